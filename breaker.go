@@ -0,0 +1,197 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by the store wrapped with WithCircuitBreaker
+// while its circuit is open. Interceptors bypass the cache on it.
+var ErrCircuitOpen = errors.New("gcache: store circuit is open")
+
+// BreakerState is the state of the circuit breaker.
+type BreakerState int
+
+// States of the circuit breaker.
+const (
+	// BreakerClosed lets the operations through to the store.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails the operations with ErrCircuitOpen
+	// without calling the store.
+	BreakerOpen
+	// BreakerHalfOpen lets a single probe operation through to the store,
+	// failing the rest with ErrCircuitOpen until the probe completes.
+	BreakerHalfOpen
+)
+
+// String returns the name of the state.
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerOption is a configuration option for the circuit breaker.
+type BreakerOption func(*breaker)
+
+// WithBreakerThreshold sets the number of consecutive failed operations,
+// after which the circuit opens, 5 by default.
+func WithBreakerThreshold(n int) BreakerOption {
+	return func(b *breaker) { b.threshold = n }
+}
+
+// WithBreakerCooldown sets the time the circuit stays open before
+// letting a probe operation through, 5 seconds by default.
+func WithBreakerCooldown(d time.Duration) BreakerOption {
+	return func(b *breaker) { b.cooldown = d }
+}
+
+// WithBreakerObserver sets the observer to report
+// the state changes of the circuit to.
+func WithBreakerObserver(o Observer) BreakerOption {
+	return func(b *breaker) { b.observer = o }
+}
+
+// WithBreakerLogger sets the logger.
+func WithBreakerLogger(l *slog.Logger) BreakerOption {
+	return func(b *breaker) { b.logger = l }
+}
+
+// WithCircuitBreaker stops calling the store once it keeps failing.
+// After the threshold of consecutive failed operations the circuit
+// opens, and the operations fail with ErrCircuitOpen right away, so that
+// the interceptors bypass the cache instead of waiting on the store.
+// Once the cooldown passes, the circuit lets a single probe operation
+// through, closing on its success, and opening again on its failure.
+//
+// Misses, ErrNotSupported, and the operations whose context is done
+// aren't failures. Combine it with WithTimeout to count the slow
+// operations as failures:
+//
+//	gcache.ChainStore(base, gcache.WithCircuitBreaker(), gcache.WithTimeout(20*time.Millisecond))
+//
+// State changes are reported to the observer as EventBreaker.
+func WithCircuitBreaker(opts ...BreakerOption) StoreMiddleware {
+	return newBreaker(opts...).middleware
+}
+
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	observer  Observer
+	logger    *slog.Logger
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int       // consecutive failures in the closed state
+	openedAt time.Time // when the circuit opened
+	probing  bool      // whether the probe is in flight in the half-open state
+}
+
+func newBreaker(opts ...BreakerOption) *breaker {
+	b := &breaker{
+		threshold: 5,
+		cooldown:  5 * time.Second,
+		observer:  nopObserver{},
+		logger:    discardLogger,
+		now:       time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+func (b *breaker) middleware(s Store) Store {
+	return wrapStore(s, middlewareStore{logger: b.logger, around: b.around})
+}
+
+// around runs the operation, if the circuit lets it through,
+// and records its outcome.
+func (b *breaker) around(ctx context.Context, op, key string, fn func(context.Context) error) error {
+	if err := b.allow(ctx, op, key); err != nil {
+		return err
+	}
+
+	err := fn(ctx)
+	b.record(ctx, op, key, err)
+	return err
+}
+
+// allow returns ErrCircuitOpen, if the circuit doesn't let the operation through.
+func (b *breaker) allow(ctx context.Context, op, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.setState(ctx, BreakerHalfOpen, op, key, nil)
+		b.probing = true
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+
+	return nil
+}
+
+// record updates the state of the circuit with the outcome of the operation.
+func (b *breaker) record(ctx context.Context, op, key string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failed := err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrNotSupported)
+
+	switch {
+	case failed && ctx.Err() != nil:
+		// the caller gave up, the store is not to blame,
+		// let the next operation probe it
+		b.probing = false
+	case b.state == BreakerClosed && failed:
+		if b.failures++; b.failures >= b.threshold {
+			b.setState(ctx, BreakerOpen, op, key, err)
+		}
+	case b.state == BreakerClosed:
+		b.failures = 0
+	case b.state == BreakerHalfOpen && failed:
+		b.setState(ctx, BreakerOpen, op, key, err)
+	case b.state == BreakerHalfOpen:
+		b.setState(ctx, BreakerClosed, op, key, nil)
+	}
+}
+
+// setState moves the circuit to the given state and reports the change,
+// err is the error of the operation that opened the circuit.
+// Must be called with the mutex held.
+func (b *breaker) setState(ctx context.Context, s BreakerState, op, key string, err error) {
+	b.state, b.failures, b.probing = s, 0, false
+	if s == BreakerOpen {
+		b.openedAt = b.now()
+	}
+
+	attrs := []any{slog.String("state", s.String()), slog.String("op", op)}
+	if err != nil {
+		attrs = append(attrs, slog.Any(ErrKey, err))
+	}
+	b.logger.WarnContext(ctx, "gcache: store circuit breaker changed its state", attrs...)
+
+	b.observer.Observe(ctx, Event{Kind: EventBreaker, Key: key, Op: op, Err: err, State: s})
+}
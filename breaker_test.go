@@ -0,0 +1,145 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	errStore := errors.New("store is down")
+
+	var events []Event
+	b := newBreaker(
+		WithBreakerThreshold(2),
+		WithBreakerCooldown(time.Minute),
+		WithBreakerObserver(ObserverFunc(func(_ context.Context, ev Event) { events = append(events, ev) })),
+	)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	base := &switchStore{StoreV2: AdaptStore(NewMemory(1 << 20))}
+	store := AdaptStore(ChainStore(storeV1{base}, b.middleware))
+
+	base.fail(errStore)
+	_, err := store.Load(ctx, "a")
+	assert.ErrorIs(t, err, errStore)
+	assert.Equal(t, BreakerClosed, b.state, "must stay closed below the threshold")
+
+	assert.ErrorIs(t, store.Save(ctx, "a", Entry{}), errStore)
+	assert.Equal(t, BreakerOpen, b.state)
+
+	calls := base.calls.Load()
+	_, err = store.Load(ctx, "a")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, calls, base.calls.Load(), "open circuit must not call the store")
+
+	now = now.Add(time.Minute)
+	_, err = store.Load(ctx, "a")
+	assert.ErrorIs(t, err, errStore, "probe must reach the store")
+	assert.Equal(t, BreakerOpen, b.state, "failed probe must open the circuit again")
+
+	_, err = store.Load(ctx, "a")
+	assert.ErrorIs(t, err, ErrCircuitOpen, "failed probe must restart the cooldown")
+
+	now = now.Add(time.Minute)
+	base.fail(nil)
+	_, err = store.Load(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound, "miss is a success")
+	assert.Equal(t, BreakerClosed, b.state)
+
+	var states []BreakerState
+	for _, ev := range events {
+		assert.Equal(t, EventBreaker, ev.Kind)
+		states = append(states, ev.State)
+	}
+	assert.Equal(t, []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}, states)
+	assert.ErrorIs(t, events[0].Err, errStore)
+	assert.Equal(t, "save", events[0].Op)
+}
+
+func TestWithCircuitBreaker_HalfOpen(t *testing.T) {
+	ctx := context.Background()
+
+	b := newBreaker(WithBreakerThreshold(1), WithBreakerCooldown(time.Minute))
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	store := AdaptStore(ChainStore(blockingStore{}, b.middleware, WithTimeout(10*time.Millisecond)))
+
+	_, err := store.Load(ctx, "a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "timeouts are failures")
+	assert.Equal(t, BreakerOpen, b.state)
+
+	now = now.Add(time.Minute)
+	probing := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := AdaptStore(ChainStore(storeV1{&notifyStore{StoreV2: blockingStore{}, called: probing}}, b.middleware)).
+			Load(ctx, "a")
+		done <- err
+	}()
+
+	<-probing
+	_, err = store.Load(ctx, "a")
+	assert.ErrorIs(t, err, ErrCircuitOpen, "only one probe must be let through")
+
+	require.ErrorIs(t, <-done, context.DeadlineExceeded)
+	assert.Equal(t, BreakerOpen, b.state, "slow probe must open the circuit again")
+}
+
+func TestWithCircuitBreaker_CallerGaveUp(t *testing.T) {
+	b := newBreaker(WithBreakerThreshold(1))
+	store := AdaptStore(ChainStore(blockingStore{}, b.middleware))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	_, err := store.Load(ctx, "a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, BreakerClosed, b.state, "caller's deadline is not a failure of the store")
+}
+
+// switchStore fails every operation with the configured error, if any,
+// and counts the calls.
+type switchStore struct {
+	StoreV2
+	err   error
+	calls atomic.Int64
+}
+
+func (s *switchStore) fail(err error) { s.err = err }
+
+func (s *switchStore) Load(ctx context.Context, key string) (Entry, error) {
+	s.calls.Add(1)
+	if s.err != nil {
+		return Entry{}, s.err
+	}
+	return s.StoreV2.Load(ctx, key)
+}
+
+func (s *switchStore) Save(ctx context.Context, key string, e Entry) error {
+	s.calls.Add(1)
+	if s.err != nil {
+		return s.err
+	}
+	return s.StoreV2.Save(ctx, key, e)
+}
+
+// notifyStore closes the channel on the first call of Load.
+type notifyStore struct {
+	StoreV2
+	called chan struct{}
+}
+
+func (s *notifyStore) Load(ctx context.Context, key string) (Entry, error) {
+	close(s.called)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	return s.StoreV2.Load(ctx, key)
+}
@@ -0,0 +1,181 @@
+package gcache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// minBackoff and maxBackoff bound the delay between the attempts
+// to restore the subscription to invalidations.
+const minBackoff, maxBackoff = 100 * time.Millisecond, 10 * time.Second
+
+// Bus delivers invalidations between the interceptors of several instances,
+// so that each of them could purge its local cache.
+type Bus interface {
+	// Publish sends the invalidation to the other subscribers.
+	Publish(ctx context.Context, inv Invalidation) error
+	// Subscribe calls fn for every invalidation published by the other
+	// instances. It blocks until the context is canceled.
+	Subscribe(ctx context.Context, fn func(context.Context, Invalidation)) error
+}
+
+// RedisBusOption is a configuration option for the redis bus.
+type RedisBusOption func(*redisBus)
+
+// WithRedisBusChannel sets the name of the pub/sub channel.
+func WithRedisBusChannel(channel string) RedisBusOption {
+	return func(b *redisBus) { b.channel = channel }
+}
+
+// WithRedisBusLogger sets the logger.
+func WithRedisBusLogger(l *slog.Logger) RedisBusOption {
+	return func(b *redisBus) { b.logger = l }
+}
+
+type redisBus struct {
+	client  redis.UniversalClient
+	channel string
+	logger  *slog.Logger
+	origin  string // to skip the messages published by the same bus
+}
+
+type redisBusMessage struct {
+	Origin string `json:"origin"`
+	Invalidation
+}
+
+// NewRedisBus returns a new Bus that delivers invalidations over the redis pub/sub channel.
+func NewRedisBus(client redis.UniversalClient, opts ...RedisBusOption) Bus {
+	origin := make([]byte, 16)
+	_, _ = rand.Read(origin)
+
+	b := &redisBus{
+		client:  client,
+		channel: "gcache:invalidations",
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		origin:  hex.EncodeToString(origin),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Publish sends the invalidation to the other subscribers.
+func (b *redisBus) Publish(ctx context.Context, inv Invalidation) error {
+	bts, err := json.Marshal(redisBusMessage{Origin: b.origin, Invalidation: inv})
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+
+	if err = b.client.Publish(ctx, b.channel, bts).Err(); err != nil {
+		return fmt.Errorf("publish to %s: %w", b.channel, err)
+	}
+
+	return nil
+}
+
+// Subscribe calls fn for every invalidation published by the other
+// instances. It blocks until the context is canceled.
+// The subscription is restored by the client after reconnects.
+func (b *redisBus) Subscribe(ctx context.Context, fn func(context.Context, Invalidation)) error {
+	return b.subscribe(ctx, fn, func() {})
+}
+
+// subscribe is Subscribe, that calls subscribed once the subscription
+// is confirmed.
+func (b *redisBus) subscribe(ctx context.Context, fn func(context.Context, Invalidation), subscribed func()) error {
+	sub := b.client.Subscribe(ctx, b.channel)
+	defer sub.Close()
+
+	// wait for the confirmation to not miss the messages
+	if _, err := sub.Receive(ctx); err != nil {
+		return fmt.Errorf("subscribe to %s: %w", b.channel, err)
+	}
+
+	subscribed()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			var m redisBusMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+				b.logger.WarnContext(ctx, "gcache: failed to unmarshal invalidation message",
+					slog.Any(ErrKey, err))
+				continue
+			}
+
+			if m.Origin == b.origin {
+				continue
+			}
+
+			fn(ctx, m.Invalidation)
+		}
+	}
+}
+
+// notifyingBus is implemented by the buses that report the moment
+// the subscription is established.
+type notifyingBus interface {
+	subscribe(ctx context.Context, fn func(context.Context, Invalidation), subscribed func()) error
+}
+
+// subscribe receives the invalidations from the bus until the context is
+// canceled, subscribing again with a backoff, if the subscription fails.
+// As the invalidations published in between are lost, the local copies
+// of the entries are flushed once the subscription is restored, or right
+// before it, if the bus doesn't report when it is established.
+func (c *Interceptor) subscribe(ctx context.Context) {
+	backoff := minBackoff
+	interrupted := false
+
+	flush := func() {
+		if interrupted {
+			c.flush(ctx, func(ctx context.Context, inv Invalidation) error {
+				return invalidateLocal(ctx, c.store, c.track(inv))
+			})
+		}
+	}
+
+	for {
+		var err error
+		if nb, ok := c.bus.(notifyingBus); ok {
+			err = nb.subscribe(ctx, c.receive, func() { backoff = minBackoff; flush() })
+		} else {
+			flush()
+			err = c.bus.Subscribe(ctx, c.receive)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		interrupted = true
+		c.logger.WarnContext(ctx, "gcache: subscription to invalidations is interrupted, subscribing again",
+			slog.Duration("backoff", backoff), slog.Any(ErrKey, err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
@@ -0,0 +1,172 @@
+package gcache
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisBus(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	ctx := context.Background()
+
+	origin := NewInterceptor(WithBus(NewRedisBus(client)))
+	replica := NewInterceptor(WithBus(NewRedisBus(client)))
+	t.Cleanup(func() {
+		require.NoError(t, origin.Close(ctx))
+		require.NoError(t, replica.Close(ctx))
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		save(t, replica, "tagged", Entry{Value: []byte("value"), Tags: []string{"order:42"}})
+		// the replica may not have subscribed yet, so we retry
+		assert.Eventually(t, func() bool {
+			require.NoError(t, origin.InvalidateTags(ctx, "order:42"))
+			_, ok := lookup(t, replica, "tagged")
+			return !ok
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("method", func(t *testing.T) {
+		save(t, replica, "/svc.Orders/List{01}", Entry{Value: []byte("value")})
+		assert.Eventually(t, func() bool {
+			require.NoError(t, origin.InvalidateMethod(ctx, "/svc.Orders/List"))
+			_, ok := lookup(t, replica, "/svc.Orders/List{01}")
+			return !ok
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("shared store is left to the publisher", func(t *testing.T) {
+		shared := &localSpyStore{StoreV2: AdaptStore(NewMemory(1 << 20))}
+		replica := NewInterceptor(WithStoreV2(shared), WithBus(NewRedisBus(client)))
+		t.Cleanup(func() { require.NoError(t, replica.Close(ctx)) })
+
+		save(t, replica, "tagged", Entry{Value: []byte("value"), Tags: []string{"order:43"}})
+		assert.Eventually(t, func() bool {
+			require.NoError(t, origin.InvalidateTags(ctx, "order:43"))
+			return len(shared.received()) > 0
+		}, time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, []string{"order:43"}, shared.received()[0].Tags)
+		_, ok := lookup(t, replica, "tagged")
+		assert.True(t, ok, "must be left to the publisher")
+	})
+
+	t.Run("own messages are skipped", func(t *testing.T) {
+		bus := NewRedisBus(client)
+		received := make(chan Invalidation, 1)
+
+		subCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			assert.NoError(t, bus.Subscribe(subCtx, func(_ context.Context, inv Invalidation) { received <- inv }))
+		}()
+
+		require.NoError(t, bus.Publish(ctx, Invalidation{Keys: []string{"key"}}))
+		require.NoError(t, NewRedisBus(client).Publish(ctx, Invalidation{Keys: []string{"other"}}))
+
+		// the subscription may not be ready yet, so we wait for the
+		// message of another bus and check that no others arrived before
+		for {
+			select {
+			case inv := <-received:
+				if assert.Equal(t, []string{"other"}, inv.Keys) {
+					cancel()
+					<-done
+					return
+				}
+			case <-time.After(10 * time.Millisecond):
+				require.NoError(t, NewRedisBus(client).Publish(ctx, Invalidation{Keys: []string{"other"}}))
+			}
+		}
+	})
+}
+
+func TestRedisBus_Resubscribe(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	ctx := context.Background()
+
+	mr.Close() // redis is down at startup
+	logs := &syncBuffer{}
+	replica := NewInterceptor(WithBus(NewRedisBus(client)), WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+	t.Cleanup(func() { require.NoError(t, replica.Close(ctx)) })
+
+	save(t, replica, "/svc.Orders/Get{01}", Entry{Value: []byte("value")})
+	assert.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), "subscription to invalidations is interrupted")
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, mr.Restart())
+
+	// invalidations published before the subscription are lost, so that
+	// the local cache is flushed once the replica is subscribed
+	assert.Eventually(t, func() bool {
+		_, ok := lookup(t, replica, "/svc.Orders/Get{01}")
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	origin := NewInterceptor(WithBus(NewRedisBus(client)))
+	t.Cleanup(func() { require.NoError(t, origin.Close(ctx)) })
+
+	save(t, replica, "tagged", Entry{Value: []byte("value"), Tags: []string{"order:42"}})
+	require.NoError(t, origin.InvalidateTags(ctx, "order:42"))
+	assert.Eventually(t, func() bool {
+		_, ok := lookup(t, replica, "tagged")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+// localSpyStore is a shared store that records the invalidations
+// of its local copies.
+type localSpyStore struct {
+	StoreV2
+	mu  sync.Mutex
+	inv []Invalidation
+}
+
+func (s *localSpyStore) InvalidateLocal(_ context.Context, inv Invalidation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inv = append(s.inv, inv)
+	return nil
+}
+
+func (s *localSpyStore) received() []Invalidation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.inv)
+}
+
+// syncBuffer is a buffer safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
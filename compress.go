@@ -0,0 +1,240 @@
+package gcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is the compression algorithm of the entry values,
+// its value is the header byte of the compressed values.
+type Compression byte
+
+// Compression algorithms.
+const (
+	// CompressionZstd is Zstandard, it gives the best ratio.
+	CompressionZstd Compression = iota + 1
+	// CompressionS2 is S2, an extension of Snappy, it is the fastest one.
+	CompressionS2
+	// CompressionGzip is gzip from the standard library, for the values
+	// shared with the readers that have nothing else.
+	CompressionGzip
+)
+
+// compressHeaderRaw is the header byte of the uncompressed value,
+// which would otherwise be taken for a compressed one.
+const compressHeaderRaw = 0
+
+// maxCompressHeader is the largest header byte. Neither protobuf messages,
+// nor JSON documents start with these bytes, so the values written
+// without the compressing store are read as they are.
+const maxCompressHeader = 0x07
+
+// maxDecompressedSize limits the size of the decompressed value, so that
+// a corrupt or malicious value doesn't make the reader allocate the world.
+const maxDecompressedSize = maxRecordSize
+
+// String returns the name of the algorithm.
+func (c Compression) String() string {
+	switch c {
+	case CompressionZstd:
+		return "zstd"
+	case CompressionS2:
+		return "s2"
+	case CompressionGzip:
+		return "gzip"
+	default:
+		return "unknown"
+	}
+}
+
+// CompressionOption is a configuration option for the compressing store.
+type CompressionOption func(*compressor)
+
+// WithCompression sets the compression algorithm, CompressionZstd by default.
+func WithCompression(algo Compression) CompressionOption {
+	return func(c *compressor) { c.algo = algo }
+}
+
+// WithCompressionThreshold sets the size of the value in bytes,
+// starting from which the values are compressed, 1 KiB by default.
+func WithCompressionThreshold(n int) CompressionOption {
+	return func(c *compressor) { c.threshold = n }
+}
+
+// WithCompressionObserver sets the observer to report
+// the sizes of the compressed values to.
+func WithCompressionObserver(o Observer) CompressionOption {
+	return func(c *compressor) { c.observer = o }
+}
+
+// WithCompressionLogger sets the logger.
+func WithCompressionLogger(l *slog.Logger) CompressionOption {
+	return func(c *compressor) { c.logger = l }
+}
+
+type compressor struct {
+	algo      Compression
+	threshold int
+	observer  Observer
+	logger    *slog.Logger
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0),
+		zstd.WithDecoderMaxMemory(maxDecompressedSize))
+)
+
+// NewCompressed makes a store that compresses the values of the entries
+// not shorter than the threshold before saving them to the given store.
+// Compressed values start with the header byte of their algorithm, values
+// of any algorithm are decompressed regardless of the configured one.
+// Values below the threshold, and the ones that don't shrink, are saved
+// as they are, so the store reads the entries saved without it, unless
+// their values start with one of the header bytes, 0x00 to 0x07, which
+// never happens to protobuf messages and JSON documents.
+//
+// The sizes of the compressed values are reported to the observer
+// as EventCompress, with Size and RawSize of the value, to track
+// the compression ratio. Tags, prefixes, snapshots, batches and
+// CompareAndSwap are passed through to the given store.
+func NewCompressed(store Store, opts ...CompressionOption) Store {
+	c := &compressor{
+		algo:      CompressionZstd,
+		threshold: 1024,
+		observer:  nopObserver{},
+		logger:    discardLogger,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return wrapStore(store, middlewareStore{logger: c.logger, encode: c.encode, decode: c.decode})
+}
+
+// encode compresses the value of the entry.
+func (c *compressor) encode(ctx context.Context, key string, e Entry) (Entry, error) {
+	var err error
+	if e.Value, err = c.compress(ctx, key, e.Value); err != nil {
+		return Entry{}, fmt.Errorf("compress %s: %w", key, err)
+	}
+	return e, nil
+}
+
+// decode decompresses the value of the entry.
+func (c *compressor) decode(_ context.Context, key string, e Entry) (Entry, error) {
+	var err error
+	if e.Value, err = decompress(e.Value); err != nil {
+		return Entry{}, fmt.Errorf("decompress %s: %w", key, err)
+	}
+	return e, nil
+}
+
+// compress returns the value compressed with the configured algorithm and
+// prefixed with its header byte, or the value as is, if it is below the
+// threshold or doesn't shrink.
+func (c *compressor) compress(ctx context.Context, key string, value []byte) ([]byte, error) {
+	if len(value) < c.threshold {
+		return escapeRaw(value), nil
+	}
+
+	compressed := []byte{byte(c.algo)}
+	switch c.algo {
+	case CompressionZstd:
+		compressed = zstdEncoder.EncodeAll(value, compressed)
+	case CompressionS2:
+		compressed = append(compressed, s2.Encode(nil, value)...)
+	case CompressionGzip:
+		buf := bytes.NewBuffer(compressed)
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		if err := w.Close(); err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		compressed = buf.Bytes()
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %d", c.algo)
+	}
+
+	if len(compressed) >= len(value) {
+		return escapeRaw(value), nil
+	}
+
+	c.observer.Observe(ctx, Event{
+		Kind:    EventCompress,
+		Key:     key,
+		Size:    int64(len(compressed)),
+		RawSize: int64(len(value)),
+	})
+
+	return compressed, nil
+}
+
+// escapeRaw prefixes the uncompressed value with the raw header byte,
+// if it starts with a header byte.
+func escapeRaw(value []byte) []byte {
+	if len(value) == 0 || value[0] > maxCompressHeader {
+		return value
+	}
+	return append([]byte{compressHeaderRaw}, value...)
+}
+
+// decompress returns the value without its header byte, decompressed
+// with the algorithm of the header. Values that don't start with a header
+// byte are returned as they are.
+func decompress(value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] > maxCompressHeader {
+		return value, nil
+	}
+
+	header, body := value[0], value[1:]
+	switch Compression(header) {
+	case compressHeaderRaw:
+		return body, nil
+	case CompressionZstd:
+		res, err := zstdDecoder.DecodeAll(body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return res, nil
+	case CompressionS2:
+		n, err := s2.DecodedLen(body)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("s2: %w", err)
+		case n > maxDecompressedSize:
+			return nil, fmt.Errorf("s2: value of %d bytes is too large", n)
+		}
+		res, err := s2.Decode(nil, body)
+		if err != nil {
+			return nil, fmt.Errorf("s2: %w", err)
+		}
+		return res, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		res, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("gzip: %w", err)
+		case len(res) > maxDecompressedSize:
+			return nil, errors.New("gzip: value is too large")
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unknown header byte %#x", header)
+	}
+}
@@ -0,0 +1,117 @@
+package gcache
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressedStore(t *testing.T) {
+	ctx := context.Background()
+	large := bytes.Repeat([]byte(`{"id": "42", "status": "shipped"}`), 100)
+
+	for _, algo := range []Compression{CompressionZstd, CompressionS2, CompressionGzip} {
+		t.Run(algo.String(), func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				events []Event
+			)
+			inner := NewMemory(1 << 20)
+			store := NewCompressed(inner, WithCompression(algo), WithCompressionThreshold(64),
+				WithCompressionObserver(ObserverFunc(func(_ context.Context, ev Event) {
+					mu.Lock()
+					defer mu.Unlock()
+					events = append(events, ev)
+				}))).(*middlewareStore)
+
+			require.NoError(t, store.Save(ctx, "large", Entry{Value: large, ETag: "v1", Tags: []string{"t"}}))
+			require.NoError(t, store.Save(ctx, "small", Entry{Value: []byte("small")}))
+
+			raw, err := AdaptStore(inner).Load(ctx, "large")
+			require.NoError(t, err)
+			assert.Equal(t, byte(algo), raw.Value[0])
+			assert.Less(t, len(raw.Value), len(large)/4)
+
+			raw, err = AdaptStore(inner).Load(ctx, "small")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("small"), raw.Value, "small values are stored as they are")
+
+			e, err := store.Load(ctx, "large")
+			require.NoError(t, err)
+			assert.Equal(t, Entry{Value: large, ETag: "v1", Tags: []string{"t"}}, e)
+
+			e, err = store.Load(ctx, "small")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("small"), e.Value)
+
+			raw, err = AdaptStore(inner).Load(ctx, "large")
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			assert.Equal(t, Event{Kind: EventCompress, Key: "large", Size: int64(len(raw.Value)), RawSize: int64(len(large))}, events[0])
+
+			require.NoError(t, store.InvalidateTags(ctx, "t"))
+			_, err = store.Load(ctx, "large")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+
+	t.Run("coexistence", func(t *testing.T) {
+		inner := NewMemory(1 << 20)
+		zstd := NewCompressed(inner, WithCompressionThreshold(1)).(*middlewareStore)
+		s2 := NewCompressed(inner, WithCompression(CompressionS2), WithCompressionThreshold(1)).(*middlewareStore)
+
+		inner.Set(ctx, "legacy", Entry{Value: large})
+		require.NoError(t, s2.Save(ctx, "s2", Entry{Value: large}))
+		require.NoError(t, zstd.Save(ctx, "header", Entry{Value: []byte{0x01, 0x02}}))
+
+		for _, key := range []string{"legacy", "s2"} {
+			e, err := zstd.Load(ctx, key)
+			require.NoError(t, err, key)
+			assert.Equal(t, large, e.Value, key)
+		}
+
+		e, err := zstd.Load(ctx, "header")
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x01, 0x02}, e.Value, "values starting with a header byte are escaped")
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		inner := NewMemory(1 << 20)
+		store := NewCompressed(inner).(*middlewareStore)
+
+		inner.Set(ctx, "key", Entry{Value: []byte{byte(CompressionZstd), 'x'}})
+		_, err := store.Load(ctx, "key")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		inner := &batchStore{Store: NewMemory(1 << 20)}
+		store := NewCompressed(inner, WithCompressionThreshold(1)).(*middlewareStore)
+
+		require.NoError(t, store.SetMulti(ctx, map[string]Entry{"a": {Value: large}, "b": {Value: []byte("b")}}))
+		entries, err := store.GetMulti(ctx, []string{"a", "b", "c"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]Entry{"a": {Value: large}, "b": {Value: []byte("b")}}, entries)
+
+		require.NoError(t, store.RemoveMulti(ctx, []string{"a", "b"}))
+		assert.Equal(t, [][]string{{"a", "b"}}, inner.removed)
+
+		_, err = store.Load(ctx, "a")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, store.Range(ctx, func(string, Entry) bool { return true }), ErrNotSupported)
+
+		store = NewCompressed(NewMemory(1<<20), WithCompressionThreshold(1)).(*middlewareStore)
+		require.NoError(t, store.Save(ctx, "a", Entry{Value: large}))
+		ranged := map[string]Entry{}
+		require.NoError(t, store.Range(ctx, func(key string, e Entry) bool {
+			ranged[key] = e
+			return true
+		}))
+		assert.Equal(t, map[string]Entry{"a": {Value: large}}, ranged)
+	})
+}
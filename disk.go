@@ -0,0 +1,508 @@
+package gcache
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskOption is a configuration option for the disk store.
+type DiskOption func(*diskStore)
+
+// WithDiskLogger sets the logger.
+func WithDiskLogger(l *slog.Logger) DiskOption {
+	return func(d *diskStore) { d.logger = l }
+}
+
+// WithDiskObserver sets the observer to report evictions to.
+func WithDiskObserver(o Observer) DiskOption {
+	return func(d *diskStore) { d.observer = o }
+}
+
+// diskTempPrefix is the prefix of the files being written.
+const diskTempPrefix = ".tmp-"
+
+// diskHealthPrefix is the prefix of the files probing the health of the
+// store, distinct enough to tell them apart from the temporary files of
+// the others in the directory.
+const diskHealthPrefix = diskTempPrefix + "gcache-health-"
+
+type diskStore struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	items map[string]*list.Element // key -> *diskItem
+	lru   *list.List               // front is the most recently used
+	size  int64
+	index tagIndex
+
+	logger   *slog.Logger
+	observer Observer
+	now      func() time.Time
+}
+
+type diskItem struct {
+	key       string
+	size      int64
+	expiresAt time.Time
+	accessed  time.Time
+}
+
+// NewDisk makes a store that keeps every entry in its own file under
+// the given directory, bounded by the total size of the files in bytes,
+// evicting the least recently used entries. Entries are written to
+// temporary files and renamed into place, so that a crash never leaves
+// a partially written entry. On start, the store recovers its index
+// from its files in the directory, dropping the leftovers of interrupted
+// writes, the corrupt and the expired entries, other files are left
+// intact. Access time is kept as the
+// modification time of the file, so the eviction order survives restarts.
+//
+// The store uses only the standard library and is meant for the
+// client-side caches of command-line tools and agents. Processes
+// may share the directory, but each of them bounds only its own view.
+func NewDisk(dir string, maxBytes int64, opts ...DiskOption) (Store, error) {
+	d := &diskStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		items:    map[string]*list.Element{},
+		lru:      list.New(),
+		logger:   discardLogger,
+		observer: nopObserver{},
+		now:      time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("make cache directory: %w", err)
+	}
+
+	if err := d.recover(); err != nil {
+		return nil, fmt.Errorf("recover cache directory: %w", err)
+	}
+
+	return d, nil
+}
+
+// Get returns the value for the given key.
+func (d *diskStore) Get(ctx context.Context, key string) (Entry, bool) {
+	return legacyStore{v2: d, logger: d.logger}.Get(ctx, key)
+}
+
+// Set sets the value for the given key.
+func (d *diskStore) Set(ctx context.Context, key string, e Entry) {
+	legacyStore{v2: d, logger: d.logger}.Set(ctx, key, e)
+}
+
+// Remove removes the value for the given key.
+func (d *diskStore) Remove(ctx context.Context, key string) {
+	legacyStore{v2: d, logger: d.logger}.Remove(ctx, key)
+}
+
+// Load returns the value for the given key, or ErrNotFound.
+func (d *diskStore) Load(ctx context.Context, key string) (Entry, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	el, ok := d.items[key]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+
+	it := el.Value.(*diskItem)
+	now := d.now()
+	if !it.expiresAt.IsZero() && !now.Before(it.expiresAt) {
+		return Entry{}, d.notFound(el)
+	}
+
+	bts, err := os.ReadFile(d.path(key))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		// removed by another process
+		return Entry{}, d.notFound(el)
+	case err != nil:
+		return Entry{}, fmt.Errorf("read %s: %w", key, err)
+	}
+
+	storedKey, e, err := readRecord(bufio.NewReader(bytes.NewReader(bts)))
+	if err != nil || storedKey != key {
+		d.logger.WarnContext(ctx, "gcache: dropping corrupt entry from the disk store",
+			slog.String("key", key), slog.Any(ErrKey, err))
+		return Entry{}, d.notFound(el)
+	}
+
+	it.accessed = now
+	d.lru.MoveToFront(el)
+	if err = os.Chtimes(d.path(key), time.Time{}, now); err != nil {
+		d.logger.WarnContext(ctx, "gcache: failed to update access time of the entry",
+			slog.String("key", key), slog.Any(ErrKey, err))
+	}
+
+	return e, nil
+}
+
+// Save sets the value for the given key, evicting other entries
+// if the budget is exceeded.
+func (d *diskStore) Save(ctx context.Context, key string, e Entry) error {
+	if e.expired(d.now()) {
+		return d.Delete(ctx, key)
+	}
+
+	rec := appendRecord(nil, key, e)
+	it := &diskItem{key: key, size: int64(len(rec)), expiresAt: e.ExpiresAt}
+
+	d.mu.Lock()
+
+	if it.size > d.maxBytes {
+		d.mu.Unlock()
+		d.observer.Observe(ctx, Event{Kind: EventEvict, Key: key, Size: it.size})
+		return d.Delete(ctx, key)
+	}
+
+	if err := d.write(key, rec); err != nil {
+		d.mu.Unlock()
+		return fmt.Errorf("write %s: %w", key, err)
+	}
+
+	if el, ok := d.items[key]; ok {
+		d.drop(el)
+	}
+
+	// the access time is kept in the modification time of the file,
+	// set explicitly, as the file system one is too coarse to tell
+	// the order of the writes on recover
+	it.accessed = d.now()
+	if err := os.Chtimes(d.path(key), time.Time{}, it.accessed); err != nil {
+		d.logger.WarnContext(ctx, "gcache: failed to update access time of the entry",
+			slog.String("key", key), slog.Any(ErrKey, err))
+	}
+
+	d.items[key] = d.lru.PushFront(it)
+	d.size += it.size
+	d.index.add(key, e.Tags)
+
+	evicted, err := d.evict()
+
+	d.mu.Unlock()
+
+	for _, victim := range evicted {
+		d.observer.Observe(ctx, Event{Kind: EventEvict, Key: victim.key, Size: victim.size})
+	}
+
+	return err
+}
+
+// Delete removes the value for the given key.
+func (d *diskStore) Delete(_ context.Context, key string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if el, ok := d.items[key]; ok {
+		return d.delete(el)
+	}
+
+	// the entry might be written by another process
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// InvalidateTags removes all entries that carry any of the given tags.
+func (d *diskStore) InvalidateTags(_ context.Context, tags ...string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var errs []error
+	for _, key := range d.index.take(tags...) {
+		if el, ok := d.items[key]; ok {
+			errs = append(errs, d.delete(el))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// RemovePrefix removes all entries whose keys start with the given prefix.
+func (d *diskStore) RemovePrefix(_ context.Context, prefix string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var errs []error
+	for key, el := range d.items {
+		if strings.HasPrefix(key, prefix) {
+			errs = append(errs, d.delete(el))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckHealth checks that a file can be written to the directory.
+func (d *diskStore) CheckHealth(context.Context) error {
+	f, err := os.CreateTemp(d.dir, diskHealthPrefix)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+
+	return errors.Join(f.Close(), os.Remove(f.Name()))
+}
+
+// path returns the path of the file of the entry. Files are spread
+// across the subdirectories by the first byte of the key hash.
+func (d *diskStore) path(key string) string {
+	name := hex.EncodeToString(hash([]byte(key)))
+	return filepath.Join(d.dir, name[:2], name)
+}
+
+// write atomically replaces the file of the entry with the record,
+// syncing the directories, so that the entry survives a crash.
+func (d *diskStore) write(key string, rec []byte) (err error) {
+	path := d.path(key)
+	dir := filepath.Dir(path)
+
+	switch err = os.Mkdir(dir, 0o700); {
+	case err == nil:
+		if err = syncDir(d.dir); err != nil {
+			return fmt.Errorf("sync cache directory: %w", err)
+		}
+	case !errors.Is(err, fs.ErrExist):
+		return fmt.Errorf("make directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, diskTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(rec); err != nil {
+		return fmt.Errorf("write temporary file: %w", err)
+	}
+
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("sync temporary file: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
+	}
+
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("rename temporary file: %w", err)
+	}
+
+	if err = syncDir(dir); err != nil {
+		return fmt.Errorf("sync directory: %w", err)
+	}
+
+	return nil
+}
+
+// syncDir flushes the directory, so that the files renamed into it
+// survive a crash. Windows doesn't allow syncing directories.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	f, err := os.Open(dir) //nolint:gosec // dir is the cache directory
+	if err != nil {
+		return fmt.Errorf("open %s: %w", dir, err)
+	}
+
+	return errors.Join(f.Sync(), f.Close())
+}
+
+// evict removes the least recently used entries, until the store
+// fits the budget. Must be called under the lock.
+func (d *diskStore) evict() (evicted []*diskItem, err error) {
+	var errs []error
+	for d.size > d.maxBytes && d.lru.Len() > 0 {
+		el := d.lru.Back()
+		evicted = append(evicted, el.Value.(*diskItem))
+		errs = append(errs, d.delete(el))
+	}
+	return evicted, errors.Join(errs...)
+}
+
+// notFound drops the entry that is known to be gone and returns ErrNotFound.
+// Must be called under the lock.
+func (d *diskStore) notFound(el *list.Element) error {
+	if err := d.delete(el); err != nil {
+		d.logger.Warn("gcache: failed to remove the entry from the disk store", slog.Any(ErrKey, err))
+	}
+	return ErrNotFound
+}
+
+// delete removes the entry and its file. Must be called under the lock.
+func (d *diskStore) delete(el *list.Element) error {
+	key := el.Value.(*diskItem).key
+	d.drop(el)
+
+	if err := os.Remove(d.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// drop removes the entry from the index. Must be called under the lock.
+func (d *diskStore) drop(el *list.Element) {
+	it := el.Value.(*diskItem)
+	d.lru.Remove(el)
+	delete(d.items, it.key)
+	d.size -= it.size
+	d.index.remove(it.key)
+}
+
+// recover rebuilds the index from the files in the directory. Only the
+// store's own layout is touched: the subdirectories named by the first
+// byte of the key hash, holding the files named by the whole hash, and
+// the leftovers of interrupted writes and health checks. Anything else
+// is skipped.
+func (d *diskStore) recover() error {
+	var items []*diskItem
+	tags := map[string][]string{}
+
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", d.dir, err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(d.dir, entry.Name())
+		switch {
+		case !entry.IsDir() && strings.HasPrefix(entry.Name(), diskHealthPrefix):
+			// leftover of an interrupted health check
+			err = d.removeFile(path)
+		case entry.IsDir() && isHex(entry.Name(), 2):
+			err = d.recoverDir(path, func(it *diskItem, e Entry) {
+				items = append(items, it)
+				tags[it.key] = e.Tags
+			})
+		default:
+			d.logger.Warn("gcache: skipping foreign file in the disk store directory", slog.String("path", path))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	slices.SortFunc(items, func(a, b *diskItem) int { return a.accessed.Compare(b.accessed) })
+	for _, it := range items {
+		d.items[it.key] = d.lru.PushFront(it)
+		d.size += it.size
+		d.index.add(it.key, tags[it.key])
+	}
+
+	_, err = d.evict()
+	return err
+}
+
+// recoverDir calls add for every entry in the subdirectory, dropping the
+// leftovers of interrupted writes, the corrupt and the expired entries.
+func (d *diskStore) recoverDir(dir string, add func(*diskItem, Entry)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", dir, err)
+	}
+
+	now := d.now()
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		switch {
+		case !entry.IsDir() && strings.HasPrefix(entry.Name(), diskTempPrefix):
+			// leftover of an interrupted write
+			if err = d.removeFile(path); err != nil {
+				return err
+			}
+			continue
+		case entry.IsDir() || !isHex(entry.Name(), 2*len(hash(nil))) || !strings.HasPrefix(entry.Name(), filepath.Base(dir)):
+			d.logger.Warn("gcache: skipping foreign file in the disk store directory", slog.String("path", path))
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", path, err)
+		}
+
+		if info.Size() > d.maxBytes {
+			// written by a process with a larger budget
+			continue
+		}
+
+		bts, err := os.ReadFile(path) //nolint:gosec // path is within the cache directory
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+
+		key, e, err := readRecord(bufio.NewReader(bytes.NewReader(bts)))
+		if err != nil || d.path(key) != path {
+			d.logger.Warn("gcache: dropping corrupt file from the disk store",
+				slog.String("path", path), slog.Any(ErrKey, err))
+			if err = d.removeFile(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if e.expired(now) {
+			if err = d.removeFile(path); err != nil {
+				return err
+			}
+			continue
+		}
+
+		add(&diskItem{key: key, size: int64(len(bts)), expiresAt: e.ExpiresAt, accessed: info.ModTime()}, e)
+	}
+
+	return nil
+}
+
+// isHex reports whether the name consists of n lowercase hex digits.
+func isHex(name string, n int) bool {
+	if len(name) != n {
+		return false
+	}
+
+	for _, c := range name {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (d *diskStore) removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove %s: %w", path, err)
+	}
+	return nil
+}
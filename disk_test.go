@@ -0,0 +1,208 @@
+package gcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	entries := map[string]Entry{
+		"full": {
+			Value:     []byte("value"),
+			ETag:      "etag",
+			Tags:      []string{"a", "b"},
+			ExpiresAt: time.Unix(0, time.Now().UnixNano()),
+			Cost:      time.Millisecond,
+		},
+		"empty": {},
+	}
+
+	var buf []byte
+	for key, e := range entries {
+		buf = appendRecord(buf, key, e)
+	}
+
+	r := bufio.NewReader(bytes.NewReader(buf))
+	for range entries {
+		key, e, err := readRecord(r)
+		require.NoError(t, err)
+		assert.True(t, entries[key].ExpiresAt.Equal(e.ExpiresAt))
+		e.ExpiresAt = entries[key].ExpiresAt
+		assert.Equal(t, entries[key], e)
+	}
+
+	_, _, err := readRecord(r)
+	assert.ErrorIs(t, err, io.EOF)
+
+	t.Run("corrupt", func(t *testing.T) {
+		rec := appendRecord(nil, "key", Entry{Value: []byte("value")})
+
+		flipped := bytes.Clone(rec)
+		flipped[5] ^= 0xff
+		_, _, err = readRecord(bufio.NewReader(bytes.NewReader(flipped)))
+		assert.ErrorIs(t, err, ErrCorruptRecord)
+
+		_, _, err = readRecord(bufio.NewReader(bytes.NewReader(rec[:len(rec)-1])))
+		assert.ErrorIs(t, err, ErrCorruptRecord)
+	})
+}
+
+func TestDiskStore(t *testing.T) {
+	ctx := context.Background()
+	recSize := int64(len(appendRecord(nil, "a", Entry{Value: []byte(strings.Repeat("x", 100))})))
+	value := []byte(strings.Repeat("x", 100))
+
+	t.Run("survives restarts", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store, err := NewDisk(dir, 1<<20)
+		require.NoError(t, err)
+		require.NoError(t, store.(StoreV2).Save(ctx, "/svc.Orders/Get{01}",
+			Entry{Value: []byte("order"), ETag: "v1", Tags: []string{"order:1"}}))
+
+		store, err = NewDisk(dir, 1<<20)
+		require.NoError(t, err)
+
+		e, err := store.(StoreV2).Load(ctx, "/svc.Orders/Get{01}")
+		require.NoError(t, err)
+		assert.Equal(t, Entry{Value: []byte("order"), ETag: "v1", Tags: []string{"order:1"}}, e)
+
+		require.NoError(t, store.(TagInvalidator).InvalidateTags(ctx, "order:1"))
+		_, err = store.(StoreV2).Load(ctx, "/svc.Orders/Get{01}")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		store, err = NewDisk(dir, 1<<20)
+		require.NoError(t, err)
+		_, err = store.(StoreV2).Load(ctx, "/svc.Orders/Get{01}")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		dir := t.TempDir()
+		now := time.Now()
+
+		var evicted []string
+		store, err := NewDisk(dir, 3*recSize, WithDiskObserver(ObserverFunc(func(_ context.Context, ev Event) {
+			assert.Equal(t, EventEvict, ev.Kind)
+			evicted = append(evicted, ev.Key)
+		})))
+		require.NoError(t, err)
+		store.(*diskStore).now = func() time.Time { now = now.Add(time.Second); return now }
+
+		store.Set(ctx, "a", Entry{Value: value})
+		store.Set(ctx, "b", Entry{Value: value})
+		store.Set(ctx, "c", Entry{Value: value})
+		_, ok := store.Get(ctx, "a")
+		require.True(t, ok)
+
+		store.Set(ctx, "d", Entry{Value: value})
+		assert.Equal(t, []string{"b"}, evicted)
+		assert.Equal(t, 3*recSize, store.(*diskStore).size)
+
+		// the access order is restored from the files
+		store, err = NewDisk(dir, 2*recSize)
+		require.NoError(t, err)
+		for key, present := range map[string]bool{"a": true, "b": false, "c": false, "d": true} {
+			_, ok = store.Get(ctx, key)
+			assert.Equal(t, present, ok, key)
+		}
+
+		_, err = os.Stat(store.(*diskStore).path("c"))
+		assert.ErrorIs(t, err, os.ErrNotExist, "evicted entry's file must be removed")
+	})
+
+	t.Run("recovers from crashes", func(t *testing.T) {
+		dir := t.TempDir()
+
+		store, err := NewDisk(dir, 1<<20)
+		require.NoError(t, err)
+		store.Set(ctx, "good", Entry{Value: value})
+		store.Set(ctx, "truncated", Entry{Value: value})
+		store.Set(ctx, "expiring", Entry{Value: value, ExpiresAt: time.Now().Add(50 * time.Millisecond)})
+
+		ds := store.(*diskStore)
+		require.NoError(t, os.Truncate(ds.path("truncated"), 10))
+		leftovers := []string{
+			filepath.Join(filepath.Dir(ds.path("good")), diskTempPrefix+"123"),
+			filepath.Join(dir, diskHealthPrefix+"123"),
+		}
+		for _, path := range leftovers {
+			require.NoError(t, os.WriteFile(path, []byte("partial"), 0o600))
+		}
+		foreign := []string{
+			filepath.Join(dir, "garbage"),
+			filepath.Join(dir, ".tmp-x"),
+			filepath.Join(dir, "docs", "notes.txt"),
+			filepath.Join(filepath.Dir(ds.path("good")), "notes.txt"),
+			filepath.Join(filepath.Dir(ds.path("good")), strings.Repeat("f", 40)),
+		}
+		for _, path := range foreign {
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+			require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o600))
+		}
+
+		time.Sleep(100 * time.Millisecond)
+
+		store, err = NewDisk(dir, 1<<20)
+		require.NoError(t, err)
+
+		_, ok := store.Get(ctx, "good")
+		assert.True(t, ok)
+		_, ok = store.Get(ctx, "truncated")
+		assert.False(t, ok)
+		_, ok = store.Get(ctx, "expiring")
+		assert.False(t, ok)
+
+		for _, path := range append(leftovers, ds.path("truncated"), ds.path("expiring")) {
+			_, err = os.Stat(path)
+			assert.ErrorIs(t, err, os.ErrNotExist, path)
+		}
+		for _, path := range foreign {
+			_, err = os.Stat(path)
+			assert.NoError(t, err, "foreign file %s must be kept", path)
+		}
+		assert.Equal(t, recSize+int64(len("good"))-1, store.(*diskStore).size)
+	})
+
+	t.Run("prefixes and removal", func(t *testing.T) {
+		store, err := NewDisk(t.TempDir(), 1<<20)
+		require.NoError(t, err)
+
+		store.Set(ctx, "/svc.Orders/Get{01}", Entry{Value: value})
+		store.Set(ctx, "/svc.Orders/List{01}", Entry{Value: value})
+		store.Set(ctx, "oversized", Entry{Value: make([]byte, 2<<20)})
+
+		require.NoError(t, store.(PrefixRemover).RemovePrefix(ctx, "/svc.Orders/List{"))
+		store.Remove(ctx, "/svc.Orders/Get{01}")
+
+		for _, key := range []string{"/svc.Orders/Get{01}", "/svc.Orders/List{01}", "oversized"} {
+			_, ok := store.Get(ctx, key)
+			assert.False(t, ok, key)
+		}
+		assert.Zero(t, store.(*diskStore).size)
+	})
+
+	t.Run("health", func(t *testing.T) {
+		dir := t.TempDir()
+		store, err := NewDisk(dir, 1<<20)
+		require.NoError(t, err)
+
+		require.NoError(t, store.(HealthChecker).CheckHealth(ctx))
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries, "must not leave files behind")
+
+		require.NoError(t, os.RemoveAll(dir))
+		assert.Error(t, store.(HealthChecker).CheckHealth(ctx))
+	})
+}
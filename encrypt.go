@@ -0,0 +1,145 @@
+package gcache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// EncryptionKey is the AES key of the encrypting store with its ID,
+// which is stored along with the encrypted values.
+type EncryptionKey struct {
+	ID  string
+	Key []byte // 16, 24 or 32 bytes for AES-128, AES-192 or AES-256
+}
+
+// EncryptionOption is a configuration option for the encrypting store.
+type EncryptionOption func(*encryptor)
+
+// WithEncryptionLogger sets the logger.
+func WithEncryptionLogger(l *slog.Logger) EncryptionOption {
+	return func(c *encryptor) { c.logger = l }
+}
+
+// errDecrypt is returned when the value can't be decrypted.
+var errDecrypt = errors.New("entry failed to decrypt")
+
+type encryptor struct {
+	primary string
+	aeads   map[string]cipher.AEAD // by key ID
+	logger  *slog.Logger
+}
+
+// NewEncrypted makes a store that encrypts the values of the entries
+// with AES-GCM before saving them to the given store. The values are
+// encrypted with the first key, and decrypted with the key of the ID
+// stored along with the value, so the keys are rotated by putting the
+// new key first and keeping the old ones until their entries expire.
+// The cache key is authenticated along with the value, so the entry
+// copied under a different key fails to decrypt. Entries that fail
+// to decrypt, or whose key is unknown, are treated as misses and removed.
+//
+// Only values are encrypted, ETags and tags are kept as they are.
+// Tags, prefixes, snapshots, batches and CompareAndSwap are passed
+// through to the given store.
+func NewEncrypted(store Store, keys []EncryptionKey, opts ...EncryptionOption) (Store, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no encryption keys")
+	}
+
+	c := &encryptor{
+		primary: keys[0].ID,
+		aeads:   make(map[string]cipher.AEAD, len(keys)),
+		logger:  discardLogger,
+	}
+
+	for _, k := range keys {
+		if _, ok := c.aeads[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate encryption key %q", k.ID)
+		}
+
+		block, err := aes.NewCipher(k.Key)
+		if err != nil {
+			return nil, fmt.Errorf("make cipher of key %q: %w", k.ID, err)
+		}
+
+		if c.aeads[k.ID], err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("make GCM of key %q: %w", k.ID, err)
+		}
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return wrapStore(store, middlewareStore{logger: c.logger, encode: c.encode, decode: c.decode, drop: true}), nil
+}
+
+// encode encrypts the value of the entry.
+func (c *encryptor) encode(_ context.Context, key string, e Entry) (Entry, error) {
+	var err error
+	if e.Value, err = c.encrypt(key, e.Value); err != nil {
+		return Entry{}, fmt.Errorf("encrypt %s: %w", key, err)
+	}
+	return e, nil
+}
+
+// decode decrypts the value of the entry, the entries
+// that fail to decrypt are misses.
+func (c *encryptor) decode(_ context.Context, key string, e Entry) (Entry, error) {
+	var err error
+	if e.Value, err = c.decrypt(key, e.Value); err != nil {
+		return Entry{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return e, nil
+}
+
+// encrypt seals the value with the primary key, authenticating the cache key.
+// The envelope is
+//
+//	uvarint len | key ID | nonce | sealed value
+func (c *encryptor) encrypt(key string, value []byte) ([]byte, error) {
+	aead := c.aeads[c.primary]
+
+	buf := binary.AppendUvarint(nil, uint64(len(c.primary)))
+	buf = append(buf, c.primary...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("read nonce: %w", err)
+	}
+	buf = append(buf, nonce...)
+
+	return aead.Seal(buf, nonce, value, []byte(key)), nil
+}
+
+// decrypt opens the envelope made by encrypt.
+func (c *encryptor) decrypt(key string, envelope []byte) ([]byte, error) {
+	n, read := binary.Uvarint(envelope)
+	if read <= 0 || n > uint64(len(envelope)-read) {
+		return nil, fmt.Errorf("%w: malformed envelope", errDecrypt)
+	}
+	id, rest := string(envelope[read:read+int(n)]), envelope[read+int(n):]
+
+	aead, ok := c.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", errDecrypt, id)
+	}
+
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: malformed envelope", errDecrypt)
+	}
+	nonce, sealed := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+
+	value, err := aead.Open(nil, nonce, sealed, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errDecrypt, err)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,90 @@
+package gcache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	ctx := context.Background()
+	k1 := EncryptionKey{ID: "k1", Key: bytes.Repeat([]byte{1}, 32)}
+	k2 := EncryptionKey{ID: "k2", Key: bytes.Repeat([]byte{2}, 16)}
+
+	inner := NewMemory(1 << 20)
+	store, err := NewEncrypted(inner, []EncryptionKey{k1})
+	require.NoError(t, err)
+
+	e := Entry{Value: []byte("customer@example.com"), ETag: "v1", Tags: []string{"customer:1"}}
+	require.NoError(t, AdaptStore(store).Save(ctx, "a", e))
+
+	raw, err := AdaptStore(inner).Load(ctx, "a")
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw.Value), "customer@example.com")
+	assert.Equal(t, "v1", raw.ETag)
+
+	got, err := AdaptStore(store).Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, e, got)
+
+	t.Run("rotation", func(t *testing.T) {
+		rotated, err := NewEncrypted(inner, []EncryptionKey{k2, k1})
+		require.NoError(t, err)
+
+		got, err := AdaptStore(rotated).Load(ctx, "a")
+		require.NoError(t, err, "entries of the old key are still readable")
+		assert.Equal(t, e.Value, got.Value)
+
+		require.NoError(t, AdaptStore(rotated).Save(ctx, "b", Entry{Value: []byte("b")}))
+		raw, err := AdaptStore(inner).Load(ctx, "b")
+		require.NoError(t, err)
+		assert.Equal(t, "k2", string(raw.Value[1:3]), "entries are encrypted with the first key")
+
+		// the old key is retired
+		_, err = AdaptStore(store).Load(ctx, "b")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = AdaptStore(inner).Load(ctx, "b")
+		assert.ErrorIs(t, err, ErrNotFound, "entry of the unknown key must be removed")
+	})
+
+	t.Run("copied under another key", func(t *testing.T) {
+		raw, err := AdaptStore(inner).Load(ctx, "a")
+		require.NoError(t, err)
+		inner.Set(ctx, "copy", raw)
+
+		_, err = AdaptStore(store).Load(ctx, "copy")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = AdaptStore(inner).Load(ctx, "copy")
+		assert.ErrorIs(t, err, ErrNotFound, "entry that failed to decrypt must be removed")
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		raw, err := AdaptStore(inner).Load(ctx, "a")
+		require.NoError(t, err)
+		raw.Value = bytes.Clone(raw.Value)
+		raw.Value[len(raw.Value)-1] ^= 1
+		inner.Set(ctx, "tampered", raw)
+		inner.Set(ctx, "garbage", Entry{Value: []byte{0xff}})
+
+		entries, err := store.(BatchStore).GetMulti(ctx, []string{"a", "tampered", "garbage"})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]Entry{"a": e}, entries)
+
+		_, err = AdaptStore(inner).Load(ctx, "tampered")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		_, err := NewEncrypted(inner, nil)
+		assert.Error(t, err)
+
+		_, err = NewEncrypted(inner, []EncryptionKey{{ID: "short", Key: []byte("short")}})
+		assert.Error(t, err)
+
+		_, err = NewEncrypted(inner, []EncryptionKey{k1, k1})
+		assert.Error(t, err)
+	})
+}
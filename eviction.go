@@ -0,0 +1,69 @@
+package gcache
+
+import (
+	"fmt"
+
+	arc "github.com/hashicorp/golang-lru/arc/v2"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// NewARC makes a store backed by the adaptive replacement cache of the
+// given number of entries, which balances between the recently and
+// the frequently used entries, so that one-off scans don't flush
+// the frequently used ones.
+func NewARC(size int) (Store, error) {
+	c, err := arc.NewARC[string, Entry](size)
+	if err != nil {
+		return nil, fmt.Errorf("make ARC cache: %w", err)
+	}
+	return NewLRU(&hashicorpBackend{cache: c, size: size}), nil
+}
+
+// New2Q makes a store backed by the 2Q cache of the given number of entries,
+// which admits the entries to the frequently used queue only on the second
+// access, so that one-off scans don't flush the frequently used entries.
+func New2Q(size int) (Store, error) {
+	c, err := lru.New2Q[string, Entry](size)
+	if err != nil {
+		return nil, fmt.Errorf("make 2Q cache: %w", err)
+	}
+	return NewLRU(&hashicorpBackend{cache: c, size: size}), nil
+}
+
+// hashicorpCache is implemented by hashicorp ARC and 2Q caches,
+// which don't report evictions and removals.
+type hashicorpCache interface {
+	Add(key string, value Entry)
+	Get(key string) (value Entry, ok bool)
+	Peek(key string) (value Entry, ok bool)
+	Remove(key string)
+	Contains(key string) bool
+	Len() int
+	Keys() []string
+}
+
+// hashicorpBackend adapts hashicorpCache to LRUBackend.
+type hashicorpBackend struct {
+	cache hashicorpCache
+	size  int
+}
+
+func (h *hashicorpBackend) Add(key string, value Entry) (evicted bool) {
+	evicted = h.cache.Len() >= h.size && !h.cache.Contains(key)
+	h.cache.Add(key, value)
+	return evicted
+}
+
+func (h *hashicorpBackend) Get(key string) (Entry, bool) { return h.cache.Get(key) }
+
+func (h *hashicorpBackend) Peek(key string) (Entry, bool) { return h.cache.Peek(key) }
+
+func (h *hashicorpBackend) Remove(key string) (present bool) {
+	present = h.cache.Contains(key)
+	h.cache.Remove(key)
+	return present
+}
+
+func (h *hashicorpBackend) Contains(key string) bool { return h.cache.Contains(key) }
+func (h *hashicorpBackend) Len() int                 { return h.cache.Len() }
+func (h *hashicorpBackend) Keys() []string           { return h.cache.Keys() }
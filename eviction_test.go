@@ -0,0 +1,214 @@
+package gcache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// evictionPolicies makes the stores of the given number of entries to compare.
+var evictionPolicies = []struct {
+	name string
+	make func(size int) (Store, error)
+}{
+	{name: "lru", make: func(size int) (Store, error) {
+		l, err := lru.New[string, Entry](size)
+		return NewLRU(l), err
+	}},
+	{name: "arc", make: NewARC},
+	{name: "2q", make: New2Q},
+	// the fixed seed makes the admission decisions of the sketch reproducible
+	{name: "tinylfu", make: func(size int) (Store, error) { return NewTinyLFU(size, withTinyLFUSeed(1)) }},
+}
+
+func TestEvictionPolicies_ScanResistance(t *testing.T) {
+	ctx := context.Background()
+
+	for _, policy := range evictionPolicies[1:] {
+		t.Run(policy.name, func(t *testing.T) {
+			store, err := policy.make(100)
+			require.NoError(t, err)
+
+			access := func(key string) {
+				if _, ok := store.Get(ctx, key); !ok {
+					store.Set(ctx, key, Entry{Value: []byte(key)})
+				}
+			}
+
+			for i := 0; i < 5; i++ {
+				for j := 0; j < 50; j++ {
+					access(fmt.Sprintf("hot:%d", j))
+				}
+			}
+
+			for j := 0; j < 500; j++ {
+				access(fmt.Sprintf("scan:%d", j))
+			}
+
+			for j := 0; j < 50; j++ {
+				_, ok := store.Get(ctx, fmt.Sprintf("hot:%d", j))
+				assert.True(t, ok, "hot:%d must survive the scan", j)
+			}
+		})
+	}
+}
+
+func TestEvictionPolicies_TagsAndPrefixes(t *testing.T) {
+	ctx := context.Background()
+
+	for _, policy := range evictionPolicies {
+		t.Run(policy.name, func(t *testing.T) {
+			store, err := policy.make(10)
+			require.NoError(t, err)
+
+			store.Set(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("1"), Tags: []string{"order:1"}})
+			store.Set(ctx, "/svc.Orders/Get{02}", Entry{Value: []byte("2"), Tags: []string{"order:2"}})
+			store.Set(ctx, "/svc.Orders/List{01}", Entry{Value: []byte("3")})
+			store.Set(ctx, "expired", Entry{Value: []byte("4"), ExpiresAt: time.Now().Add(-time.Second)})
+
+			require.NoError(t, store.(TagInvalidator).InvalidateTags(ctx, "order:1"))
+			require.NoError(t, store.(PrefixRemover).RemovePrefix(ctx, "/svc.Orders/List{"))
+
+			for key, present := range map[string]bool{
+				"/svc.Orders/Get{01}":  false,
+				"/svc.Orders/Get{02}":  true,
+				"/svc.Orders/List{01}": false,
+				"expired":              false,
+			} {
+				_, ok := store.Get(ctx, key)
+				assert.Equal(t, present, ok, key)
+			}
+
+			store.Remove(ctx, "/svc.Orders/Get{02}")
+			_, ok := store.Get(ctx, "/svc.Orders/Get{02}")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestTinyLFU_ReportsRejections(t *testing.T) {
+	ctx := context.Background()
+
+	var evicted []string
+	store, err := NewTinyLFU(2, WithTinyLFUObserver(ObserverFunc(func(_ context.Context, ev Event) {
+		assert.Equal(t, EventEvict, ev.Kind)
+		evicted = append(evicted, ev.Key)
+	})))
+	require.NoError(t, err)
+
+	store.Set(ctx, "a", Entry{})
+	store.Set(ctx, "b", Entry{}) // "a" is admitted to the empty main segment
+	_, ok := store.Get(ctx, "a")
+	require.True(t, ok)
+
+	store.Set(ctx, "c", Entry{}) // "b" is less frequent than "a", not admitted
+	assert.Equal(t, []string{"b"}, evicted)
+
+	_, err = NewTinyLFU(0)
+	assert.Error(t, err)
+}
+
+// BenchmarkStores_HitRatio replays the key traces against the stores
+// and reports their hit ratios. Besides the synthetic traces, it replays
+// the recorded ones from the files matching GCACHE_TRACES glob, with
+// one key per line. The stores fit a tenth of the distinct keys.
+func BenchmarkStores_HitRatio(b *testing.B) {
+	traces := map[string][]string{
+		"zipf":      syntheticTrace(false),
+		"zipf+scan": syntheticTrace(true),
+	}
+
+	if pattern := os.Getenv("GCACHE_TRACES"); pattern != "" {
+		files, err := filepath.Glob(pattern)
+		require.NoError(b, err)
+		for _, file := range files {
+			traces[filepath.Base(file)] = readTrace(b, file)
+		}
+	}
+
+	names := make([]string, 0, len(traces))
+	for name := range traces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		trace := traces[name]
+		distinct := map[string]struct{}{}
+		for _, key := range trace {
+			distinct[key] = struct{}{}
+		}
+		size := max(len(distinct)/10, 1)
+
+		for _, policy := range evictionPolicies {
+			b.Run(name+"/"+policy.name, func(b *testing.B) {
+				ctx := context.Background()
+				var hits int
+
+				for i := 0; i < b.N; i++ {
+					store, err := policy.make(size)
+					require.NoError(b, err)
+
+					hits = 0
+					for _, key := range trace {
+						if _, ok := store.Get(ctx, key); ok {
+							hits++
+							continue
+						}
+						store.Set(ctx, key, Entry{})
+					}
+				}
+
+				b.ReportMetric(100*float64(hits)/float64(len(trace)), "hit%")
+			})
+		}
+	}
+}
+
+// syntheticTrace makes a zipf-distributed trace, optionally interrupted
+// by the scans over the one-off keys, like paginated exports do.
+func syntheticTrace(scans bool) []string {
+	const requests, keys, scanEvery, scanLen = 200_000, 50_000, 20_000, 5_000
+
+	rnd := rand.New(rand.NewSource(1)) //nolint:gosec // OK for benchmarks
+	zipf := rand.NewZipf(rnd, 1.01, 1, keys-1)
+
+	trace := make([]string, 0, requests)
+	for i := 0; len(trace) < requests; i++ {
+		if scans && i > 0 && i%scanEvery == 0 {
+			for j := 0; j < scanLen; j++ {
+				trace = append(trace, fmt.Sprintf("scan:%d:%d", i, j))
+			}
+		}
+		trace = append(trace, fmt.Sprintf("key:%d", zipf.Uint64()))
+	}
+
+	return trace
+}
+
+func readTrace(b *testing.B, file string) []string {
+	f, err := os.Open(file) //nolint:gosec // path from the environment of the benchmark
+	require.NoError(b, err)
+	defer f.Close()
+
+	var trace []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if key := sc.Text(); key != "" {
+			trace = append(trace, key)
+		}
+	}
+	require.NoError(b, sc.Err())
+
+	return trace
+}
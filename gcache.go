@@ -34,3 +34,13 @@ func NotChanged(ctx context.Context, etag string) error {
 
 	return status.Error(codes.Aborted, "gcache: not changed")
 }
+
+// Tag attaches tags to the response that is going to be cached
+// by the server interceptor, e.g. "order:42" or "customer:7".
+// Entries can be later dropped by their tags via Interceptor.InvalidateTags.
+// It is a no-op if the call is not handled by the server interceptor.
+func Tag(ctx context.Context, tags ...string) {
+	if tc, ok := ctx.Value(tagsCtxKey{}).(*tagCollector); ok {
+		tc.add(tags...)
+	}
+}
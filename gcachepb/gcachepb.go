@@ -0,0 +1,3 @@
+// Package gcachepb contains the gRPC service, that streams the invalidations
+// of the cached responses from the server to its clients.
+package gcachepb
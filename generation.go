@@ -0,0 +1,179 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// generationKey is the key of the generation counter in the store.
+const generationKey = "gcache:generation"
+
+// generation is the counter of the purges, kept in the store. Keys of the
+// entries are prefixed with the current generation, so bumping it makes
+// all entries of the previous generations unreachable at once. Stores
+// that are Incrementer keep the counter apart from the entries, others
+// keep it as an entry, which might expire or be evicted.
+type generation struct {
+	store   StoreV2 // holds the counter
+	current atomic.Uint64
+	mu      sync.Mutex // serializes the bumps within the process
+}
+
+// prefix returns the prefix of the keys of the current generation.
+func (g *generation) prefix() string {
+	return "g" + strconv.FormatUint(g.current.Load(), 10) + ":"
+}
+
+// load reads the counter from the store, zero if there is none.
+// The entry of the counter is returned for the stores that aren't Incrementer.
+func (g *generation) load(ctx context.Context) (uint64, Entry, error) {
+	if inc, ok := g.store.(Incrementer); ok {
+		n, err := inc.Increment(ctx, generationKey, 0)
+		switch {
+		case err == nil:
+			return n, Entry{}, nil
+		case !errors.Is(err, ErrNotSupported):
+			return 0, Entry{}, fmt.Errorf("load generation: %w", err)
+		}
+	}
+
+	e, err := g.store.Load(ctx, generationKey)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return 0, Entry{}, nil
+	case err != nil:
+		return 0, Entry{}, fmt.Errorf("load generation: %w", err)
+	}
+
+	n, err := strconv.ParseUint(string(e.Value), 10, 64)
+	if err != nil {
+		return 0, Entry{}, fmt.Errorf("parse generation %q: %w", e.Value, err)
+	}
+
+	return n, e, nil
+}
+
+// advance moves the current generation forward to n,
+// reporting whether it was behind.
+func (g *generation) advance(n uint64) bool {
+	for {
+		cur := g.current.Load()
+		if n <= cur {
+			return false
+		}
+		if g.current.CompareAndSwap(cur, n) {
+			return true
+		}
+	}
+}
+
+// bump increments the counter in the store with Increment, or with
+// CompareAndSwap, or, if the store supports neither, with Load and Save,
+// which isn't atomic across the instances, and returns the new generation,
+// leaving the current one to be advanced by the caller. The counter
+// never goes back, even if the store lost it.
+func (g *generation) bump(ctx context.Context) (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if inc, ok := g.store.(Incrementer); ok {
+		next, err := inc.Increment(ctx, generationKey, 1)
+		if cur := g.current.Load(); err == nil && next <= cur {
+			// the store lost the counter, move it past the current generation
+			next, err = inc.Increment(ctx, generationKey, cur-next+1)
+		}
+
+		switch {
+		case err == nil:
+			return next, nil
+		case !errors.Is(err, ErrNotSupported):
+			return 0, fmt.Errorf("increment generation: %w", err)
+		}
+	}
+
+	for {
+		stored, e, err := g.load(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		next := max(stored, g.current.Load()) + 1
+		ne := Entry{Value: []byte(strconv.FormatUint(next, 10)), ETag: strconv.FormatUint(next, 10)}
+
+		swapped, err := compareAndSwap(ctx, g.store, generationKey, e.ETag, ne)
+		switch {
+		case errors.Is(err, ErrNotSupported):
+			if err = g.store.Save(ctx, generationKey, ne); err != nil {
+				return 0, fmt.Errorf("save generation: %w", err)
+			}
+		case err != nil:
+			return 0, fmt.Errorf("swap generation: %w", err)
+		case !swapped:
+			continue // bumped concurrently by another instance
+		}
+
+		return next, nil
+	}
+}
+
+// compareAndSwap swaps the entry, if the store is CompareAndSwapper,
+// returns ErrNotSupported otherwise.
+func compareAndSwap(ctx context.Context, s StoreV2, key, etag string, e Entry) (bool, error) {
+	if cas, ok := s.(CompareAndSwapper); ok {
+		return cas.CompareAndSwap(ctx, key, etag, e)
+	}
+	return false, ErrNotSupported
+}
+
+// Purge makes all cached entries unreachable at once, by bumping the
+// generation counter, kept in the store, without scanning or flushing
+// the store. Entries of the previous generations are left to expire or
+// to be evicted. The new generation is published to the bus, if set,
+// other instances pick it up from the store every refresh interval
+// otherwise. Returns ErrNotSupported without WithGenerations.
+func (c *Interceptor) Purge(ctx context.Context) error {
+	if c.generation == nil {
+		return ErrNotSupported
+	}
+
+	n, err := c.generation.bump(ctx)
+	if err != nil {
+		return fmt.Errorf("bump generation: %w", err)
+	}
+
+	return c.invalidate(ctx, Invalidation{Generation: n})
+}
+
+// refreshGeneration picks up the generation bumped by other instances
+// from the store every interval until the context is done.
+func (c *Interceptor) refreshGeneration(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, _, err := c.generation.load(ctx)
+			if err != nil {
+				c.logger.WarnContext(ctx, "gcache: failed to refresh generation", slog.Any(ErrKey, err))
+				continue
+			}
+
+			if n <= c.generation.current.Load() {
+				continue
+			}
+
+			if err = c.apply(ctx, Invalidation{Generation: n}); err != nil {
+				c.logger.WarnContext(ctx, "gcache: failed to apply generation", slog.Any(ErrKey, err))
+			}
+		}
+	}
+}
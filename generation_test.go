@@ -0,0 +1,154 @@
+package gcache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	rediscache "github.com/go-redis/cache/v9"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptor_Namespace(t *testing.T) {
+	ctx := context.Background()
+	l, _ := lru.New[string, Entry](10)
+	icptr := NewInterceptor(WithStore(NewLRU(l)), WithNamespace("orders-v3"))
+
+	icptr.save(ctx, "/svc.Orders/Get", "/svc.Orders/Get{01}", Entry{Value: []byte("1")})
+	assert.Equal(t, []string{"orders-v3:/svc.Orders/Get{01}"}, l.Keys())
+
+	_, err := icptr.store.Load(ctx, "/svc.Orders/Get{01}")
+	require.NoError(t, err)
+
+	require.NoError(t, icptr.InvalidateMethod(ctx, "/svc.Orders/Get"))
+	assert.Empty(t, l.Keys())
+}
+
+func TestInterceptor_Purge(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("not enabled", func(t *testing.T) {
+		assert.ErrorIs(t, NewInterceptor().Purge(ctx), ErrNotSupported)
+	})
+
+	t.Run("drops everything", func(t *testing.T) {
+		l, _ := lru.New[string, Entry](10)
+		store := NewLRU(l)
+		origin := NewInterceptor(WithStore(store), WithNamespace("orders"), WithGenerations(0))
+		replica := NewInterceptor(WithStore(store), WithNamespace("orders"), WithGenerations(time.Millisecond))
+		t.Cleanup(func() {
+			require.NoError(t, origin.Close(ctx))
+			require.NoError(t, replica.Close(ctx))
+		})
+
+		origin.save(ctx, "/svc.Orders/Get", "/svc.Orders/Get{01}", Entry{Value: []byte("1")})
+		assert.Equal(t, []string{"orders:g0:/svc.Orders/Get{01}"}, l.Keys())
+		_, err := replica.store.Load(ctx, "/svc.Orders/Get{01}")
+		require.NoError(t, err)
+
+		require.NoError(t, origin.Purge(ctx))
+		e, ok := l.Get("orders:gcache:generation")
+		require.True(t, ok, "generation must be kept in the store")
+		assert.Equal(t, "1", string(e.Value))
+
+		_, err = origin.store.Load(ctx, "/svc.Orders/Get{01}")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		assert.Eventually(t, func() bool {
+			_, err := replica.store.Load(ctx, "/svc.Orders/Get{01}")
+			return err != nil
+		}, time.Second, time.Millisecond, "replica must pick up the generation from the store")
+
+		origin.save(ctx, "/svc.Orders/Get", "/svc.Orders/Get{01}", Entry{Value: []byte("2")})
+		e, err = replica.store.Load(ctx, "/svc.Orders/Get{01}")
+		require.NoError(t, err)
+		assert.Equal(t, "2", string(e.Value))
+
+		restarted := NewInterceptor(WithStore(store), WithNamespace("orders"), WithGenerations(0))
+		_, err = restarted.store.Load(ctx, "/svc.Orders/Get{01}")
+		assert.NoError(t, err, "generation must be loaded on start")
+	})
+
+	t.Run("outdated snapshot", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.snap")
+		store := NewMemory(1 << 20)
+
+		replica := NewInterceptor(WithStore(store), WithGenerations(0), WithSnapshotFile(path))
+		replica.save(ctx, "/svc.Orders/Get", "/svc.Orders/Get{01}", Entry{Value: []byte("1")})
+		require.NoError(t, replica.Close(ctx))
+
+		origin := NewInterceptor(WithStore(store), WithGenerations(0))
+		require.NoError(t, origin.Purge(ctx))
+		require.NoError(t, origin.Close(ctx))
+
+		replica = NewInterceptor(WithStore(store), WithGenerations(0), WithSnapshotFile(path))
+		t.Cleanup(func() { require.NoError(t, replica.Close(ctx)) })
+		_, err := replica.store.Load(ctx, "/svc.Orders/Get{01}")
+		assert.ErrorIs(t, err, ErrNotFound, "snapshot made before the purge must not be restored")
+
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = f.Close() })
+		assert.ErrorIs(t, replica.Restore(ctx, f), ErrSnapshotGeneration)
+	})
+
+	t.Run("concurrent purges with bus", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+
+		store := NewRedisHashStore(client)
+		icptrs := make([]*Interceptor, 3)
+		for i := range icptrs {
+			icptrs[i] = NewInterceptor(WithStore(store), WithGenerations(0), WithBus(NewRedisBus(client)))
+			t.Cleanup(func() { require.NoError(t, icptrs[i].Close(ctx)) })
+		}
+
+		var wg sync.WaitGroup
+		for _, icptr := range icptrs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 5 {
+					assert.NoError(t, icptr.Purge(ctx))
+				}
+			}()
+		}
+		wg.Wait()
+
+		counter, err := mr.Get("gcache:generation")
+		require.NoError(t, err)
+		assert.Equal(t, "15", counter, "every purge must bump the counter")
+		assert.Zero(t, mr.TTL("gcache:generation"), "counter must not expire")
+		for _, icptr := range icptrs {
+			assert.Eventually(t, func() bool { return icptr.generation.current.Load() == 15 },
+				time.Second, time.Millisecond, "generation must be delivered by the bus")
+		}
+	})
+
+	t.Run("lost counter", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { _ = client.Close() })
+
+		icptr := NewInterceptor(WithStore(NewRedis(rediscache.New(&rediscache.Options{Redis: client}), WithRedisClient(client))), WithGenerations(0))
+		t.Cleanup(func() { require.NoError(t, icptr.Close(ctx)) })
+
+		require.NoError(t, icptr.Purge(ctx))
+		require.NoError(t, icptr.Purge(ctx))
+		mr.Del("gcache:generation")
+
+		require.NoError(t, icptr.Purge(ctx))
+		assert.EqualValues(t, 3, icptr.generation.current.Load(), "generation must never go back")
+		counter, err := mr.Get("gcache:generation")
+		require.NoError(t, err)
+		assert.Equal(t, "3", counter)
+	})
+}
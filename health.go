@@ -0,0 +1,35 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// CheckHealth checks the connectivity of the interceptor's store.
+// Stores that don't implement HealthChecker are considered healthy.
+func (c *Interceptor) CheckHealth(ctx context.Context) error {
+	if err := checkHealth(ctx, c.store); err != nil && !errors.Is(err, ErrNotSupported) {
+		return fmt.Errorf("check store health: %w", err)
+	}
+	return nil
+}
+
+// ReadinessHandler returns the HTTP handler for the readiness probes,
+// e.g. of Kubernetes. It responds with 200 OK, if the store is healthy,
+// and with 503 Service Unavailable and the error otherwise. The check is
+// bounded by the request context, so the probe timeout applies to it.
+func (c *Interceptor) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+		if err := c.CheckHealth(r.Context()); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintln(w, err)
+			return
+		}
+
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+}
@@ -0,0 +1,69 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptor_CheckHealth(t *testing.T) {
+	ctx := context.Background()
+	errDown := errors.New("store is down")
+
+	assert.NoError(t, NewInterceptor().CheckHealth(ctx), "stores without health checks are healthy")
+
+	store := &healthStore{Store: NewMemory(1 << 20)}
+	icptr := NewInterceptor(WithStore(ChainStore(store, WithPrefix("p:"))))
+	assert.NoError(t, icptr.CheckHealth(ctx))
+
+	store.err = errDown
+	assert.ErrorIs(t, icptr.CheckHealth(ctx), errDown, "must be passed through the middlewares")
+}
+
+func TestInterceptor_ReadinessHandler(t *testing.T) {
+	store := &healthStore{Store: NewMemory(1 << 20)}
+	h := NewInterceptor(WithStore(store)).ReadinessHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok\n", rec.Body.String())
+
+	store.err = errors.New("store is down")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "store is down")
+}
+
+func TestInterceptor_CloseStore(t *testing.T) {
+	store := &healthStore{Store: NewMemory(1 << 20)}
+	icptr := NewInterceptor(WithStore(store))
+
+	require.NoError(t, icptr.Close(context.Background()))
+	assert.False(t, store.closed, "store belongs to the caller by default")
+
+	icptr = NewInterceptor(WithStore(store), WithCloseStore())
+	require.NoError(t, icptr.Close(context.Background()))
+	assert.True(t, store.closed)
+}
+
+// healthStore reports the configured health and records whether it was closed.
+// It is Store only, so it is adapted to StoreV2 by AdaptStore.
+type healthStore struct {
+	Store
+	err    error
+	closed bool
+}
+
+func (s *healthStore) CheckHealth(context.Context) error { return s.err }
+
+func (s *healthStore) Close() error {
+	s.closed = true
+	return nil
+}
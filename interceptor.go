@@ -4,14 +4,18 @@ package gcache
 import (
 	"context"
 	"crypto/sha1" //nolint: gosec // we use sha1 for hashing
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"reflect"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru/v2"
 	"google.golang.org/grpc"
@@ -24,18 +28,46 @@ import (
 // Interceptor is a cache interceptor.
 // It looks over the ETag header and caches the response ONLY if the ETag is present.
 type Interceptor struct {
-	store  Store
+	store  StoreV2
 	logger *slog.Logger
 	codec  encoding.Codec
 	filter *regexp.Regexp
+	tagger Tagger
+	rules  map[string][]Rule // mutating method -> rules
+	bus    Bus
+
+	observer         Observer
+	storeErrorPolicy StoreErrorPolicy
+	snapshotFile     string
+	closeStore       bool
+	writeBehindSize  int
+	fullQueuePolicy  FullQueuePolicy
+	writeBehind      *writeBehind // nil if writes are performed inline
+	namespace        string
+	generations      bool
+	genRefresh       time.Duration
+	generation       *generation // nil if generations are disabled
+	fingerprints     bool
+	schemaPolicy     SchemaPolicy
+	schemas          sync.Map      // method -> *schema
+	epoch            atomic.Uint64 // bumped by the flushes the store fails, see flush
+
+	batchesMu sync.Mutex
+	batches   map[*saveBatch]struct{} // collected by the Warmers
+
+	ctx    context.Context    // context of the background jobs
+	stop   context.CancelFunc // stops the background jobs
+	wg     sync.WaitGroup
+	stopMu sync.Mutex // guards the jobs started after NewInterceptor, e.g. Watch, against Close
 }
 
 // NewInterceptor makes a new Interceptor.
 func NewInterceptor(opts ...Option) *Interceptor {
 	c := &Interceptor{
-		codec:  RawBytesCodec{},
-		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
-		filter: regexp.MustCompile(`.*`),
+		codec:    RawBytesCodec{},
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		filter:   regexp.MustCompile(`.*`),
+		observer: nopObserver{},
 	}
 
 	for _, opt := range opts {
@@ -44,12 +76,111 @@ func NewInterceptor(opts ...Option) *Interceptor {
 
 	if c.store == nil { // lazy init for LRU
 		l, _ := lru.New[string, Entry](1024)
-		c.store = NewLRU(l)
+		c.store = AdaptStore(NewLRU(l))
+	}
+
+	if c.namespace != "" {
+		prefix := c.namespace + ":"
+		c.store = prefixStore(c.store, func() string { return prefix })
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	c.ctx, c.stop = ctx, stop
+
+	if c.generations {
+		c.generation = &generation{store: c.store}
+		c.store = prefixStore(c.store, c.generation.prefix)
+
+		if n, _, err := c.generation.load(ctx); err != nil {
+			c.logger.WarnContext(ctx, "gcache: failed to load generation, starting from zero", slog.Any(ErrKey, err))
+		} else {
+			c.generation.advance(n)
+		}
+
+		if c.genRefresh > 0 {
+			c.wg.Add(1)
+			go func() {
+				defer c.wg.Done()
+				c.refreshGeneration(ctx, c.genRefresh)
+			}()
+		}
+	}
+
+	c.store = prefixStore(c.store, c.epochPrefix)
+
+	if c.snapshotFile != "" {
+		c.restoreFile(ctx)
+	}
+
+	if c.writeBehindSize > 0 {
+		c.writeBehind = newWriteBehind(c.writeBehindSize, c.fullQueuePolicy)
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.writeBehindLoop()
+		}()
+	}
+
+	if c.bus != nil {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			c.subscribe(ctx)
+		}()
 	}
 
 	return c
 }
 
+// Close stops the background jobs of the interceptor and waits
+// for them to finish, or for the context to be done.
+// The write-behind queue, if set, is drained.
+// If the snapshot file is set, the store is snapshotted to it.
+// The store is closed only with WithCloseStore.
+func (c *Interceptor) Close(ctx context.Context) error {
+	c.stopMu.Lock()
+	c.stop()
+	c.stopMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("wait for background jobs: %w", ctx.Err())
+	}
+
+	if c.snapshotFile != "" {
+		if err := c.snapshotToFile(ctx); err != nil {
+			return fmt.Errorf("snapshot store: %w", err)
+		}
+	}
+
+	if !c.closeStore {
+		return nil
+	}
+
+	if err := closeStore(c.store); err != nil {
+		return fmt.Errorf("close store: %w", err)
+	}
+
+	return nil
+}
+
+// epochPrefix returns the prefix of the keys of the current epoch,
+// which is empty, unless the store failed to flush.
+func (c *Interceptor) epochPrefix() string {
+	n := c.epoch.Load()
+	if n == 0 {
+		return ""
+	}
+	return "e" + strconv.FormatUint(n, 10) + ":"
+}
+
 // UnaryServerInterceptor returns a new unary server interceptor that caches the response.
 // It doesn't use ETag header, but Cache-Control header.
 func (c *Interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
@@ -59,6 +190,13 @@ func (c *Interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (resp any, err error) {
+		if rules, ok := c.rules[info.FullMethod]; ok {
+			if resp, err = handler(ctx, req); err == nil {
+				c.applyRules(ctx, rules, req)
+			}
+			return resp, err
+		}
+
 		if !c.filter.MatchString(info.FullMethod) {
 			return handler(ctx, req)
 		}
@@ -76,18 +214,31 @@ func (c *Interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 			return handler(ctx, req)
 		}
 
-		if e, ok := c.store.Get(ctx, key); ok {
+		s := c.schemaOf(ctx, info.FullMethod)
+		switch e, err := c.load(ctx, info.FullMethod, key, s); {
+		case err == nil:
 			if resp, err = c.buildResponse(info, e); err == nil {
+				c.observer.Observe(ctx, Event{Kind: EventHit, Method: info.FullMethod, Key: key})
 				return resp, nil
 			}
 
 			c.logger.WarnContext(ctx, "gcache: failed to unmarshal response, retrieving from handler",
 				slog.Any(ErrKey, err))
+		case errors.Is(err, ErrNotFound):
+			c.observer.Observe(ctx, Event{Kind: EventMiss, Method: info.FullMethod, Key: key})
+		default:
+			if err = c.storeFailed(ctx, info.FullMethod, key, "load", err); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
 		}
 
+		ctx, tc := withTagCollector(ctx)
+		start := time.Now()
 		if resp, err = handler(ctx, req); err != nil {
 			return nil, err
 		}
+		cost := time.Since(start)
 
 		bts, err := c.codec.Marshal(resp)
 		if err != nil {
@@ -96,7 +247,16 @@ func (c *Interceptor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 			return resp, nil
 		}
 
-		c.store.Set(ctx, key, Entry{Value: bts})
+		tags := append(tc.collected(), c.tags(info.FullMethod, req, resp)...)
+		c.publishSchema(ctx, info.FullMethod, s)
+		e := Entry{Value: s.seal(bts), Tags: tags, Cost: cost}
+		if b, ok := saveBatchOf(ctx, c); ok {
+			b.add(key, e) // warmed, see Warmer
+			return resp, nil
+		}
+
+		c.save(ctx, info.FullMethod, key, e)
+
 		return resp, nil
 	}
 }
@@ -111,6 +271,14 @@ func (c *Interceptor) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
 		invoker grpc.UnaryInvoker,
 		opts ...grpc.CallOption,
 	) error {
+		if rules, ok := c.rules[method]; ok {
+			if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+				return err
+			}
+			c.applyRules(ctx, rules, req)
+			return nil
+		}
+
 		if !c.filter.MatchString(method) {
 			return invoker(ctx, method, req, reply, cc, opts...)
 		}
@@ -122,13 +290,27 @@ func (c *Interceptor) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
 			return invoker(ctx, method, req, reply, cc, opts...)
 		}
 
-		cachedValue, ok := c.store.Get(ctx, key)
-		if ok {
+		var cached *Entry
+		s := c.schemaOf(ctx, method)
+		switch e, err := c.load(ctx, method, key, s); {
+		case err == nil:
+			c.observer.Observe(ctx, Event{Kind: EventHit, Method: method, Key: key})
+			cached = &e
+		case errors.Is(err, ErrNotFound):
+			c.observer.Observe(ctx, Event{Kind: EventMiss, Method: method, Key: key})
+		default:
+			if err = c.storeFailed(ctx, method, key, "load", err); err != nil {
+				return err
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		if cached != nil && cached.ETag != "" {
 			outMD, ok := metadata.FromOutgoingContext(ctx)
 			if !ok {
 				outMD = metadata.MD{}
 			}
-			outMD.Set("If-None-Match", cachedValue.ETag)
+			outMD.Set("If-None-Match", cached.ETag)
 			ctx = metadata.NewOutgoingContext(ctx, outMD)
 		}
 
@@ -136,11 +318,13 @@ func (c *Interceptor) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
 		opts = append(opts, grpc.Header(inMD), grpc.ForceCodec(c.codec))
 
 		var raw []byte
-		switch err = invoker(ctx, method, req, &raw, cc, opts...); {
-		case notChanged(ctx, err, inMD):
-			if err = c.codec.Unmarshal(cachedValue.Value, reply); err != nil {
-				return fmt.Errorf("unmarshal cached response: %w", err)
-			}
+		start := time.Now()
+		err = invoker(ctx, method, req, &raw, cc, opts...)
+		cost := time.Since(start)
+
+		switch {
+		case cached != nil && notChanged(ctx, err, inMD):
+			raw, cost = cached.Value, cached.Cost
 		case err != nil:
 			return fmt.Errorf("call invoker: %w", err)
 		}
@@ -150,15 +334,35 @@ func (c *Interceptor) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
 		}
 
 		if etag := inMD.Get("ETag"); len(etag) != 0 {
-			c.store.Set(ctx, key, Entry{Value: raw, ETag: etag[0]})
+			c.publishSchema(ctx, method, s)
+			c.save(ctx, method, key, Entry{Value: s.seal(raw), ETag: etag[0], Tags: c.tags(method, req, reply), Cost: cost})
 		} else {
-			c.store.Remove(ctx, key)
+			c.remove(ctx, method, key)
 		}
 
 		return nil
 	}
 }
 
+// storeFailed reports the failure of the store operation and returns
+// the error to respond with, if the call must be failed.
+func (c *Interceptor) storeFailed(ctx context.Context, method, key, op string, err error) error {
+	if errors.Is(err, ErrCircuitOpen) {
+		// the store is known to be down, bypass the cache quietly
+		return nil
+	}
+
+	c.logger.WarnContext(ctx, "gcache: store operation failed",
+		slog.String("method", method), slog.String("op", op), slog.Any(ErrKey, err))
+	c.observer.Observe(ctx, Event{Kind: EventStoreError, Method: method, Key: key, Op: op, Err: err})
+
+	if op == "load" && c.storeErrorPolicy == FailOnStoreError {
+		return status.Error(codes.Unavailable, "gcache: cache store is unavailable")
+	}
+
+	return nil
+}
+
 var responseCache sync.Map
 
 func (c *Interceptor) responseType(fullMethodName string, srv any) (any, error) {
@@ -200,7 +404,17 @@ func (c *Interceptor) key(method string, req interface{}) (string, error) {
 		return "", fmt.Errorf("marshal request: %w", err)
 	}
 
-	return fmt.Sprintf("%s{%x}", method, hash(bts)), nil
+	return fmt.Sprintf("%s%x}", methodPrefix(method), hash(bts)), nil
+}
+
+// methodPrefix returns the prefix shared by the keys of the method.
+func methodPrefix(method string) string { return method + "{" }
+
+func (c *Interceptor) tags(method string, req, resp any) []string {
+	if c.tagger == nil {
+		return nil
+	}
+	return c.tagger(method, req, resp)
 }
 
 func notChanged(ctx context.Context, err error, inMD *metadata.MD) bool {
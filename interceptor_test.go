@@ -2,6 +2,7 @@ package gcache
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"regexp"
 	"testing"
@@ -10,8 +11,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -42,7 +45,7 @@ func TestInterceptor_UnaryClientInterceptor(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "must-not-be-cached", resp.Value)
 
-		_, ok := icptr.store.Get(context.Background(), emptyReqKey)
+		_, ok := lookup(t, icptr, emptyReqKey)
 		require.False(t, ok)
 	})
 
@@ -69,7 +72,7 @@ func TestInterceptor_UnaryClientInterceptor(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "must-be-cached", resp.Value)
 
-		e, ok := icptr.store.Get(context.Background(), emptyReqKey)
+		e, ok := lookup(t, icptr, emptyReqKey)
 		require.True(t, ok)
 		assert.Equal(t, "must-be-cached", e.ETag)
 	})
@@ -95,7 +98,7 @@ func TestInterceptor_UnaryClientInterceptor(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "must-be-cached", resp.Value)
 
-		_, ok := icptr.store.Get(context.Background(), emptyReqKey)
+		_, ok := lookup(t, icptr, emptyReqKey)
 		require.False(t, ok)
 	})
 
@@ -112,7 +115,7 @@ func TestInterceptor_UnaryClientInterceptor(t *testing.T) {
 		bts, err := RawBytesCodec{}.Marshal(&tspb.TestResponse{Value: "use-cached"})
 		require.NoError(t, err)
 
-		icptr.store.Set(context.Background(), emptyReqKey, Entry{Value: bts, ETag: "use-cached"})
+		save(t, icptr, emptyReqKey, Entry{Value: bts, ETag: "use-cached"})
 
 		cc, err := grpc.NewClient(addr,
 			grpc.WithUnaryInterceptor(icptr.UnaryClientInterceptor()),
@@ -141,7 +144,7 @@ func TestInterceptor_UnaryClientInterceptor(t *testing.T) {
 		bts, err := RawBytesCodec{}.Marshal(&tspb.TestResponse{Value: "use-cached"})
 		require.NoError(t, err)
 
-		icptr.store.Set(context.Background(), emptyReqKey, Entry{Value: bts, ETag: "use-cached"})
+		save(t, icptr, emptyReqKey, Entry{Value: bts, ETag: "use-cached"})
 
 		cc, err := grpc.NewClient(addr,
 			grpc.WithUnaryInterceptor(icptr.UnaryClientInterceptor()),
@@ -155,7 +158,7 @@ func TestInterceptor_UnaryClientInterceptor(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "update", resp.Value)
 
-		e, ok := icptr.store.Get(context.Background(), emptyReqKey)
+		e, ok := lookup(t, icptr, emptyReqKey)
 		require.True(t, ok)
 
 		assert.Equal(t, "update", e.ETag)
@@ -173,7 +176,7 @@ func TestInterceptor_UnaryClientInterceptor(t *testing.T) {
 		bts, err := RawBytesCodec{}.Marshal(&tspb.TestResponse{Value: "use-cached"})
 		require.NoError(t, err)
 
-		icptr.store.Set(context.Background(), emptyReqKey, Entry{Value: bts, ETag: "use-cached"})
+		save(t, icptr, emptyReqKey, Entry{Value: bts, ETag: "use-cached"})
 
 		cc, err := grpc.NewClient(addr,
 			grpc.WithUnaryInterceptor(icptr.UnaryClientInterceptor()),
@@ -187,7 +190,7 @@ func TestInterceptor_UnaryClientInterceptor(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "update", resp.Value)
 
-		_, ok := icptr.store.Get(context.Background(), emptyReqKey)
+		_, ok := lookup(t, icptr, emptyReqKey)
 		require.False(t, ok)
 	})
 }
@@ -212,7 +215,7 @@ func TestInterceptor_UnaryServerInterceptor(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "must-not-be-cached", resp.Value)
 
-		_, ok := icptr.store.Get(context.Background(), emptyReqKey)
+		_, ok := lookup(t, icptr, emptyReqKey)
 		require.False(t, ok)
 	})
 
@@ -234,7 +237,7 @@ func TestInterceptor_UnaryServerInterceptor(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, "must-not-be-cached", resp.Value)
 
-		e, ok := icptr.store.Get(context.Background(), emptyReqKey)
+		e, ok := lookup(t, icptr, emptyReqKey)
 		require.True(t, ok)
 
 		expected, err := proto.Marshal(&tspb.TestResponse{Value: "must-not-be-cached"})
@@ -278,7 +281,7 @@ func TestInterceptor_UnaryServerInterceptor(t *testing.T) {
 		bts, err := RawBytesCodec{}.Marshal(&tspb.TestResponse{Value: "use-cached"})
 		require.NoError(t, err)
 
-		icptr.store.Set(context.Background(), emptyReqKey, Entry{Value: bts})
+		save(t, icptr, emptyReqKey, Entry{Value: bts})
 
 		addr := tspb.Run(t, tspb.MockTestService{
 			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
@@ -304,7 +307,7 @@ func TestInterceptor_UnaryServerInterceptor(t *testing.T) {
 		bts, err := RawBytesCodec{}.Marshal(&tspb.TestResponse{Value: "must-not-be-responded"})
 		require.NoError(t, err)
 
-		icptr.store.Set(context.Background(), emptyReqKey, Entry{Value: bts})
+		save(t, icptr, emptyReqKey, Entry{Value: bts})
 
 		addr := tspb.Run(t, tspb.MockTestService{
 			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
@@ -323,3 +326,275 @@ func TestInterceptor_UnaryServerInterceptor(t *testing.T) {
 		assert.Equal(t, "success", resp.Value)
 	})
 }
+
+func TestInterceptor_InvalidateTags(t *testing.T) {
+	t.Run("tags from handler and tagger", func(t *testing.T) {
+		icptr := NewInterceptor(WithTagger(func(fullMethod string, req, resp any) []string {
+			return []string{"value:" + resp.(*tspb.TestResponse).Value}
+		}))
+
+		calls := 0
+		addr := tspb.Run(t, tspb.MockTestService{
+			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+				calls++
+				Tag(ctx, "key:"+in.Key)
+				return &tspb.TestResponse{Value: "tagged"}, nil
+			},
+		}, grpc.UnaryInterceptor(icptr.UnaryServerInterceptor()))
+
+		cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+
+		cl := tspb.NewTestServiceClient(cc)
+
+		_, err = cl.Test(context.Background(), &tspb.TestRequest{})
+		require.NoError(t, err)
+
+		e, ok := lookup(t, icptr, emptyReqKey)
+		require.True(t, ok)
+		assert.Equal(t, []string{"key:", "value:tagged"}, e.Tags)
+
+		require.NoError(t, icptr.InvalidateTags(context.Background(), "unknown"))
+		_, ok = lookup(t, icptr, emptyReqKey)
+		require.True(t, ok)
+
+		require.NoError(t, icptr.InvalidateTags(context.Background(), "value:tagged"))
+		_, ok = lookup(t, icptr, emptyReqKey)
+		require.False(t, ok)
+
+		_, err = cl.Test(context.Background(), &tspb.TestRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("store doesn't support tags", func(t *testing.T) {
+		icptr := NewInterceptor(WithStore(nopStore{}))
+		assert.ErrorIs(t, icptr.InvalidateTags(context.Background(), "tag"), ErrNotSupported)
+	})
+}
+
+type nopStore struct{}
+
+func (nopStore) Get(context.Context, string) (Entry, bool) { return Entry{}, false }
+func (nopStore) Set(context.Context, string, Entry)        {}
+func (nopStore) Remove(context.Context, string)            {}
+
+func TestInterceptor_Invalidate(t *testing.T) {
+	icptr := NewInterceptor()
+	ctx := context.Background()
+	const method = "/com.github.cappuccinotm.gcache.example.TestService/Test"
+
+	for _, key := range []string{"a", "b"} {
+		k, err := icptr.key(method, &tspb.TestRequest{Key: key})
+		require.NoError(t, err)
+		save(t, icptr, k, Entry{Value: []byte(key)})
+	}
+	save(t, icptr, "/other.Service/Test{da39}", Entry{Value: []byte("other")})
+
+	require.NoError(t, icptr.Invalidate(ctx, method, &tspb.TestRequest{Key: "a"}))
+
+	k, err := icptr.key(method, &tspb.TestRequest{Key: "a"})
+	require.NoError(t, err)
+	_, ok := lookup(t, icptr, k)
+	assert.False(t, ok)
+
+	k, err = icptr.key(method, &tspb.TestRequest{Key: "b"})
+	require.NoError(t, err)
+	_, ok = lookup(t, icptr, k)
+	assert.True(t, ok)
+
+	require.NoError(t, icptr.InvalidateMethod(ctx, method))
+	_, ok = lookup(t, icptr, k)
+	assert.False(t, ok)
+
+	_, ok = lookup(t, icptr, "/other.Service/Test{da39}")
+	assert.True(t, ok)
+
+	assert.ErrorIs(t, NewInterceptor(WithStore(nopStore{})).InvalidateMethod(ctx, method), ErrNotSupported)
+
+	t.Run("batch", func(t *testing.T) {
+		store := &batchStore{Store: NewMemory(1 << 20)}
+		icptr := NewInterceptor(WithStore(store))
+
+		var keys []string
+		for _, key := range []string{"a", "b", "c"} {
+			k, err := icptr.key(method, &tspb.TestRequest{Key: key})
+			require.NoError(t, err)
+			save(t, icptr, k, Entry{Value: []byte(key)})
+			keys = append(keys, k)
+		}
+
+		require.NoError(t, icptr.Invalidate(ctx, method, &tspb.TestRequest{Key: "a"}, &tspb.TestRequest{Key: "b"}))
+		assert.Equal(t, [][]string{keys[:2]}, store.removed, "keys must be removed in a single batch")
+
+		_, ok := lookup(t, icptr, keys[2])
+		assert.True(t, ok)
+	})
+}
+
+// batchStore records the batches of saved and removed keys.
+type batchStore struct {
+	Store
+	saved   []map[string]Entry
+	removed [][]string
+}
+
+func (b *batchStore) GetMulti(context.Context, []string) (map[string]Entry, error) {
+	return nil, ErrNotSupported
+}
+
+func (b *batchStore) SetMulti(ctx context.Context, entries map[string]Entry) error {
+	b.saved = append(b.saved, entries)
+	for key, e := range entries {
+		b.Set(ctx, key, e)
+	}
+	return nil
+}
+
+func (b *batchStore) RemoveMulti(ctx context.Context, keys []string) error {
+	b.removed = append(b.removed, keys)
+	for _, key := range keys {
+		b.Remove(ctx, key)
+	}
+	return nil
+}
+
+// lookup returns the entry from the interceptor's store.
+func lookup(t *testing.T, icptr *Interceptor, key string) (Entry, bool) {
+	e, err := icptr.store.Load(context.Background(), key)
+	if errors.Is(err, ErrNotFound) {
+		return Entry{}, false
+	}
+	require.NoError(t, err)
+	return e, true
+}
+
+// save puts the entry to the interceptor's store.
+func save(t *testing.T, icptr *Interceptor, key string, e Entry) {
+	require.NoError(t, icptr.store.Save(context.Background(), key, e))
+}
+
+func TestInterceptor_StoreErrors(t *testing.T) {
+	errStore := errors.New("store is down")
+
+	newInterceptor := func(policy StoreErrorPolicy) (*Interceptor, *[]Event) {
+		var events []Event
+		return NewInterceptor(
+			WithStoreV2(failingStore{err: errStore}),
+			WithStoreErrorPolicy(policy),
+			WithObserver(ObserverFunc(func(_ context.Context, ev Event) { events = append(events, ev) })),
+		), &events
+	}
+
+	t.Run("server, bypass", func(t *testing.T) {
+		icptr, events := newInterceptor(BypassOnStoreError)
+
+		addr := tspb.Run(t, tspb.MockTestService{
+			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+				return &tspb.TestResponse{Value: "from-handler"}, nil
+			},
+		}, grpc.UnaryInterceptor(icptr.UnaryServerInterceptor()))
+
+		cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+
+		resp, err := tspb.NewTestServiceClient(cc).Test(context.Background(), &tspb.TestRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, "from-handler", resp.Value)
+
+		require.Len(t, *events, 1)
+		assert.Equal(t, EventStoreError, (*events)[0].Kind)
+		assert.Equal(t, "load", (*events)[0].Op)
+		assert.ErrorIs(t, (*events)[0].Err, errStore)
+	})
+
+	t.Run("server, fail", func(t *testing.T) {
+		icptr, _ := newInterceptor(FailOnStoreError)
+
+		addr := tspb.Run(t, tspb.MockTestService{
+			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+				require.Fail(t, "must not be called")
+				return nil, nil
+			},
+		}, grpc.UnaryInterceptor(icptr.UnaryServerInterceptor()))
+
+		cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+
+		_, err = tspb.NewTestServiceClient(cc).Test(context.Background(), &tspb.TestRequest{})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("client, bypass", func(t *testing.T) {
+		icptr, events := newInterceptor(BypassOnStoreError)
+
+		addr := tspb.Run(t, tspb.MockTestService{
+			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+				assert.Empty(t, ETag(ctx), "must not send If-None-Match")
+				return &tspb.TestResponse{Value: "from-server"}, nil
+			},
+		})
+
+		cc, err := grpc.NewClient(addr,
+			grpc.WithUnaryInterceptor(icptr.UnaryClientInterceptor()),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		require.NoError(t, err)
+
+		resp, err := tspb.NewTestServiceClient(cc).Test(context.Background(), &tspb.TestRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, "from-server", resp.Value)
+
+		require.Len(t, *events, 1)
+		assert.Equal(t, EventStoreError, (*events)[0].Kind)
+	})
+
+	t.Run("client, fail", func(t *testing.T) {
+		icptr, _ := newInterceptor(FailOnStoreError)
+
+		addr := tspb.Run(t, tspb.MockTestService{
+			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+				require.Fail(t, "must not be called")
+				return nil, nil
+			},
+		})
+
+		cc, err := grpc.NewClient(addr,
+			grpc.WithUnaryInterceptor(icptr.UnaryClientInterceptor()),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		require.NoError(t, err)
+
+		_, err = tspb.NewTestServiceClient(cc).Test(context.Background(), &tspb.TestRequest{})
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+
+	t.Run("server, circuit open", func(t *testing.T) {
+		var events []Event
+		icptr := NewInterceptor(
+			WithStoreV2(failingStore{err: ErrCircuitOpen}),
+			WithStoreErrorPolicy(FailOnStoreError),
+			WithObserver(ObserverFunc(func(_ context.Context, ev Event) { events = append(events, ev) })),
+		)
+
+		addr := tspb.Run(t, tspb.MockTestService{
+			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+				return &tspb.TestResponse{Value: "from-handler"}, nil
+			},
+		}, grpc.UnaryInterceptor(icptr.UnaryServerInterceptor()))
+
+		cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+
+		resp, err := tspb.NewTestServiceClient(cc).Test(context.Background(), &tspb.TestRequest{})
+		require.NoError(t, err, "open circuit must bypass the cache regardless of the policy")
+		assert.Equal(t, "from-handler", resp.Value)
+		assert.Empty(t, events)
+	})
+}
+
+type failingStore struct{ err error }
+
+func (f failingStore) Load(context.Context, string) (Entry, error) { return Entry{}, f.err }
+func (f failingStore) Save(context.Context, string, Entry) error   { return f.err }
+func (f failingStore) Delete(context.Context, string) error        { return f.err }
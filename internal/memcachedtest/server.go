@@ -0,0 +1,365 @@
+// Package memcachedtest provides an in-process memcached server for tests.
+// It speaks the subset of the memcached text protocol used by gcache:
+// get, gets, set, add, replace, cas, delete, flush_all, version and quit.
+package memcachedtest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MaxItemSize is the largest value the server accepts, as memcached does by default.
+const MaxItemSize = 1 << 20
+
+// maxRelativeExptime is the largest expiration time that memcached treats
+// as relative, the larger ones are Unix timestamps.
+const maxRelativeExptime = 30 * 24 * 60 * 60
+
+// Server is an in-process memcached server.
+type Server struct {
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	items    map[string]item
+	cas      uint64
+	offset   time.Duration // added to the wall clock by FastForward
+	conns    map[net.Conn]struct{}
+	accepted int
+}
+
+type item struct {
+	flags     uint32
+	value     []byte
+	cas       uint64
+	expiresAt time.Time // zero if the item doesn't expire
+}
+
+// NewServer starts the server on a random local port,
+// the server is closed when the test finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	s := &Server{ln: ln, items: map[string]item{}, conns: map[net.Conn]struct{}{}}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Addr returns the address the server listens on.
+func (s *Server) Addr() string { return s.ln.Addr().String() }
+
+// Close stops the server and drops all connections.
+func (s *Server) Close() {
+	_ = s.ln.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// FastForward moves the clock of the server forward, expiring the items.
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Accepted returns the number of connections the server has accepted.
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+// Keys returns the sorted keys of the live items.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		if _, ok := s.get(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Get returns the value of the live item.
+func (s *Server) Get(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it, ok := s.get(key)
+	return it.value, ok
+}
+
+// Set puts the item with the given value, replacing the existing one.
+func (s *Server) Set(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cas++
+	s.items[key] = item{value: value, cas: s.cas}
+}
+
+// TTL returns the time left until the item expires,
+// or zero if the item doesn't expire or doesn't exist.
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	it, ok := s.get(key)
+	if !ok || it.expiresAt.IsZero() {
+		return 0
+	}
+	return it.expiresAt.Sub(s.now())
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.accepted++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			_, _ = w.WriteString("ERROR\r\n")
+			continue
+		}
+
+		switch args[0] {
+		case "get", "gets":
+			s.retrieve(w, args[0] == "gets", args[1:])
+		case "set", "add", "replace", "cas":
+			if err = s.store(r, w, args[0], args[1:]); err != nil {
+				// the data block can't be skipped, the stream is out of sync
+				_, _ = fmt.Fprintf(w, "CLIENT_ERROR %s\r\n", err)
+				_ = w.Flush()
+				return
+			}
+		case "delete":
+			s.delete(w, args[1:])
+		case "flush_all":
+			s.mu.Lock()
+			clear(s.items)
+			s.mu.Unlock()
+			_, _ = w.WriteString("OK\r\n")
+		case "version":
+			_, _ = w.WriteString("VERSION 1.6.0-memcachedtest\r\n")
+		case "quit":
+			_ = w.Flush()
+			return
+		default:
+			_, _ = w.WriteString("ERROR\r\n")
+		}
+
+		// replies to pipelined commands are flushed together
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *Server) retrieve(w *bufio.Writer, withCAS bool, keys []string) {
+	if len(keys) == 0 {
+		_, _ = w.WriteString("ERROR\r\n")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		it, ok := s.get(key)
+		if !ok {
+			continue
+		}
+
+		if withCAS {
+			_, _ = fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, it.flags, len(it.value), it.cas)
+		} else {
+			_, _ = fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, it.flags, len(it.value))
+		}
+		_, _ = w.Write(it.value)
+		_, _ = w.WriteString("\r\n")
+	}
+
+	_, _ = w.WriteString("END\r\n")
+}
+
+// store handles the storage command, which is
+//
+//	<cmd> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply]
+//
+// followed by the data block. It returns an error only if the data block
+// can't be read.
+func (s *Server) store(r *bufio.Reader, w *bufio.Writer, cmd string, args []string) error {
+	n := 4
+	if cmd == "cas" {
+		n = 5
+	}
+	if len(args) < n {
+		return errors.New("bad command line format")
+	}
+
+	flags, ferr := strconv.ParseUint(args[1], 10, 32)
+	exptime, eerr := strconv.ParseInt(args[2], 10, 64)
+	size, serr := strconv.Atoi(args[3])
+	var unique uint64
+	var cerr error
+	if cmd == "cas" {
+		unique, cerr = strconv.ParseUint(args[4], 10, 64)
+	}
+	if err := errors.Join(ferr, eerr, serr, cerr); err != nil || size < 0 {
+		return errors.New("bad command line format")
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return errors.New("bad data chunk")
+	}
+	if string(data[size:]) != "\r\n" {
+		return errors.New("bad data chunk")
+	}
+
+	noreply := len(args) > n && args[n] == "noreply"
+	reply := func(msg string) {
+		if !noreply {
+			_, _ = w.WriteString(msg + "\r\n")
+		}
+	}
+
+	if size > MaxItemSize {
+		reply("SERVER_ERROR object too large for cache")
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := args[0]
+	existing, exists := s.get(key)
+	switch {
+	case cmd == "add" && exists, cmd == "replace" && !exists:
+		reply("NOT_STORED")
+		return nil
+	case cmd == "cas" && !exists:
+		reply("NOT_FOUND")
+		return nil
+	case cmd == "cas" && existing.cas != unique:
+		reply("EXISTS")
+		return nil
+	}
+
+	s.cas++
+	it := item{flags: uint32(flags), value: data[:size], cas: s.cas}
+	switch {
+	case exptime < 0:
+		// memcached stores the item expired
+		it.expiresAt = s.now()
+	case exptime > maxRelativeExptime:
+		it.expiresAt = time.Unix(exptime, 0)
+	case exptime > 0:
+		it.expiresAt = s.now().Add(time.Duration(exptime) * time.Second)
+	}
+	s.items[key] = it
+
+	reply("STORED")
+	return nil
+}
+
+func (s *Server) delete(w *bufio.Writer, args []string) {
+	if len(args) == 0 {
+		_, _ = w.WriteString("ERROR\r\n")
+		return
+	}
+
+	s.mu.Lock()
+	_, ok := s.get(args[0])
+	delete(s.items, args[0])
+	s.mu.Unlock()
+
+	if len(args) > 1 && args[len(args)-1] == "noreply" {
+		return
+	}
+
+	if ok {
+		_, _ = w.WriteString("DELETED\r\n")
+		return
+	}
+	_, _ = w.WriteString("NOT_FOUND\r\n")
+}
+
+// get returns the live item, dropping the expired one, s.mu must be held.
+func (s *Server) get(key string) (item, bool) {
+	it, ok := s.items[key]
+	if !ok {
+		return item{}, false
+	}
+
+	if !it.expiresAt.IsZero() && !s.now().Before(it.expiresAt) {
+		delete(s.items, key)
+		return item{}, false
+	}
+
+	return it, true
+}
+
+func (s *Server) now() time.Time { return time.Now().Add(s.offset) }
@@ -3,6 +3,7 @@ package tspb
 
 import (
 	"context"
+	"errors"
 	"net"
 	"testing"
 
@@ -34,7 +35,10 @@ func Run(t *testing.T, ts MockTestService, opts ...grpc.ServerOption) (addr stri
 	RegisterTestServiceServer(s, &ts)
 
 	go func() {
-		require.NoError(t, s.Serve(l))
+		// server might be stopped before it started serving
+		if err := s.Serve(l); !errors.Is(err, grpc.ErrServerStopped) {
+			require.NoError(t, err)
+		}
 	}()
 
 	t.Cleanup(func() { s.GracefulStop() })
@@ -0,0 +1,161 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// ErrNotSupported is returned when the store doesn't support the requested operation.
+var ErrNotSupported = errors.New("gcache: operation is not supported by the store")
+
+// Invalidation describes the cached entries to remove.
+type Invalidation struct {
+	Keys     []string `json:"keys,omitempty"`
+	Prefixes []string `json:"prefixes,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	// Generation is the generation the interceptor moves to,
+	// making all entries of the previous ones unreachable, see Purge.
+	Generation uint64 `json:"generation,omitempty"`
+}
+
+// InvalidateTags removes all cached entries that carry any of the given tags.
+// Returns ErrNotSupported if the store doesn't implement TagInvalidator.
+func (c *Interceptor) InvalidateTags(ctx context.Context, tags ...string) error {
+	return c.invalidate(ctx, Invalidation{Tags: tags})
+}
+
+// Invalidate removes the cached responses of the given method for the given requests.
+// Keys are computed the same way as interceptors do, and removed
+// in a single batch, if the store implements BatchStore.
+func (c *Interceptor) Invalidate(ctx context.Context, fullMethod string, reqs ...any) error {
+	keys := make([]string, len(reqs))
+	for i, req := range reqs {
+		key, err := c.key(fullMethod, req)
+		if err != nil {
+			return fmt.Errorf("produce key: %w", err)
+		}
+		keys[i] = key
+	}
+
+	return c.invalidate(ctx, Invalidation{Keys: keys})
+}
+
+// InvalidateMethod removes all cached responses of the given method.
+// Returns ErrNotSupported if the store doesn't implement PrefixRemover.
+func (c *Interceptor) InvalidateMethod(ctx context.Context, fullMethod string) error {
+	return c.invalidate(ctx, Invalidation{Prefixes: []string{methodPrefix(fullMethod)}})
+}
+
+// invalidate removes the entries from the store and publishes
+// the invalidation to the other instances, if the bus is set.
+func (c *Interceptor) invalidate(ctx context.Context, inv Invalidation) error {
+	if err := c.apply(ctx, inv); err != nil {
+		return err
+	}
+
+	if c.bus == nil {
+		return nil
+	}
+
+	if err := c.bus.Publish(ctx, inv); err != nil {
+		return fmt.Errorf("publish invalidation: %w", err)
+	}
+
+	return nil
+}
+
+// apply removes the entries, described by the invalidation, from the store.
+func (c *Interceptor) apply(ctx context.Context, inv Invalidation) error {
+	return invalidateStore(ctx, c.store, c.track(inv))
+}
+
+// receive applies the invalidation, published by another instance.
+// The publisher has already removed the entries from the shared store,
+// so that only their local copies are purged, see LocalInvalidator.
+func (c *Interceptor) receive(ctx context.Context, inv Invalidation) {
+	if err := invalidateLocal(ctx, c.store, c.track(inv)); err != nil {
+		c.logger.WarnContext(ctx, "gcache: failed to apply received invalidation", slog.Any(ErrKey, err))
+	}
+}
+
+// flush removes all entries cached by the interceptor with the given apply
+// function. If the store fails to, e.g. it can't remove the entries by
+// prefix, they are made unreachable by moving the keys to the next epoch,
+// and are left to expire or to be evicted.
+func (c *Interceptor) flush(ctx context.Context, apply func(context.Context, Invalidation) error) {
+	// keys of all methods start with the slash
+	err := apply(ctx, Invalidation{Prefixes: []string{"/"}})
+	if err == nil {
+		return
+	}
+
+	c.epoch.Add(1)
+	if !errors.Is(err, ErrNotSupported) {
+		c.logger.WarnContext(ctx, "gcache: failed to flush the store, moved to the next epoch", slog.Any(ErrKey, err))
+	}
+}
+
+// track advances the generation and drops the pending writes and the
+// warmed entries, described by the invalidation. The returned
+// invalidation has no generation, if the interceptor is already there.
+func (c *Interceptor) track(inv Invalidation) Invalidation {
+	if inv.Generation > 0 && (c.generation == nil || !c.generation.advance(inv.Generation)) {
+		inv.Generation = 0 // already there
+	}
+
+	if c.writeBehind != nil {
+		c.writeBehind.discard(inv)
+	}
+	c.discardBatched(inv)
+
+	return inv
+}
+
+// invalidateLocal removes the local copies of the entries, described by
+// the invalidation, if the store is LocalInvalidator, or the entries
+// themselves otherwise, as the store is local as a whole.
+func invalidateLocal(ctx context.Context, s StoreV2, inv Invalidation) error {
+	if li, ok := s.(LocalInvalidator); ok {
+		if err := li.InvalidateLocal(ctx, inv); !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return invalidateStore(ctx, s, inv)
+}
+
+// invalidateStore removes the entries, described by the invalidation, from the store.
+func invalidateStore(ctx context.Context, s StoreV2, inv Invalidation) error {
+	var errs []error
+
+	if len(inv.Keys) > 0 {
+		if err := deleteMulti(ctx, s, inv.Keys); err != nil {
+			errs = append(errs, fmt.Errorf("delete keys: %w", err))
+		}
+	}
+
+	if len(inv.Prefixes) > 0 {
+		if pr, ok := s.(PrefixRemover); ok {
+			for _, prefix := range inv.Prefixes {
+				if err := pr.RemovePrefix(ctx, prefix); err != nil {
+					errs = append(errs, fmt.Errorf("remove prefix %s: %w", prefix, err))
+				}
+			}
+		} else {
+			errs = append(errs, ErrNotSupported)
+		}
+	}
+
+	if len(inv.Tags) > 0 {
+		if ti, ok := s.(TagInvalidator); ok {
+			if err := ti.InvalidateTags(ctx, inv.Tags...); err != nil {
+				errs = append(errs, fmt.Errorf("invalidate tags: %w", err))
+			}
+		} else {
+			errs = append(errs, ErrNotSupported)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,671 @@
+package gcache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MemcachedOption is a configuration option for the memcached store.
+type MemcachedOption func(*memcachedStore)
+
+// WithMemcachedTTL bounds the TTL of the entries, 0 means no bound.
+func WithMemcachedTTL(ttl time.Duration) MemcachedOption {
+	return func(m *memcachedStore) { m.ttl = ttl }
+}
+
+// WithMemcachedTimeout sets the timeout of dialing the server and of every
+// operation on the connection, 1 second by default. The deadline of the
+// context shortens it.
+func WithMemcachedTimeout(d time.Duration) MemcachedOption {
+	return func(m *memcachedStore) { m.timeout = d }
+}
+
+// WithMemcachedPoolSize sets the number of idle connections kept
+// to every server, 8 by default.
+func WithMemcachedPoolSize(n int) MemcachedOption {
+	return func(m *memcachedStore) { m.poolSize = n }
+}
+
+// WithMemcachedLogger sets the logger.
+func WithMemcachedLogger(l *slog.Logger) MemcachedOption {
+	return func(m *memcachedStore) { m.logger = l }
+}
+
+const (
+	// memcachedMaxKeyLen is the longest key memcached accepts.
+	memcachedMaxKeyLen = 250
+	// memcachedMaxRelativeTTL is the longest expiration time memcached
+	// treats as relative, the longer ones must be Unix timestamps.
+	memcachedMaxRelativeTTL = 30 * 24 * time.Hour
+	// memcachedPoints is the number of points of every server on the hash ring.
+	memcachedPoints = 160
+)
+
+// errMemcachedServer is returned when the server replies with an error,
+// which leaves the connection usable.
+var errMemcachedServer = errors.New("memcached server error")
+
+type memcachedStore struct {
+	servers []*memcachedServer
+	ring    []memcachedPoint // sorted by hash
+
+	ttl      time.Duration
+	timeout  time.Duration
+	poolSize int
+	logger   *slog.Logger
+	closed   atomic.Bool
+	now      func() time.Time
+}
+
+type memcachedPoint struct {
+	hash   uint32
+	server *memcachedServer
+}
+
+type memcachedServer struct {
+	addr string
+	idle chan *memcachedConn
+}
+
+type memcachedConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// NewMemcached makes a store on top of the memcached servers with the
+// given addresses, speaking the memcached text protocol. Keys are spread
+// across the servers by consistent hashing, so that adding or removing
+// a server moves only the keys of that server. Connections are pooled
+// per server.
+//
+// Entries are kept in the same binary records as the disk store does,
+// along with their keys, so that keys longer than memcached allows
+// are hashed without the risk of collisions. The store implements
+// CompareAndSwapper on top of the memcached CAS, and BatchStore,
+// pipelining the commands to every server. As memcached can't enumerate
+// its keys, the store supports neither invalidation by tags nor removal
+// by prefix.
+func NewMemcached(addrs []string, opts ...MemcachedOption) (Store, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no memcached servers")
+	}
+
+	m := &memcachedStore{
+		timeout:  time.Second,
+		poolSize: 8,
+		logger:   discardLogger,
+		now:      time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	for _, addr := range addrs {
+		srv := &memcachedServer{addr: addr, idle: make(chan *memcachedConn, max(m.poolSize, 0))}
+		m.servers = append(m.servers, srv)
+		for i := range memcachedPoints {
+			h := crc32.ChecksumIEEE([]byte(addr + "-" + strconv.Itoa(i)))
+			m.ring = append(m.ring, memcachedPoint{hash: h, server: srv})
+		}
+	}
+
+	slices.SortFunc(m.ring, func(a, b memcachedPoint) int {
+		switch {
+		case a.hash < b.hash:
+			return -1
+		case a.hash > b.hash:
+			return 1
+		}
+		return strings.Compare(a.server.addr, b.server.addr)
+	})
+
+	return m, nil
+}
+
+// Get returns the value for the given key.
+func (m *memcachedStore) Get(ctx context.Context, key string) (Entry, bool) {
+	return legacyStore{v2: m, logger: m.logger}.Get(ctx, key)
+}
+
+// Set sets the value for the given key.
+func (m *memcachedStore) Set(ctx context.Context, key string, e Entry) {
+	legacyStore{v2: m, logger: m.logger}.Set(ctx, key, e)
+}
+
+// Remove removes the value for the given key.
+func (m *memcachedStore) Remove(ctx context.Context, key string) {
+	legacyStore{v2: m, logger: m.logger}.Remove(ctx, key)
+}
+
+// Load returns the value for the given key, or ErrNotFound.
+func (m *memcachedStore) Load(ctx context.Context, key string) (Entry, error) {
+	var (
+		e     Entry
+		found bool
+		derr  error
+	)
+
+	mkey := memcachedKey(key)
+	err := m.do(ctx, m.server(mkey), func(c *memcachedConn) error {
+		return c.gets([]string{mkey}, func(_ string, value []byte, _ uint64) {
+			e, found, derr = m.decode(key, value)
+		})
+	})
+	switch {
+	case err != nil:
+		return Entry{}, fmt.Errorf("get %s: %w", key, err)
+	case derr != nil:
+		return Entry{}, fmt.Errorf("decode %s: %w", key, derr)
+	case !found:
+		return Entry{}, ErrNotFound
+	}
+
+	return e, nil
+}
+
+// Save sets the value for the given key.
+func (m *memcachedStore) Save(ctx context.Context, key string, e Entry) error {
+	exptime, expired := m.exptime(e)
+	if expired {
+		return m.Delete(ctx, key)
+	}
+
+	mkey := memcachedKey(key)
+	err := m.do(ctx, m.server(mkey), func(c *memcachedConn) error {
+		if err := c.store("set", mkey, exptime, appendRecord(nil, key, e), 0); err != nil {
+			return err
+		}
+		_, err := c.reply()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("set %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// CompareAndSwap saves the entry only if the stored one has the given ETag,
+// or, if the ETag is empty, only if there is no stored entry.
+func (m *memcachedStore) CompareAndSwap(ctx context.Context, key, etag string, e Entry) (swapped bool, err error) {
+	exptime, expired := m.exptime(e)
+	if expired {
+		return false, m.Delete(ctx, key)
+	}
+
+	mkey := memcachedKey(key)
+	rec := appendRecord(nil, key, e)
+	err = m.do(ctx, m.server(mkey), func(c *memcachedConn) error {
+		if etag == "" {
+			if err := c.store("add", mkey, exptime, rec, 0); err != nil {
+				return err
+			}
+			reply, err := c.reply()
+			swapped = reply == "STORED"
+			return err
+		}
+
+		var (
+			stored Entry
+			found  bool
+			unique uint64
+			derr   error
+		)
+		err := c.gets([]string{mkey}, func(_ string, value []byte, cas uint64) {
+			stored, found, derr = m.decode(key, value)
+			unique = cas
+		})
+		if err != nil || derr != nil || !found || stored.ETag != etag {
+			return err
+		}
+
+		if err = c.store("cas", mkey, exptime, rec, unique); err != nil {
+			return err
+		}
+		reply, err := c.reply()
+		swapped = reply == "STORED"
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("compare and swap %s: %w", key, err)
+	}
+
+	return swapped, nil
+}
+
+// Delete removes the value for the given key.
+func (m *memcachedStore) Delete(ctx context.Context, key string) error {
+	mkey := memcachedKey(key)
+	err := m.do(ctx, m.server(mkey), func(c *memcachedConn) error {
+		if err := c.command("delete", mkey); err != nil {
+			return err
+		}
+		_, err := c.reply()
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// InvalidateLocal does nothing, as the store doesn't keep local copies
+// of the entries, the invalidations received from other instances are
+// already applied to memcached by their publisher.
+func (m *memcachedStore) InvalidateLocal(context.Context, Invalidation) error { return nil }
+
+// GetMulti returns the entries found for the given keys, getting them
+// from every server with a single command.
+func (m *memcachedStore) GetMulti(ctx context.Context, keys []string) (map[string]Entry, error) {
+	res := make(map[string]Entry, len(keys))
+	for srv, keys := range m.byServer(keys) {
+		originals := make(map[string]string, len(keys))
+		mkeys := make([]string, len(keys))
+		for i, key := range keys {
+			mkeys[i] = memcachedKey(key)
+			originals[mkeys[i]] = key
+		}
+
+		var derr error
+		err := m.do(ctx, srv, func(c *memcachedConn) error {
+			return c.gets(mkeys, func(mkey string, value []byte, _ uint64) {
+				key, ok := originals[mkey]
+				if !ok {
+					return
+				}
+				e, found, err := m.decode(key, value)
+				if err != nil {
+					derr = errors.Join(derr, fmt.Errorf("decode %s: %w", key, err))
+					return
+				}
+				if found {
+					res[key] = e
+				}
+			})
+		})
+		if err = errors.Join(err, derr); err != nil {
+			return nil, fmt.Errorf("get from %s: %w", srv.addr, err)
+		}
+	}
+
+	return res, nil
+}
+
+// SetMulti saves the entries, pipelining the commands to every server.
+func (m *memcachedStore) SetMulti(ctx context.Context, entries map[string]Entry) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+
+	for srv, keys := range m.byServer(keys) {
+		err := m.pipeline(ctx, srv, keys, func(c *memcachedConn, key string) error {
+			e := entries[key]
+			exptime, expired := m.exptime(e)
+			if expired {
+				return c.command("delete", memcachedKey(key))
+			}
+			return c.store("set", memcachedKey(key), exptime, appendRecord(nil, key, e), 0)
+		})
+		if err != nil {
+			return fmt.Errorf("set to %s: %w", srv.addr, err)
+		}
+	}
+
+	return nil
+}
+
+// RemoveMulti removes the entries, pipelining the commands to every server.
+func (m *memcachedStore) RemoveMulti(ctx context.Context, keys []string) error {
+	for srv, keys := range m.byServer(keys) {
+		err := m.pipeline(ctx, srv, keys, func(c *memcachedConn, key string) error {
+			return c.command("delete", memcachedKey(key))
+		})
+		if err != nil {
+			return fmt.Errorf("delete from %s: %w", srv.addr, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckHealth checks that every server responds to the version command.
+func (m *memcachedStore) CheckHealth(ctx context.Context) error {
+	var errs []error
+	for _, srv := range m.servers {
+		err := m.do(ctx, srv, func(c *memcachedConn) error {
+			if err := c.command("version"); err != nil {
+				return err
+			}
+			reply, err := c.reply()
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(reply, "VERSION ") {
+				return fmt.Errorf("unexpected reply %q", reply)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("check %s: %w", srv.addr, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close closes the idle connections, connections in use
+// are closed as they are released. It is safe to call it more than once.
+func (m *memcachedStore) Close() error {
+	m.closed.Store(true)
+
+	var errs []error
+	for _, srv := range m.servers {
+		for done := false; !done; {
+			select {
+			case c := <-srv.idle:
+				errs = append(errs, c.Close())
+			default:
+				done = true
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// server returns the server that owns the memcached key: the first one
+// clockwise from the hash of the key on the ring.
+func (m *memcachedStore) server(mkey string) *memcachedServer {
+	if len(m.servers) == 1 {
+		return m.servers[0]
+	}
+
+	h := crc32.ChecksumIEEE([]byte(mkey))
+	i, _ := slices.BinarySearchFunc(m.ring, h, func(p memcachedPoint, h uint32) int {
+		switch {
+		case p.hash < h:
+			return -1
+		case p.hash > h:
+			return 1
+		}
+		return 0
+	})
+	if i == len(m.ring) {
+		i = 0
+	}
+
+	return m.ring[i].server
+}
+
+// byServer groups the keys by the servers that own them.
+func (m *memcachedStore) byServer(keys []string) map[*memcachedServer][]string {
+	res := map[*memcachedServer][]string{}
+	for _, key := range keys {
+		srv := m.server(memcachedKey(key))
+		res[srv] = append(res[srv], key)
+	}
+	return res
+}
+
+// pipeline writes the commands for the keys at once and then reads
+// a reply to each of them.
+func (m *memcachedStore) pipeline(ctx context.Context, srv *memcachedServer, keys []string, write func(*memcachedConn, string) error) error {
+	return m.do(ctx, srv, func(c *memcachedConn) error {
+		for _, key := range keys {
+			if err := write(c, key); err != nil {
+				return err
+			}
+		}
+
+		if err := c.w.Flush(); err != nil {
+			return err
+		}
+
+		var errs []error
+		for range keys {
+			if _, err := c.reply(); err != nil {
+				if !errors.Is(err, errMemcachedServer) {
+					return err
+				}
+				errs = append(errs, err)
+			}
+		}
+
+		return errors.Join(errs...)
+	})
+}
+
+// do runs fn on a pooled connection to the server. The connection is
+// returned to the pool, unless fn fails with anything but the server's
+// error reply, which might leave the connection out of sync.
+func (m *memcachedStore) do(ctx context.Context, srv *memcachedServer, fn func(c *memcachedConn) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(m.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+
+	c, err := m.conn(ctx, srv, deadline)
+	if err != nil {
+		return err
+	}
+
+	if err = c.SetDeadline(deadline); err != nil {
+		_ = c.Close()
+		return fmt.Errorf("set deadline: %w", err)
+	}
+
+	err = fn(c)
+	if err != nil && !errors.Is(err, errMemcachedServer) {
+		_ = c.Close()
+		return err
+	}
+
+	if m.closed.Load() {
+		_ = c.Close()
+		return err
+	}
+
+	select {
+	case srv.idle <- c:
+	default:
+		_ = c.Close()
+	}
+
+	return err
+}
+
+// conn takes an idle connection to the server or dials a new one.
+func (m *memcachedStore) conn(ctx context.Context, srv *memcachedServer, deadline time.Time) (*memcachedConn, error) {
+	select {
+	case c := <-srv.idle:
+		return c, nil
+	default:
+	}
+
+	d := net.Dialer{Deadline: deadline}
+	conn, err := d.DialContext(ctx, "tcp", srv.addr)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", srv.addr, err)
+	}
+
+	return &memcachedConn{Conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+// exptime returns the memcached expiration time of the entry, or false,
+// if the entry is already expired. Memcached counts the time in seconds,
+// so the expiration time is rounded up, Load checks the exact expiry.
+func (m *memcachedStore) exptime(e Entry) (exptime int64, expired bool) {
+	now := m.now()
+
+	ttl := m.ttl
+	if !e.ExpiresAt.IsZero() {
+		until := e.ExpiresAt.Sub(now)
+		if until <= 0 {
+			return 0, true
+		}
+		if ttl <= 0 || until < ttl {
+			ttl = until
+		}
+	}
+
+	if ttl <= 0 {
+		return 0, false
+	}
+
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if ttl > memcachedMaxRelativeTTL {
+		return now.Unix() + secs, false
+	}
+
+	return secs, false
+}
+
+// decode decodes the stored record, reporting whether it holds
+// the live entry of the given key.
+func (m *memcachedStore) decode(key string, value []byte) (Entry, bool, error) {
+	storedKey, e, err := readRecord(bufio.NewReader(bytes.NewReader(value)))
+	switch {
+	case err != nil:
+		return Entry{}, false, err
+	case storedKey != key, e.expired(m.now()):
+		// the hashed key collided with another one
+		return Entry{}, false, nil
+	}
+
+	return e, true, nil
+}
+
+// memcachedKey returns the key of the entry in memcached: the key itself,
+// if memcached accepts it, or its SHA-256 otherwise.
+func memcachedKey(key string) string {
+	valid := len(key) > 0 && len(key) <= memcachedMaxKeyLen
+	for i := 0; valid && i < len(key); i++ {
+		valid = key[i] > ' ' && key[i] != 0x7f
+	}
+
+	if valid {
+		return key
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return "gcache:sha256:" + hex.EncodeToString(sum[:])
+}
+
+// command writes the command line, without flushing it.
+func (c *memcachedConn) command(args ...string) error {
+	_, err := c.w.WriteString(strings.Join(args, " ") + "\r\n")
+	return err
+}
+
+// store writes the storage command with its data block, without flushing it.
+// unique is written only for the "cas" command.
+func (c *memcachedConn) store(cmd, mkey string, exptime int64, data []byte, unique uint64) error {
+	args := []string{cmd, mkey, "0", strconv.FormatInt(exptime, 10), strconv.Itoa(len(data))}
+	if cmd == "cas" {
+		args = append(args, strconv.FormatUint(unique, 10))
+	}
+
+	if err := c.command(args...); err != nil {
+		return err
+	}
+
+	if _, err := c.w.Write(data); err != nil {
+		return err
+	}
+
+	_, err := c.w.WriteString("\r\n")
+	return err
+}
+
+// gets retrieves the items with their CAS values, calling fn for each found one.
+func (c *memcachedConn) gets(mkeys []string, fn func(mkey string, value []byte, cas uint64)) error {
+	if err := c.command(append([]string{"gets"}, mkeys...)...); err != nil {
+		return err
+	}
+
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	for {
+		line, err := c.line()
+		if err != nil {
+			return err
+		}
+
+		if line == "END" {
+			return nil
+		}
+
+		// VALUE <key> <flags> <bytes> <cas unique>
+		args := strings.Fields(line)
+		if len(args) != 5 || args[0] != "VALUE" {
+			return fmt.Errorf("unexpected reply %q", line)
+		}
+
+		size, serr := strconv.Atoi(args[3])
+		cas, cerr := strconv.ParseUint(args[4], 10, 64)
+		if serr != nil || cerr != nil || size < 0 {
+			return fmt.Errorf("unexpected reply %q", line)
+		}
+
+		value := make([]byte, size+2)
+		if _, err = io.ReadFull(c.r, value); err != nil {
+			return fmt.Errorf("read value: %w", err)
+		}
+
+		fn(args[1], value[:size], cas)
+	}
+}
+
+// reply flushes the written commands and reads the reply line,
+// the error replies are returned as errMemcachedServer.
+func (c *memcachedConn) reply() (string, error) {
+	if err := c.w.Flush(); err != nil {
+		return "", err
+	}
+	return c.line()
+}
+
+// line reads the reply line.
+func (c *memcachedConn) line() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("read reply: %w", err)
+	}
+
+	line = strings.TrimSuffix(line, "\r\n")
+	switch {
+	case line == "ERROR":
+		return "", fmt.Errorf("%w: unknown command", errMemcachedServer)
+	case strings.HasPrefix(line, "SERVER_ERROR "):
+		return "", fmt.Errorf("%w: %s", errMemcachedServer, strings.TrimPrefix(line, "SERVER_ERROR "))
+	case strings.HasPrefix(line, "CLIENT_ERROR "):
+		// the server might have lost track of the commands
+		return "", fmt.Errorf("client error: %s", strings.TrimPrefix(line, "CLIENT_ERROR "))
+	}
+
+	return line, nil
+}
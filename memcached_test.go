@@ -0,0 +1,244 @@
+package gcache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cappuccinotm/gcache/internal/memcachedtest"
+)
+
+func newTestMemcachedStore(t *testing.T, servers []*memcachedtest.Server, opts ...MemcachedOption) *memcachedStore {
+	addrs := make([]string, len(servers))
+	for i, srv := range servers {
+		addrs[i] = srv.Addr()
+	}
+
+	store, err := NewMemcached(addrs, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.(*memcachedStore).Close() })
+
+	return store.(*memcachedStore)
+}
+
+func TestMemcachedStore_LoadSave(t *testing.T) {
+	ctx := context.Background()
+	srv := memcachedtest.NewServer(t)
+	store := newTestMemcachedStore(t, []*memcachedtest.Server{srv}, WithMemcachedTTL(time.Hour))
+
+	expiresAt := time.Unix(0, time.Now().Add(time.Minute).UnixNano())
+	e := Entry{Value: []byte("order"), ETag: "v1", Tags: []string{"order:1"}, ExpiresAt: expiresAt, Cost: time.Millisecond}
+	require.NoError(t, store.Save(ctx, "/svc.Orders/Get{01}", e))
+	assert.InDelta(t, time.Minute, srv.TTL("/svc.Orders/Get{01}"), float64(time.Second))
+
+	got, err := store.Load(ctx, "/svc.Orders/Get{01}")
+	require.NoError(t, err)
+	assert.True(t, expiresAt.Equal(got.ExpiresAt))
+	got.ExpiresAt = expiresAt
+	assert.Equal(t, e, got)
+
+	require.NoError(t, store.Save(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("new")}))
+	assert.Equal(t, time.Hour, srv.TTL("/svc.Orders/Get{01}").Round(time.Second))
+
+	require.NoError(t, store.Delete(ctx, "/svc.Orders/Get{01}"))
+	_, err = store.Load(ctx, "/svc.Orders/Get{01}")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	t.Run("expired", func(t *testing.T) {
+		require.NoError(t, store.Save(ctx, "a", Entry{ExpiresAt: time.Now().Add(time.Second)}))
+		srv.FastForward(2 * time.Second)
+		_, err := store.Load(ctx, "a")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		require.NoError(t, store.Save(ctx, "a", Entry{ExpiresAt: time.Now().Add(-time.Second)}))
+		assert.Empty(t, srv.Keys())
+	})
+
+	t.Run("long ttl", func(t *testing.T) {
+		srv := memcachedtest.NewServer(t)
+		store := newTestMemcachedStore(t, []*memcachedtest.Server{srv})
+		require.NoError(t, store.Save(ctx, "a", Entry{ExpiresAt: time.Now().Add(60 * 24 * time.Hour)}))
+		assert.InDelta(t, 60*24*time.Hour, srv.TTL("a"), float64(2*time.Second))
+	})
+
+	t.Run("long keys", func(t *testing.T) {
+		key := "/svc.Orders/Get{" + strings.Repeat("0", 300) + " }"
+		require.NoError(t, store.Save(ctx, key, Entry{Value: []byte("long")}))
+		assert.NotContains(t, srv.Keys(), key)
+
+		got, err := store.Load(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("long"), got.Value)
+
+		// an entry of another key under the same memcached key is a miss
+		srv.Set(memcachedKey("other"), appendRecord(nil, "another", Entry{}))
+		_, err = store.Load(ctx, "other")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		srv.Set("corrupt", []byte("garbage"))
+		_, err := store.Load(ctx, "corrupt")
+		assert.ErrorIs(t, err, ErrCorruptRecord)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		err := store.Save(ctx, "large", Entry{Value: make([]byte, memcachedtest.MaxItemSize)})
+		assert.ErrorIs(t, err, errMemcachedServer)
+
+		// the connection stays usable
+		require.NoError(t, store.Save(ctx, "small", Entry{}))
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		srv := memcachedtest.NewServer(t)
+		store := newTestMemcachedStore(t, []*memcachedtest.Server{srv}, WithMemcachedTimeout(100*time.Millisecond))
+		require.NoError(t, store.Save(ctx, "key", Entry{}))
+		srv.Close()
+
+		_, err := store.Load(ctx, "key")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrNotFound)
+		assert.Error(t, store.Save(ctx, "key", Entry{}))
+	})
+}
+
+func TestMemcachedStore_CompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	store := newTestMemcachedStore(t, []*memcachedtest.Server{memcachedtest.NewServer(t)})
+
+	swapped, err := store.CompareAndSwap(ctx, "key", "", Entry{Value: []byte("1"), ETag: "v1"})
+	require.NoError(t, err)
+	assert.True(t, swapped)
+
+	swapped, err = store.CompareAndSwap(ctx, "key", "", Entry{Value: []byte("2"), ETag: "v2"})
+	require.NoError(t, err)
+	assert.False(t, swapped, "entry already exists")
+
+	swapped, err = store.CompareAndSwap(ctx, "key", "v0", Entry{Value: []byte("2"), ETag: "v2"})
+	require.NoError(t, err)
+	assert.False(t, swapped, "entry was changed")
+
+	swapped, err = store.CompareAndSwap(ctx, "key", "v1", Entry{Value: []byte("2"), ETag: "v2"})
+	require.NoError(t, err)
+	assert.True(t, swapped)
+
+	e, err := store.Load(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", e.ETag)
+
+	swapped, err = store.CompareAndSwap(ctx, "missing", "v1", Entry{Value: []byte("2"), ETag: "v2"})
+	require.NoError(t, err)
+	assert.False(t, swapped)
+}
+
+func TestMemcachedStore_Servers(t *testing.T) {
+	ctx := context.Background()
+	servers := []*memcachedtest.Server{memcachedtest.NewServer(t), memcachedtest.NewServer(t), memcachedtest.NewServer(t)}
+	store := newTestMemcachedStore(t, servers)
+
+	keys := make([]string, 300)
+	for i := range keys {
+		keys[i] = methodPrefix("/svc.Orders/Get") + strings.Repeat("x", i%7) + string(rune('a'+i%26)) + time.Duration(i).String() + "}"
+		require.NoError(t, store.Save(ctx, keys[i], Entry{}))
+	}
+
+	total := 0
+	for _, srv := range servers {
+		n := len(srv.Keys())
+		assert.Greater(t, n, 50, "keys must be spread across the servers")
+		total += n
+	}
+	assert.Equal(t, len(keys), total, "every key is kept by a single server")
+
+	// removing a server moves only its keys
+	reduced := newTestMemcachedStore(t, servers[:2])
+	for _, key := range keys {
+		owner := store.server(key).addr
+		if owner == servers[2].Addr() {
+			continue
+		}
+		assert.Equal(t, owner, reduced.server(key).addr, key)
+	}
+}
+
+func TestMemcachedStore_Batch(t *testing.T) {
+	ctx := context.Background()
+	servers := []*memcachedtest.Server{memcachedtest.NewServer(t), memcachedtest.NewServer(t)}
+	store := newTestMemcachedStore(t, servers)
+
+	entries := map[string]Entry{"expired": {Value: []byte("x"), ExpiresAt: time.Now().Add(-time.Second)}}
+	keys := []string{"expired", "missing"}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		entries[key] = Entry{Value: []byte(key), ETag: "v-" + key}
+		keys = append(keys, key)
+	}
+
+	require.NoError(t, store.SetMulti(ctx, entries))
+	assert.Equal(t, 6, len(servers[0].Keys())+len(servers[1].Keys()))
+
+	got, err := store.GetMulti(ctx, keys)
+	require.NoError(t, err)
+	delete(entries, "expired")
+	assert.Equal(t, entries, got)
+
+	require.NoError(t, store.RemoveMulti(ctx, []string{"a", "b", "c", "missing"}))
+	got, err = store.GetMulti(ctx, keys)
+	require.NoError(t, err)
+	assert.Len(t, got, 3)
+	assert.NotContains(t, got, "a")
+}
+
+func TestMemcachedStore_Pool(t *testing.T) {
+	ctx := context.Background()
+	srv := memcachedtest.NewServer(t)
+	store := newTestMemcachedStore(t, []*memcachedtest.Server{srv}, WithMemcachedPoolSize(2))
+
+	for range 10 {
+		require.NoError(t, store.Save(ctx, "key", Entry{}))
+	}
+	assert.Equal(t, 1, srv.Accepted(), "connection must be reused")
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				_, err := store.Load(ctx, "key")
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, store.servers[0].idle, 2, "idle connections are bounded by the pool size")
+
+	require.NoError(t, store.Close())
+	assert.Empty(t, store.servers[0].idle)
+}
+
+func TestMemcachedStore_CheckHealth(t *testing.T) {
+	ctx := context.Background()
+	srv1, srv2 := memcachedtest.NewServer(t), memcachedtest.NewServer(t)
+	store := newTestMemcachedStore(t, []*memcachedtest.Server{srv1, srv2})
+
+	require.NoError(t, store.CheckHealth(ctx))
+
+	srv2.Close()
+	err := store.CheckHealth(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), srv2.Addr())
+
+	require.NoError(t, store.Close())
+	require.NoError(t, store.Close(), "must be safe to close twice")
+}
+
+func TestNewMemcached(t *testing.T) {
+	_, err := NewMemcached(nil)
+	assert.Error(t, err)
+}
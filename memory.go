@@ -0,0 +1,269 @@
+package gcache
+
+import (
+	"cmp"
+	"container/heap"
+	"context"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryOption is a configuration option for the memory store.
+type MemoryOption func(*memoryStore)
+
+// WithMemoryCostAware makes the store to take into account the time it
+// took to produce the entry, so that among the entries of the same size,
+// the ones that are cheap to recompute are evicted first (GreedyDual-Size).
+// By default, the least recently used entries are evicted first.
+func WithMemoryCostAware(enabled bool) MemoryOption {
+	return func(m *memoryStore) { m.costAware = enabled }
+}
+
+// WithMemoryObserver sets the observer to report evictions to.
+func WithMemoryObserver(o Observer) MemoryOption {
+	return func(m *memoryStore) { m.observer = o }
+}
+
+// memoryEntryOverhead is the approximate size of the bookkeeping of the entry.
+const memoryEntryOverhead = 128
+
+type memoryStore struct {
+	mu       sync.Mutex
+	items    map[string]*memoryItem
+	queue    memoryQueue
+	size     int64
+	maxBytes int64
+	clock    float64 // priority of the last evicted entry
+	seq      uint64  // access counter for LRU
+
+	costAware bool
+	observer  Observer
+	index     tagIndex
+	now       func() time.Time
+}
+
+type memoryItem struct {
+	key      string
+	entry    Entry
+	size     int64
+	priority float64
+	pos      int // position in the queue
+}
+
+// NewMemory makes an in-memory store, bounded by the total size of the
+// entries in bytes. The size of the entry is the total length of its key,
+// value, ETag and tags, plus a small constant overhead.
+// Entries that exceed the budget by themselves are not stored.
+func NewMemory(maxBytes int64, opts ...MemoryOption) Store {
+	m := &memoryStore{
+		items:    map[string]*memoryItem{},
+		maxBytes: maxBytes,
+		observer: nopObserver{},
+		now:      time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Get returns the value for the given key.
+func (m *memoryStore) Get(ctx context.Context, key string) (Entry, bool) {
+	return legacyStore{v2: m, logger: discardLogger}.Get(ctx, key)
+}
+
+// Set sets the value for the given key.
+func (m *memoryStore) Set(ctx context.Context, key string, e Entry) {
+	legacyStore{v2: m, logger: discardLogger}.Set(ctx, key, e)
+}
+
+// Remove removes the value for the given key.
+func (m *memoryStore) Remove(ctx context.Context, key string) {
+	legacyStore{v2: m, logger: discardLogger}.Remove(ctx, key)
+}
+
+// Load returns the value for the given key, or ErrNotFound.
+func (m *memoryStore) Load(_ context.Context, key string) (Entry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	it, ok := m.items[key]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+
+	if it.entry.expired(m.now()) {
+		m.remove(it)
+		return Entry{}, ErrNotFound
+	}
+
+	it.priority = m.priority(it)
+	heap.Fix(&m.queue, it.pos)
+
+	return it.entry, nil
+}
+
+// Save sets the value for the given key, evicting other entries
+// if the budget is exceeded.
+func (m *memoryStore) Save(ctx context.Context, key string, e Entry) error {
+	m.mu.Lock()
+
+	if it, ok := m.items[key]; ok {
+		m.remove(it)
+	}
+
+	it := &memoryItem{key: key, entry: e, size: entrySize(key, e)}
+	if it.size > m.maxBytes {
+		m.mu.Unlock()
+		m.observer.Observe(ctx, Event{Kind: EventEvict, Key: key, Size: it.size})
+		return nil
+	}
+
+	var evicted []*memoryItem
+	for m.size+it.size > m.maxBytes {
+		victim := m.queue[0]
+		m.clock = victim.priority
+		m.remove(victim)
+		evicted = append(evicted, victim)
+	}
+
+	it.priority = m.priority(it)
+	heap.Push(&m.queue, it)
+	m.items[key] = it
+	m.size += it.size
+	m.index.add(key, e.Tags)
+
+	m.mu.Unlock()
+
+	for _, victim := range evicted {
+		m.observer.Observe(ctx, Event{Kind: EventEvict, Key: victim.key, Size: victim.size})
+	}
+
+	return nil
+}
+
+// Delete removes the value for the given key.
+func (m *memoryStore) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if it, ok := m.items[key]; ok {
+		m.remove(it)
+	}
+
+	return nil
+}
+
+// InvalidateTags removes all entries that carry any of the given tags.
+func (m *memoryStore) InvalidateTags(_ context.Context, tags ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.index.take(tags...) {
+		if it, ok := m.items[key]; ok {
+			m.remove(it)
+		}
+	}
+
+	return nil
+}
+
+// RemovePrefix removes all entries whose keys start with the given prefix.
+func (m *memoryStore) RemovePrefix(_ context.Context, prefix string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, it := range m.items {
+		if strings.HasPrefix(key, prefix) {
+			m.remove(it)
+		}
+	}
+
+	return nil
+}
+
+// Range calls fn for the entries in the order of their eviction.
+func (m *memoryStore) Range(_ context.Context, fn func(key string, e Entry) bool) error {
+	m.mu.Lock()
+	// items are copied, as Load and Save update them in place
+	items := make([]memoryItem, 0, len(m.queue))
+	for _, it := range m.queue {
+		items = append(items, *it)
+	}
+	now := m.now()
+	m.mu.Unlock()
+
+	slices.SortFunc(items, func(a, b memoryItem) int { return cmp.Compare(a.priority, b.priority) })
+	for _, it := range items {
+		if it.entry.expired(now) {
+			continue
+		}
+		if !fn(it.key, it.entry) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// remove drops the item from the store, must be called under the lock.
+func (m *memoryStore) remove(it *memoryItem) {
+	heap.Remove(&m.queue, it.pos)
+	delete(m.items, it.key)
+	m.size -= it.size
+	m.index.remove(it.key)
+}
+
+// priority returns the priority of the item to be evicted, the lower
+// the priority is, the sooner the item is evicted.
+func (m *memoryStore) priority(it *memoryItem) float64 {
+	if !m.costAware {
+		m.seq++
+		return float64(m.seq)
+	}
+
+	cost := float64(max(it.entry.Cost.Microseconds(), 1))
+	return m.clock + cost/float64(it.size)
+}
+
+// entrySize returns the approximate size of the entry in bytes.
+func entrySize(key string, e Entry) int64 {
+	size := len(key) + len(e.Value) + len(e.ETag) + memoryEntryOverhead
+	for _, tag := range e.Tags {
+		size += len(tag)
+	}
+	return int64(size)
+}
+
+// memoryQueue is a min-heap of the items by their priority.
+type memoryQueue []*memoryItem
+
+func (q memoryQueue) Len() int           { return len(q) }
+func (q memoryQueue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+
+func (q memoryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].pos, q[j].pos = i, j
+}
+
+func (q *memoryQueue) Push(x any) {
+	it := x.(*memoryItem)
+	it.pos = len(*q)
+	*q = append(*q, it)
+}
+
+func (q *memoryQueue) Pop() any {
+	old := *q
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return it
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
@@ -0,0 +1,96 @@
+package gcache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	value := func(n int) []byte { return []byte(strings.Repeat("x", n)) }
+
+	t.Run("evicts least recently used within the budget", func(t *testing.T) {
+		var evicted []string
+		store := NewMemory(3*(memoryEntryOverhead+101), WithMemoryObserver(ObserverFunc(func(_ context.Context, ev Event) {
+			assert.Equal(t, EventEvict, ev.Kind)
+			assert.Equal(t, int64(memoryEntryOverhead+101), ev.Size)
+			evicted = append(evicted, ev.Key)
+		})))
+
+		store.Set(ctx, "a", Entry{Value: value(100)})
+		store.Set(ctx, "b", Entry{Value: value(100)})
+		store.Set(ctx, "c", Entry{Value: value(100)})
+		_, ok := store.Get(ctx, "a")
+		require.True(t, ok)
+
+		store.Set(ctx, "d", Entry{Value: value(100)})
+		assert.Equal(t, []string{"b"}, evicted)
+
+		for key, present := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+			_, ok = store.Get(ctx, key)
+			assert.Equal(t, present, ok, key)
+		}
+
+		assert.Equal(t, int64(3*(memoryEntryOverhead+101)), store.(*memoryStore).size)
+	})
+
+	t.Run("counts value and etag", func(t *testing.T) {
+		store := NewMemory(2 * (memoryEntryOverhead + 101))
+
+		store.Set(ctx, "a", Entry{Value: value(50), ETag: string(value(50))})
+		store.Set(ctx, "b", Entry{Value: value(100)})
+		store.Set(ctx, "c", Entry{Value: value(1), ETag: string(value(99))})
+
+		_, ok := store.Get(ctx, "a")
+		assert.False(t, ok)
+	})
+
+	t.Run("oversized entries are not stored", func(t *testing.T) {
+		store := NewMemory(memoryEntryOverhead + 10)
+		store.Set(ctx, "a", Entry{Value: value(100)})
+		_, ok := store.Get(ctx, "a")
+		assert.False(t, ok)
+		assert.Zero(t, store.(*memoryStore).size)
+	})
+
+	t.Run("cost-aware eviction keeps expensive entries", func(t *testing.T) {
+		store := NewMemory(3*(memoryEntryOverhead+101), WithMemoryCostAware(true))
+
+		store.Set(ctx, "expensive", Entry{Value: value(100), Cost: time.Second})
+		store.Set(ctx, "cheap", Entry{Value: value(100), Cost: time.Millisecond})
+		store.Set(ctx, "medium", Entry{Value: value(100), Cost: 100 * time.Millisecond})
+		store.Set(ctx, "new", Entry{Value: value(100), Cost: 10 * time.Millisecond})
+
+		_, ok := store.Get(ctx, "cheap")
+		assert.False(t, ok)
+		_, ok = store.Get(ctx, "expensive")
+		assert.True(t, ok)
+	})
+
+	t.Run("expiry, tags and prefixes", func(t *testing.T) {
+		store := NewMemory(1 << 20).(*memoryStore)
+
+		store.Set(ctx, "/svc.Orders/Get{01}", Entry{Value: value(1), Tags: []string{"order:1"}})
+		store.Set(ctx, "/svc.Orders/List{01}", Entry{Value: value(1)})
+		store.Set(ctx, "expiring", Entry{Value: value(1), ExpiresAt: time.Now().Add(time.Minute)})
+
+		require.NoError(t, store.InvalidateTags(ctx, "order:1"))
+		_, ok := store.Get(ctx, "/svc.Orders/Get{01}")
+		assert.False(t, ok)
+
+		require.NoError(t, store.RemovePrefix(ctx, "/svc.Orders/"))
+		_, ok = store.Get(ctx, "/svc.Orders/List{01}")
+		assert.False(t, ok)
+
+		store.now = func() time.Time { return time.Now().Add(time.Hour) }
+		_, ok = store.Get(ctx, "expiring")
+		assert.False(t, ok)
+		assert.Empty(t, store.items)
+		assert.Zero(t, store.size)
+	})
+}
@@ -0,0 +1,405 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// StoreMiddleware wraps the store with additional behavior.
+// Middlewares made with the built-in constructors pass the optional
+// capabilities of the store, such as TagInvalidator, PrefixRemover,
+// Ranger, BatchStore and CompareAndSwapper, through, returning
+// ErrNotSupported, if the wrapped store lacks them.
+type StoreMiddleware func(Store) Store
+
+// ChainStore wraps the base store with the middlewares,
+// the first middleware being the outermost one:
+//
+//	gcache.ChainStore(base, gcache.WithPrefix("svc:"), gcache.WithTimeout(20*time.Millisecond))
+//
+// applies the prefix to the keys, then runs the operation on the base
+// store within the timeout.
+func ChainStore(base Store, mws ...StoreMiddleware) Store {
+	for i := len(mws) - 1; i >= 0; i-- {
+		base = mws[i](base)
+	}
+	return base
+}
+
+// WithPrefix prepends the prefix to the keys of the entries. Range reports
+// only the entries with the prefix. Tags are left as they are, so that
+// invalidation by tags reaches the entries of all prefixes.
+func WithPrefix(prefix string) StoreMiddleware {
+	return func(s Store) Store { return prefixStore(AdaptStore(s), func() string { return prefix }) }
+}
+
+// prefixStore prepends the prefix, returned by the function, to the keys
+// of the next store, so that the prefix could change over time.
+func prefixStore(next StoreV2, prefix func() string) *middlewareStore {
+	return wrapStoreV2(next, middlewareStore{
+		mapKey:   func(key string) string { return prefix() + key },
+		unmapKey: func(key string) (string, bool) { return strings.CutPrefix(key, prefix()) },
+	})
+}
+
+// WithTimeout bounds every operation of the store with the timeout.
+func WithTimeout(d time.Duration) StoreMiddleware {
+	return func(s Store) Store {
+		return wrapStore(s, middlewareStore{
+			around: func(ctx context.Context, _, _ string, fn func(context.Context) error) error {
+				ctx, cancel := context.WithTimeout(ctx, d)
+				defer cancel()
+				return fn(ctx)
+			},
+		})
+	}
+}
+
+// WithMetrics reports every operation of the store to the observer
+// as EventStoreOp, with its duration and error.
+func WithMetrics(o Observer) StoreMiddleware {
+	return func(s Store) Store {
+		return wrapStore(s, middlewareStore{
+			around: func(ctx context.Context, op, key string, fn func(context.Context) error) error {
+				start := time.Now()
+				err := fn(ctx)
+				o.Observe(ctx, Event{Kind: EventStoreOp, Key: key, Op: op, Err: err, Duration: time.Since(start)})
+				return err
+			},
+		})
+	}
+}
+
+// WithLogging logs every operation of the store at debug level,
+// and the failed ones at warn level. Misses aren't failures.
+func WithLogging(l *slog.Logger) StoreMiddleware {
+	return func(s Store) Store {
+		return wrapStore(s, middlewareStore{
+			logger: l,
+			around: func(ctx context.Context, op, key string, fn func(context.Context) error) error {
+				start := time.Now()
+				err := fn(ctx)
+
+				attrs := []any{slog.String("op", op), slog.String("key", key), slog.Duration("duration", time.Since(start))}
+				if err != nil && !errors.Is(err, ErrNotFound) {
+					l.WarnContext(ctx, "gcache: store operation failed", append(attrs, slog.Any(ErrKey, err))...)
+					return err
+				}
+
+				l.DebugContext(ctx, "gcache: store operation", append(attrs, slog.Bool("found", err == nil))...)
+				return err
+			},
+		})
+	}
+}
+
+// middlewareStore forwards the operations to the next store, letting
+// the middleware run around them and transform the keys and the entries.
+// Unset hooks do nothing.
+type middlewareStore struct {
+	next   StoreV2
+	logger *slog.Logger
+
+	// around runs the operation of the store, key is empty
+	// for the operations over several keys.
+	around func(ctx context.Context, op, key string, fn func(context.Context) error) error
+
+	// mapKey maps the key, or the prefix, to the one of the next store,
+	// unmapKey maps it back, reporting whether the key belongs to the store.
+	mapKey   func(key string) string
+	unmapKey func(key string) (string, bool)
+
+	// encode transforms the entry before saving it to the next store,
+	// decode reverts it after loading. Entries that fail to decode
+	// with an error that wraps ErrNotFound are treated as misses,
+	// and, if drop is set, removed from the next store.
+	encode func(ctx context.Context, key string, e Entry) (Entry, error)
+	decode func(ctx context.Context, key string, e Entry) (Entry, error)
+	drop   bool
+}
+
+// wrapStore makes the middleware store on top of the given one.
+func wrapStore(s Store, m middlewareStore) *middlewareStore {
+	return wrapStoreV2(AdaptStore(s), m)
+}
+
+// wrapStoreV2 is wrapStore for the next store, that is StoreV2 only.
+func wrapStoreV2(next StoreV2, m middlewareStore) *middlewareStore {
+	m.next = next
+
+	if m.logger == nil {
+		m.logger = discardLogger
+	}
+
+	if m.around == nil {
+		m.around = func(ctx context.Context, _, _ string, fn func(context.Context) error) error { return fn(ctx) }
+	}
+
+	if m.mapKey == nil {
+		m.mapKey = func(key string) string { return key }
+		m.unmapKey = func(key string) (string, bool) { return key, true }
+	}
+
+	if m.encode == nil {
+		m.encode = func(_ context.Context, _ string, e Entry) (Entry, error) { return e, nil }
+		m.decode = m.encode
+	}
+
+	return &m
+}
+
+// Get returns the value for the given key.
+func (m *middlewareStore) Get(ctx context.Context, key string) (Entry, bool) {
+	return legacyStore{v2: m, logger: m.logger}.Get(ctx, key)
+}
+
+// Set sets the value for the given key.
+func (m *middlewareStore) Set(ctx context.Context, key string, e Entry) {
+	legacyStore{v2: m, logger: m.logger}.Set(ctx, key, e)
+}
+
+// Remove removes the value for the given key.
+func (m *middlewareStore) Remove(ctx context.Context, key string) {
+	legacyStore{v2: m, logger: m.logger}.Remove(ctx, key)
+}
+
+// Load returns the value for the given key, or ErrNotFound.
+func (m *middlewareStore) Load(ctx context.Context, key string) (e Entry, err error) {
+	err = m.around(ctx, "load", key, func(ctx context.Context) error {
+		stored, err := m.next.Load(ctx, m.mapKey(key))
+		if err != nil {
+			return err
+		}
+
+		if e, err = m.decode(ctx, key, stored); err != nil {
+			m.dropUndecodable(ctx, err, key)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Entry{}, err
+	}
+
+	return e, nil
+}
+
+// Save sets the value for the given key.
+func (m *middlewareStore) Save(ctx context.Context, key string, e Entry) error {
+	return m.around(ctx, "save", key, func(ctx context.Context) error {
+		e, err := m.encode(ctx, key, e)
+		if err != nil {
+			return err
+		}
+		return m.next.Save(ctx, m.mapKey(key), e)
+	})
+}
+
+// Delete removes the value for the given key.
+func (m *middlewareStore) Delete(ctx context.Context, key string) error {
+	return m.around(ctx, "delete", key, func(ctx context.Context) error {
+		return m.next.Delete(ctx, m.mapKey(key))
+	})
+}
+
+// InvalidateTags removes all entries that carry any of the given tags.
+func (m *middlewareStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	ti, ok := m.next.(TagInvalidator)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return m.around(ctx, "invalidate_tags", "", func(ctx context.Context) error {
+		return ti.InvalidateTags(ctx, tags...)
+	})
+}
+
+// RemovePrefix removes all entries whose keys start with the given prefix.
+func (m *middlewareStore) RemovePrefix(ctx context.Context, prefix string) error {
+	pr, ok := m.next.(PrefixRemover)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return m.around(ctx, "remove_prefix", prefix, func(ctx context.Context) error {
+		return pr.RemovePrefix(ctx, m.mapKey(prefix))
+	})
+}
+
+// InvalidateLocal removes the local copies of the entries of the next store.
+// It doesn't reach the backend, so that it bypasses the middleware.
+func (m *middlewareStore) InvalidateLocal(ctx context.Context, inv Invalidation) error {
+	li, ok := m.next.(LocalInvalidator)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	mapped := inv
+	mapped.Keys = make([]string, len(inv.Keys))
+	for i, key := range inv.Keys {
+		mapped.Keys[i] = m.mapKey(key)
+	}
+	mapped.Prefixes = make([]string, len(inv.Prefixes))
+	for i, prefix := range inv.Prefixes {
+		mapped.Prefixes[i] = m.mapKey(prefix)
+	}
+
+	return li.InvalidateLocal(ctx, mapped)
+}
+
+// CompareAndSwap saves the entry only if the stored one has the given ETag,
+// or, if the ETag is empty, only if there is no stored entry.
+func (m *middlewareStore) CompareAndSwap(ctx context.Context, key, etag string, e Entry) (swapped bool, err error) {
+	cas, ok := m.next.(CompareAndSwapper)
+	if !ok {
+		return false, ErrNotSupported
+	}
+
+	err = m.around(ctx, "compare_and_swap", key, func(ctx context.Context) error {
+		e, err := m.encode(ctx, key, e)
+		if err != nil {
+			return err
+		}
+		swapped, err = cas.CompareAndSwap(ctx, m.mapKey(key), etag, e)
+		return err
+	})
+
+	return swapped, err
+}
+
+// Increment atomically adds delta to the counter of the next store.
+func (m *middlewareStore) Increment(ctx context.Context, key string, delta uint64) (n uint64, err error) {
+	inc, ok := m.next.(Incrementer)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	err = m.around(ctx, "increment", key, func(ctx context.Context) error {
+		n, err = inc.Increment(ctx, m.mapKey(key), delta)
+		return err
+	})
+
+	return n, err
+}
+
+// Range calls fn for the entries of the store, skipping the ones
+// that fail to decode.
+func (m *middlewareStore) Range(ctx context.Context, fn func(key string, e Entry) bool) error {
+	r, ok := m.next.(Ranger)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return m.around(ctx, "range", "", func(ctx context.Context) error {
+		return r.Range(ctx, func(key string, e Entry) bool {
+			key, ok := m.unmapKey(key)
+			if !ok {
+				return true
+			}
+
+			e, err := m.decode(ctx, key, e)
+			if err != nil {
+				m.logger.WarnContext(ctx, "gcache: skipping entry that failed to decode",
+					slog.String("key", key), slog.Any(ErrKey, err))
+				return true
+			}
+
+			return fn(key, e)
+		})
+	})
+}
+
+// GetMulti returns the entries found for the given keys.
+func (m *middlewareStore) GetMulti(ctx context.Context, keys []string) (res map[string]Entry, err error) {
+	err = m.around(ctx, "get_multi", "", func(ctx context.Context) error {
+		mapped := make(map[string]string, len(keys))
+		mkeys := make([]string, len(keys))
+		for i, key := range keys {
+			mkeys[i] = m.mapKey(key)
+			mapped[mkeys[i]] = key
+		}
+
+		entries, err := loadMulti(ctx, m.next, mkeys)
+		if err != nil {
+			return err
+		}
+
+		res = make(map[string]Entry, len(entries))
+		for mkey, e := range entries {
+			key := mapped[mkey]
+			e, err := m.decode(ctx, key, e)
+			switch {
+			case errors.Is(err, ErrNotFound):
+				m.dropUndecodable(ctx, err, key)
+				continue
+			case err != nil:
+				return err
+			}
+			res[key] = e
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// SetMulti saves the entries.
+func (m *middlewareStore) SetMulti(ctx context.Context, entries map[string]Entry) error {
+	return m.around(ctx, "set_multi", "", func(ctx context.Context) error {
+		encoded := make(map[string]Entry, len(entries))
+		for key, e := range entries {
+			e, err := m.encode(ctx, key, e)
+			if err != nil {
+				return err
+			}
+			encoded[m.mapKey(key)] = e
+		}
+
+		return saveMulti(ctx, m.next, encoded)
+	})
+}
+
+// RemoveMulti removes the entries for the given keys.
+func (m *middlewareStore) RemoveMulti(ctx context.Context, keys []string) error {
+	return m.around(ctx, "remove_multi", "", func(ctx context.Context) error {
+		mkeys := make([]string, len(keys))
+		for i, key := range keys {
+			mkeys[i] = m.mapKey(key)
+		}
+		return deleteMulti(ctx, m.next, mkeys)
+	})
+}
+
+// CheckHealth checks the health of the next store, bypassing the middleware.
+func (m *middlewareStore) CheckHealth(ctx context.Context) error {
+	return checkHealth(ctx, m.next)
+}
+
+// Close closes the next store, if it is io.Closer.
+func (m *middlewareStore) Close() error {
+	return closeStore(m.next)
+}
+
+// dropUndecodable removes the entry that failed to decode as a miss,
+// if the middleware asks for it.
+func (m *middlewareStore) dropUndecodable(ctx context.Context, err error, key string) {
+	if !m.drop || !errors.Is(err, ErrNotFound) {
+		return
+	}
+
+	m.logger.WarnContext(ctx, "gcache: removing entry that failed to decode",
+		slog.String("key", key), slog.Any(ErrKey, err))
+
+	if err = m.next.Delete(ctx, m.mapKey(key)); err != nil {
+		m.logger.WarnContext(ctx, "gcache: failed to remove entry",
+			slog.String("key", key), slog.Any(ErrKey, err))
+	}
+}
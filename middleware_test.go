@@ -0,0 +1,184 @@
+package gcache
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChainStore(t *testing.T) {
+	ctx := context.Background()
+
+	var calls []string
+	trace := func(name string) StoreMiddleware {
+		return func(s Store) Store {
+			return wrapStore(s, middlewareStore{
+				around: func(ctx context.Context, op, _ string, fn func(context.Context) error) error {
+					calls = append(calls, name+":"+op)
+					return fn(ctx)
+				},
+			})
+		}
+	}
+
+	store := AdaptStore(ChainStore(NewMemory(1<<20), trace("outer"), trace("inner")))
+	require.NoError(t, store.Save(ctx, "a", Entry{}))
+	assert.Equal(t, []string{"outer:save", "inner:save"}, calls)
+
+	base := NewMemory(1 << 20)
+	assert.Same(t, base, ChainStore(base), "no middlewares leave the store as is")
+}
+
+func TestWithPrefix(t *testing.T) {
+	ctx := context.Background()
+	l, _ := lru.New[string, Entry](10)
+	base := NewLRU(l)
+	store := ChainStore(base, WithPrefix("svc:")).(*middlewareStore)
+
+	require.NoError(t, store.Save(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("1"), Tags: []string{"order:1"}}))
+	require.NoError(t, store.SetMulti(ctx, map[string]Entry{"/svc.Orders/Get{02}": {Value: []byte("2")}}))
+	base.Set(ctx, "other:/svc.Orders/Get{03}", Entry{Value: []byte("3")})
+
+	assert.ElementsMatch(t, []string{"svc:/svc.Orders/Get{01}", "svc:/svc.Orders/Get{02}", "other:/svc.Orders/Get{03}"}, l.Keys())
+
+	e, err := store.Load(ctx, "/svc.Orders/Get{01}")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), e.Value)
+
+	entries, err := store.GetMulti(ctx, []string{"/svc.Orders/Get{01}", "/svc.Orders/Get{02}", "/svc.Orders/Get{03}"})
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Contains(t, entries, "/svc.Orders/Get{02}")
+
+	var keys []string
+	require.NoError(t, store.Range(ctx, func(key string, _ Entry) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	assert.ElementsMatch(t, []string{"/svc.Orders/Get{01}", "/svc.Orders/Get{02}"}, keys)
+
+	require.NoError(t, store.RemovePrefix(ctx, "/svc.Orders/"))
+	assert.Equal(t, []string{"other:/svc.Orders/Get{03}"}, l.Keys())
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx := context.Background()
+	store := AdaptStore(ChainStore(blockingStore{}, WithTimeout(10*time.Millisecond)))
+
+	start := time.Now()
+	_, err := store.Load(ctx, "a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestWithMetrics(t *testing.T) {
+	ctx := context.Background()
+
+	var events []Event
+	store := ChainStore(NewMemory(1<<20), WithMetrics(ObserverFunc(func(_ context.Context, ev Event) {
+		events = append(events, ev)
+	}))).(*middlewareStore)
+
+	require.NoError(t, store.Save(ctx, "a", Entry{}))
+	_, err := store.Load(ctx, "b")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, store.RemoveMulti(ctx, []string{"a"}))
+
+	require.Len(t, events, 3)
+	for i, want := range []Event{
+		{Kind: EventStoreOp, Op: "save", Key: "a"},
+		{Kind: EventStoreOp, Op: "load", Key: "b", Err: ErrNotFound},
+		{Kind: EventStoreOp, Op: "remove_multi"},
+	} {
+		assert.GreaterOrEqual(t, events[i].Duration, time.Duration(0))
+		events[i].Duration = 0
+		assert.Equal(t, want, events[i])
+	}
+}
+
+func TestWithLogging(t *testing.T) {
+	ctx := context.Background()
+
+	buf := &bytes.Buffer{}
+	l := slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	store := AdaptStore(ChainStore(blockingStore{}, WithLogging(l), WithTimeout(time.Millisecond)))
+
+	_, err := store.Load(ctx, "a")
+	require.Error(t, err)
+	assert.Contains(t, buf.String(), "level=WARN")
+	assert.Contains(t, buf.String(), "op=load key=a")
+
+	buf.Reset()
+	store = AdaptStore(ChainStore(NewMemory(1<<20), WithLogging(l)))
+	_, err = store.Load(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Contains(t, buf.String(), "level=DEBUG")
+	assert.Contains(t, buf.String(), "found=false")
+}
+
+func TestMiddlewareStore_Capabilities(t *testing.T) {
+	ctx := context.Background()
+	mws := []StoreMiddleware{WithPrefix("p:"), WithTimeout(time.Second), WithMetrics(nopObserver{}), WithLogging(discardLogger)}
+
+	t.Run("passed through", func(t *testing.T) {
+		l, _ := lru.New[string, Entry](10)
+		store := ChainStore(NewLRU(l), mws...)
+
+		store.Set(ctx, "a", Entry{Tags: []string{"t"}})
+		store.Set(ctx, "b", Entry{})
+		require.NoError(t, store.(TagInvalidator).InvalidateTags(ctx, "t"))
+		_, ok := store.Get(ctx, "a")
+		assert.False(t, ok)
+
+		var snap bytes.Buffer
+		require.NoError(t, Snapshot(ctx, &snap, store.(Ranger)))
+		restored := ChainStore(NewMemory(1<<20), mws...)
+		require.NoError(t, Restore(ctx, &snap, AdaptStore(restored)))
+		_, ok = restored.Get(ctx, "b")
+		assert.True(t, ok)
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		store := ChainStore(nopStore{}, mws...)
+
+		assert.ErrorIs(t, store.(TagInvalidator).InvalidateTags(ctx, "t"), ErrNotSupported)
+		assert.ErrorIs(t, store.(PrefixRemover).RemovePrefix(ctx, "a"), ErrNotSupported)
+		assert.ErrorIs(t, store.(Ranger).Range(ctx, func(string, Entry) bool { return true }), ErrNotSupported)
+		_, err := store.(CompareAndSwapper).CompareAndSwap(ctx, "a", "", Entry{})
+		assert.ErrorIs(t, err, ErrNotSupported)
+
+		// batches fall back to the operations one by one
+		entries, err := store.(BatchStore).GetMulti(ctx, []string{"a"})
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+		require.NoError(t, store.(BatchStore).RemoveMulti(ctx, []string{"a"}))
+	})
+}
+
+// blockingStore blocks every operation until the context is done.
+type blockingStore struct{}
+
+func (blockingStore) Get(context.Context, string) (Entry, bool) { return Entry{}, false }
+func (blockingStore) Set(context.Context, string, Entry)        {}
+func (blockingStore) Remove(context.Context, string)            {}
+
+func (blockingStore) Load(ctx context.Context, _ string) (Entry, error) {
+	<-ctx.Done()
+	return Entry{}, ctx.Err()
+}
+
+func (blockingStore) Save(ctx context.Context, _ string, _ Entry) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingStore) Delete(ctx context.Context, _ string) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
@@ -0,0 +1,97 @@
+package gcache
+
+import (
+	"context"
+	"time"
+)
+
+// EventKind specifies the kind of the cache event.
+type EventKind int
+
+// Kinds of the cache events.
+const (
+	// EventHit is reported when the response is found in the store.
+	EventHit EventKind = iota
+	// EventMiss is reported when the response is not found in the store.
+	EventMiss
+	// EventStoreError is reported when the store operation fails.
+	EventStoreError
+	// EventEvict is reported by the store when it evicts the entry
+	// to stay within its budget.
+	EventEvict
+	// EventCompress is reported by the compressing store when it
+	// compresses the entry, see NewCompressed.
+	EventCompress
+	// EventTampered is reported by the signing store when the entry
+	// fails the verification, see NewSigned.
+	EventTampered
+	// EventStoreOp is reported by the metrics middleware on every
+	// operation of the store, see WithMetrics.
+	EventStoreOp
+	// EventBreaker is reported by the circuit breaker when its circuit
+	// changes the state, see WithCircuitBreaker.
+	EventBreaker
+	// EventWriteDropped is reported when the write-behind queue is full
+	// and the write is dropped, see WithWriteBehind.
+	EventWriteDropped
+	// EventSchemaMismatch is reported when the entry was written with
+	// the incompatible schema of the messages, see WithSchemaFingerprint.
+	EventSchemaMismatch
+)
+
+// String returns the name of the event kind.
+func (k EventKind) String() string {
+	switch k {
+	case EventHit:
+		return "hit"
+	case EventMiss:
+		return "miss"
+	case EventStoreError:
+		return "store_error"
+	case EventEvict:
+		return "evict"
+	case EventCompress:
+		return "compress"
+	case EventTampered:
+		return "tampered"
+	case EventStoreOp:
+		return "store_op"
+	case EventBreaker:
+		return "breaker"
+	case EventWriteDropped:
+		return "write_dropped"
+	case EventSchemaMismatch:
+		return "schema_mismatch"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a cache event, reported to the Observer.
+type Event struct {
+	Kind     EventKind
+	Method   string        // full method name, empty if not applicable
+	Key      string        // key of the entry, empty if not applicable
+	Op       string        // store operation, e.g. "load", "save" or "delete"
+	Err      error         // error of the store operation, if any
+	Size     int64         // size of the evicted or compressed entry in bytes
+	RawSize  int64         // size of the compressed entry before the compression
+	Duration time.Duration // duration of the store operation
+	State    BreakerState  // new state of the circuit breaker
+}
+
+// Observer receives the cache events, e.g. to export metrics.
+// It must be safe for concurrent use and must not block.
+type Observer interface {
+	Observe(ctx context.Context, ev Event)
+}
+
+// ObserverFunc is an adapter to use ordinary functions as Observer.
+type ObserverFunc func(ctx context.Context, ev Event)
+
+// Observe calls f(ctx, ev).
+func (f ObserverFunc) Observe(ctx context.Context, ev Event) { f(ctx, ev) }
+
+type nopObserver struct{}
+
+func (nopObserver) Observe(context.Context, Event) {}
@@ -3,6 +3,7 @@ package gcache
 import (
 	"log/slog"
 	"regexp"
+	"time"
 
 	"google.golang.org/grpc/encoding"
 )
@@ -17,8 +18,125 @@ func WithLogger(l *slog.Logger) Option { return func(c *Interceptor) { c.logger
 func WithCodec(codec encoding.Codec) Option { return func(c *Interceptor) { c.codec = codec } }
 
 // WithStore sets the store.
-func WithStore(store Store) Option { return func(c *Interceptor) { c.store = store } }
+func WithStore(store Store) Option { return func(c *Interceptor) { c.store = AdaptStore(store) } }
+
+// WithStoreV2 sets the store that reports its failures.
+func WithStoreV2(store StoreV2) Option { return func(c *Interceptor) { c.store = store } }
+
+// WithCloseStore makes Close close the store, if it implements io.Closer.
+// By default, the store belongs to the caller, as the same store is often
+// shared by several interceptors, e.g. the server and the client ones.
+func WithCloseStore() Option { return func(c *Interceptor) { c.closeStore = true } }
+
+// StoreErrorPolicy specifies the behavior of interceptors when
+// the store fails to load the entry.
+type StoreErrorPolicy int
+
+const (
+	// BypassOnStoreError makes interceptors to proceed with the call
+	// as if there was no cache.
+	BypassOnStoreError StoreErrorPolicy = iota
+	// FailOnStoreError makes interceptors to fail the call
+	// with codes.Unavailable.
+	FailOnStoreError
+)
+
+// WithStoreErrorPolicy sets the behavior of interceptors when the store
+// fails to load the entry. By default, the cache is bypassed.
+// Failures to save or delete the entry never fail the call.
+// ErrCircuitOpen of the store wrapped with WithCircuitBreaker always
+// bypasses the cache and isn't reported as EventStoreError.
+func WithStoreErrorPolicy(p StoreErrorPolicy) Option {
+	return func(c *Interceptor) { c.storeErrorPolicy = p }
+}
+
+// WithObserver sets the observer of the cache events, e.g. to export metrics.
+func WithObserver(o Observer) Option { return func(c *Interceptor) { c.observer = o } }
 
 // WithFilter sets the filter that is used to match the methods that
 // must be cached.
 func WithFilter(rx *regexp.Regexp) Option { return func(c *Interceptor) { c.filter = rx } }
+
+// WithTagger sets the function that produces tags for the cached responses,
+// in addition to the ones attached by the handler via Tag.
+func WithTagger(t Tagger) Option { return func(c *Interceptor) { c.tagger = t } }
+
+// WithRules sets the rules to invalidate the cached responses after
+// successful calls of the mutating methods. Rules are applied by both
+// server and client interceptors, regardless of the filter.
+func WithRules(rules ...Rule) Option {
+	return func(c *Interceptor) {
+		if c.rules == nil {
+			c.rules = map[string][]Rule{}
+		}
+		for _, rule := range rules {
+			c.rules[rule.Method] = append(c.rules[rule.Method], rule)
+		}
+	}
+}
+
+// WithBus sets the bus to exchange invalidations with the interceptors
+// of other instances. Interceptor publishes its own invalidations to the bus
+// and applies the ones received from the others to its store.
+// Interceptor must be closed to stop the subscription.
+func WithBus(bus Bus) Option { return func(c *Interceptor) { c.bus = bus } }
+
+// WithSnapshotFile sets the file to restore the store from on start,
+// if it exists, and to snapshot the store to on Close, so that the cache
+// survives graceful restarts. The store must implement Ranger.
+func WithSnapshotFile(path string) Option { return func(c *Interceptor) { c.snapshotFile = path } }
+
+// WithWriteBehind makes interceptors save the responses to the store
+// in the background, so that a slow store doesn't add latency to the
+// calls, and a canceled call doesn't abort the write. Writes are detached
+// from the cancellation of the call context, and repeated writes to the
+// same key are coalesced, the latest one wins. Up to size writes are
+// queued, see WithFullQueuePolicy for the behavior when the queue is full.
+// Interceptor must be closed to drain the queue.
+func WithWriteBehind(size int) Option {
+	return func(c *Interceptor) { c.writeBehindSize = size }
+}
+
+// WithFullQueuePolicy sets the behavior of interceptors when the
+// write-behind queue is full. By default, the write is dropped and
+// reported as EventWriteDropped. It has no effect without WithWriteBehind.
+func WithFullQueuePolicy(p FullQueuePolicy) Option {
+	return func(c *Interceptor) { c.fullQueuePolicy = p }
+}
+
+// WithNamespace prefixes the keys of the entries with the namespace and
+// a colon, e.g. "orders-v3:", so that the services, or the versions of
+// a service, sharing the store never read each other's entries. Changing
+// the namespace on a deploy that changes the responses starts the cache
+// from scratch. Tags aren't namespaced, so that invalidation by tags
+// reaches the entries of all namespaces, see WithPrefix.
+func WithNamespace(ns string) Option { return func(c *Interceptor) { c.namespace = ns } }
+
+// WithGenerations prefixes the keys of the entries with the generation,
+// kept in the store, so that Purge drops all entries at once by bumping
+// it. The counter is kept apart from the entries and bumped atomically,
+// if the store is Incrementer, e.g. both redis stores given the redis
+// client. Other stores keep it as an entry, which might expire or be
+// evicted, making the instances started after that diverge from the
+// others, and bump it atomically only if they are CompareAndSwapper.
+// The generation is loaded from the store on start, and, if refresh is
+// positive, every refresh interval, to pick up the purges of the
+// instances that aren't connected with the bus. Snapshots made before
+// the loaded generation aren't restored, see WithSnapshotFile.
+// Enabling it changes the keys, so the entries cached before become
+// unreachable.
+func WithGenerations(refresh time.Duration) Option {
+	return func(c *Interceptor) { c.generations, c.genRefresh = true, refresh }
+}
+
+// WithSchemaFingerprint seals the cached values with the fingerprint of
+// the request and response message descriptors of the method, resolved
+// through the global proto registry, so that the entries written by the
+// binary with the different schemas are treated as misses, instead of
+// silently decoding with the fields missing. Such entries are reported
+// as EventSchemaMismatch. The policy tells whether the entries written
+// before the additive changes of the schemas are still served.
+// Enabling it makes the entries cached before unreachable.
+func WithSchemaFingerprint(p SchemaPolicy) Option {
+	return func(c *Interceptor) { c.fingerprints, c.schemaPolicy = true, p }
+}
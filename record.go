@@ -0,0 +1,170 @@
+package gcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// ErrCorruptRecord is returned when a persisted entry can't be decoded.
+var ErrCorruptRecord = errors.New("gcache: corrupt record")
+
+// errRecordsEnd is returned by readRecord on the end marker
+// of the sequence of records, see appendRecordsEnd.
+var errRecordsEnd = errors.New("end of records")
+
+// maxRecordSize limits the size of the record to be decoded, so that
+// a corrupt length doesn't make the reader allocate the world.
+const maxRecordSize = 1 << 30
+
+// appendRecord appends the binary record of the entry to the buffer.
+// The record is the length of its body, the body and its CRC-32:
+//
+//	uvarint len | key | value | etag | expires at | cost | tags | crc32
+//
+// Strings and byte slices are prefixed with their uvarint lengths,
+// expiry is a varint of Unix nanoseconds (0 if the entry doesn't expire),
+// cost is a varint of nanoseconds, tags are prefixed with their count.
+func appendRecord(buf []byte, key string, e Entry) []byte {
+	var body []byte
+	body = appendBytes(body, []byte(key))
+	body = appendBytes(body, e.Value)
+	body = appendBytes(body, []byte(e.ETag))
+
+	var expiresAt int64
+	if !e.ExpiresAt.IsZero() {
+		expiresAt = e.ExpiresAt.UnixNano()
+	}
+	body = binary.AppendVarint(body, expiresAt)
+	body = binary.AppendVarint(body, int64(e.Cost))
+
+	body = binary.AppendUvarint(body, uint64(len(e.Tags)))
+	for _, tag := range e.Tags {
+		body = appendBytes(body, []byte(tag))
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(body)))
+	buf = append(buf, body...)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(body))
+}
+
+// appendRecordsEnd appends the end marker of the sequence of records,
+// which is a zero length, as no record has an empty body.
+func appendRecordsEnd(buf []byte) []byte { return binary.AppendUvarint(buf, 0) }
+
+// readRecord reads the next record from the reader.
+// It returns io.EOF if the reader is exhausted right before the record,
+// and errRecordsEnd if the end marker is read instead of the record.
+func readRecord(r *bufio.Reader) (key string, e Entry, err error) {
+	n, err := binary.ReadUvarint(r)
+	switch {
+	case errors.Is(err, io.EOF):
+		return "", Entry{}, io.EOF
+	case err != nil:
+		return "", Entry{}, fmt.Errorf("%w: read length: %w", ErrCorruptRecord, err)
+	case n == 0:
+		return "", Entry{}, errRecordsEnd
+	case n > maxRecordSize:
+		return "", Entry{}, fmt.Errorf("%w: record of %d bytes is too large", ErrCorruptRecord, n)
+	}
+
+	body := make([]byte, n+4)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return "", Entry{}, fmt.Errorf("%w: read body: %w", ErrCorruptRecord, err)
+	}
+
+	body, sum := body[:n], binary.LittleEndian.Uint32(body[n:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return "", Entry{}, fmt.Errorf("%w: checksum mismatch", ErrCorruptRecord)
+	}
+
+	return decodeRecord(body)
+}
+
+// decodeRecord decodes the body of the record.
+func decodeRecord(body []byte) (key string, e Entry, err error) {
+	d := recordDecoder{buf: body}
+
+	key = string(d.bytes())
+	e.Value = d.bytes()
+	e.ETag = string(d.bytes())
+	if expiresAt := d.varint(); expiresAt != 0 {
+		e.ExpiresAt = time.Unix(0, expiresAt)
+	}
+	e.Cost = time.Duration(d.varint())
+
+	if n := d.uvarint(); n > 0 && n <= uint64(len(d.buf)) {
+		e.Tags = make([]string, n)
+		for i := range e.Tags {
+			e.Tags[i] = string(d.bytes())
+		}
+	} else if n > 0 {
+		d.err = errors.New("too many tags")
+	}
+
+	if d.err == nil && len(d.buf) > 0 {
+		d.err = fmt.Errorf("%d trailing bytes", len(d.buf))
+	}
+
+	if d.err != nil {
+		return "", Entry{}, fmt.Errorf("%w: %w", ErrCorruptRecord, d.err)
+	}
+
+	return key, e, nil
+}
+
+func appendBytes(buf, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// recordDecoder reads the fields of the record body,
+// remembering the first error.
+type recordDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *recordDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("malformed uvarint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *recordDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("malformed varint")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *recordDecoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errors.New("field exceeds the record")
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
@@ -3,47 +3,90 @@ package gcache
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
+	"strings"
 	"time"
 
 	rediscache "github.com/go-redis/cache/v9"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/redis/go-redis/v9"
 )
 
-// RedisOption is a configuration option.
-type RedisOption func(*redisStore)
+// redisTagPrefix is the prefix of the redis sets that hold the keys of the tagged entries.
+const redisTagPrefix = "gcache:tag:"
+
+// RedisOption is a configuration option of the redis stores.
+type RedisOption func(*redisOptions)
 
 // WithRedisLogger sets the logger.
 func WithRedisLogger(l *slog.Logger) RedisOption {
-	return func(r *redisStore) { r.logger = l }
+	return func(r *redisOptions) { r.logger = l }
 }
 
 // WithRedisTTL sets the TTL.
 func WithRedisTTL(ttl time.Duration) RedisOption {
-	return func(r *redisStore) { r.ttl = ttl }
+	return func(r *redisOptions) { r.ttl = ttl }
 }
 
 // WithRedisSkipLocalCache sets the skipLocalCache.
+// It is used only by the store made with NewRedis.
 func WithRedisSkipLocalCache(skipLocalCache bool) RedisOption {
-	return func(r *redisStore) { r.skipLocalCache = skipLocalCache }
+	return func(r *redisOptions) { r.skipLocalCache = skipLocalCache }
 }
 
-type redisStore struct {
-	backend        *rediscache.Cache
+// WithRedisClient sets the redis client that is used for the operations
+// not supported by go-redis/cache, such as tag-based invalidation.
+// It must point to the same redis, the cache backend uses.
+// It is used only by the store made with NewRedis.
+func WithRedisClient(client redis.UniversalClient) RedisOption {
+	return func(r *redisOptions) { r.client = client }
+}
+
+// WithRedisLocalIndexSize sets the number of keys tracked in the local cache
+// of go-redis/cache, in order to purge them on invalidations received from
+// other instances. It should be not less than the size of the local cache.
+// It is used only by the store made with NewRedis.
+func WithRedisLocalIndexSize(size int) RedisOption {
+	return func(r *redisOptions) { r.localIndexSize = size }
+}
+
+// redisOptions are the options shared by the redis stores.
+type redisOptions struct {
+	client         redis.UniversalClient
 	logger         *slog.Logger
 	ttl            time.Duration
 	skipLocalCache bool
+	localIndexSize int
+	hashTags       bool
 }
 
-// NewRedis returns a new redisStore cache store.
-func NewRedis(backend *rediscache.Cache, opts ...RedisOption) Store {
-	store := &redisStore{
-		backend: backend,
-		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+func newRedisOptions(opts []RedisOption) redisOptions {
+	o := redisOptions{
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		localIndexSize: 10_000,
 	}
 
 	for _, opt := range opts {
-		opt(store)
+		opt(&o)
+	}
+
+	return o
+}
+
+type redisStore struct {
+	redisOptions
+	backend *rediscache.Cache
+	local   *localIndex // nil if local cache is skipped
+}
+
+// NewRedis returns a new redisStore cache store.
+func NewRedis(backend *rediscache.Cache, opts ...RedisOption) Store {
+	store := &redisStore{backend: backend, redisOptions: newRedisOptions(opts)}
+
+	if !store.skipLocalCache {
+		store.local = newLocalIndex(store.localIndexSize)
 	}
 
 	return store
@@ -51,33 +94,459 @@ func NewRedis(backend *rediscache.Cache, opts ...RedisOption) Store {
 
 // Get returns the value for the given key.
 func (r *redisStore) Get(ctx context.Context, key string) (e Entry, ok bool) {
-	switch err := r.backend.Get(ctx, key, &e); {
+	return legacyStore{v2: r, logger: r.logger}.Get(ctx, key)
+}
+
+// Set sets the value for the given key.
+func (r *redisStore) Set(ctx context.Context, key string, e Entry) {
+	legacyStore{v2: r, logger: r.logger}.Set(ctx, key, e)
+}
+
+// Remove removes the value for the given key.
+func (r *redisStore) Remove(ctx context.Context, key string) {
+	legacyStore{v2: r, logger: r.logger}.Remove(ctx, key)
+}
+
+// Load returns the value for the given key, or ErrNotFound.
+func (r *redisStore) Load(ctx context.Context, key string) (e Entry, err error) {
+	switch err = r.backend.Get(ctx, key, &e); {
 	case errors.Is(err, rediscache.ErrCacheMiss):
-		return Entry{}, false
+		return Entry{}, ErrNotFound
 	case err != nil:
-		r.logger.WarnContext(ctx, "gcache: failed to get from redisStore cache", slog.Any(ErrKey, err))
+		return Entry{}, fmt.Errorf("get %s: %w", key, err)
+	}
+
+	// entry might be served from the local cache, which has its own TTL
+	if e.expired(time.Now()) {
+		r.backend.DeleteFromLocalCache(key)
+		return Entry{}, ErrNotFound
 	}
-	return e, true
+
+	if r.local != nil {
+		r.local.add(key, e.Tags)
+	}
+
+	return e, nil
 }
 
-// Set sets the value for the given key.
-func (r *redisStore) Set(ctx context.Context, key string, e Entry) {
+// Save sets the value for the given key.
+func (r *redisStore) Save(ctx context.Context, key string, e Entry) error {
+	ttl, expired := r.itemTTL(e)
+	if expired {
+		return r.Delete(ctx, key)
+	}
+
 	item := &rediscache.Item{
 		Ctx:            ctx,
 		Key:            key,
 		Value:          e,
-		TTL:            r.ttl,
+		TTL:            ttl,
 		SkipLocalCache: r.skipLocalCache,
 	}
 
 	if err := r.backend.Set(item); err != nil {
-		r.logger.WarnContext(ctx, "gcache: failed to set to redisStore cache", slog.Any(ErrKey, err))
+		return fmt.Errorf("set %s: %w", key, err)
+	}
+
+	if r.local != nil {
+		r.local.add(key, e.Tags)
 	}
+
+	if len(e.Tags) == 0 {
+		return nil
+	}
+
+	if r.client == nil {
+		r.logger.WarnContext(ctx, "gcache: redis client is not set, tags are ignored")
+		return nil
+	}
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		r.indexTags(ctx, pipe, key, e.Tags)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("index tags of %s: %w", key, err)
+	}
+
+	return nil
 }
 
-// Remove removes the value for the given key.
-func (r *redisStore) Remove(ctx context.Context, key string) {
+// indexTags adds the key to the sets of its tags.
+func (r *redisStore) indexTags(ctx context.Context, pipe redis.Pipeliner, key string, tags []string) {
+	// tag set lives as long as the longest-living entry that might be added to it
+	ttl := r.effectiveTTL()
+	for _, tag := range tags {
+		pipe.SAdd(ctx, redisTagPrefix+tag, key)
+		if ttl > 0 {
+			pipe.Expire(ctx, redisTagPrefix+tag, ttl)
+		}
+	}
+}
+
+// itemTTL returns the TTL of the go-redis/cache item for the entry,
+// or false, if the entry is already expired.
+func (r *redisStore) itemTTL(e Entry) (ttl time.Duration, expired bool) {
+	if e.ExpiresAt.IsZero() {
+		return r.ttl, false
+	}
+
+	until := time.Until(e.ExpiresAt)
+	if until <= 0 {
+		return 0, true
+	}
+
+	if r.ttl <= 0 || until < r.ttl {
+		// go-redis/cache doesn't support sub-second TTLs
+		return max(until, time.Second), false
+	}
+
+	return r.ttl, false
+}
+
+// GetMulti returns the entries found for the keys. If the redis client
+// is set, entries are read from redis in a single round trip, bypassing
+// the local cache, otherwise they are read one by one.
+func (r *redisStore) GetMulti(ctx context.Context, keys []string) (map[string]Entry, error) {
+	if r.client == nil {
+		return loadEach(ctx, r, keys)
+	}
+
+	cmds, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("get entries: %w", err)
+	}
+
+	now := time.Now()
+	entries := make(map[string]Entry, len(keys))
+	for i, cmd := range cmds {
+		bts, err := cmd.(*redis.StringCmd).Bytes()
+		switch {
+		case errors.Is(err, redis.Nil):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("get %s: %w", keys[i], err)
+		}
+
+		var e Entry
+		if err = r.backend.Unmarshal(bts, &e); err != nil {
+			return nil, fmt.Errorf("unmarshal %s: %w", keys[i], err)
+		}
+
+		if !e.expired(now) {
+			entries[keys[i]] = e
+		}
+	}
+
+	return entries, nil
+}
+
+// SetMulti puts the entries by their keys. If the redis client is set,
+// entries are written to redis in a single round trip, and dropped
+// from the local cache, otherwise they are written one by one.
+func (r *redisStore) SetMulti(ctx context.Context, entries map[string]Entry) error {
+	if r.client == nil {
+		return saveEach(ctx, r, entries)
+	}
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, e := range entries {
+			r.dropLocal(key)
+
+			ttl, expired := r.itemTTL(e)
+			if expired {
+				pipe.Del(ctx, key)
+				continue
+			}
+
+			bts, err := r.backend.Marshal(e)
+			if err != nil {
+				return fmt.Errorf("marshal %s: %w", key, err)
+			}
+
+			pipe.Set(ctx, key, bts, redisItemTTL(ttl))
+			r.indexTags(ctx, pipe, key, e.Tags)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("set entries: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveMulti removes the entries for the keys. If the redis client
+// is set, entries are removed in a single round trip, otherwise
+// they are removed one by one.
+func (r *redisStore) RemoveMulti(ctx context.Context, keys []string) error {
+	if r.client == nil {
+		return deleteEach(ctx, r, keys)
+	}
+
+	// keys are deleted one by one, as they may reside in different cluster slots
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			r.dropLocal(key)
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete entries: %w", err)
+	}
+
+	return nil
+}
+
+// dropLocal removes the key from the local cache.
+func (r *redisStore) dropLocal(key string) {
+	if r.local != nil {
+		r.local.remove(key)
+		r.backend.DeleteFromLocalCache(key)
+	}
+}
+
+// Delete removes the value for the given key.
+func (r *redisStore) Delete(ctx context.Context, key string) error {
+	if r.local != nil {
+		r.local.remove(key)
+	}
+
 	if err := r.backend.Delete(ctx, key); err != nil {
-		r.logger.WarnContext(ctx, "gcache: failed to remove from redisStore cache", slog.Any(ErrKey, err))
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// errNoRedisClient is returned on operations that require the redis client.
+var errNoRedisClient = fmt.Errorf("%w: redis client is not set", ErrNotSupported)
+
+// InvalidateTags removes all entries that carry any of the given tags.
+func (r *redisStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	if r.local != nil {
+		for _, key := range r.local.take(tags...) {
+			r.backend.DeleteFromLocalCache(key)
+		}
+	}
+
+	if r.client == nil {
+		return errNoRedisClient
+	}
+
+	for _, tag := range tags {
+		keys, err := r.client.SMembers(ctx, redisTagPrefix+tag).Result()
+		if err != nil {
+			return fmt.Errorf("get keys tagged with %s: %w", tag, err)
+		}
+
+		// keys are deleted one by one, as they may reside in different cluster slots
+		_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Del(ctx, key)
+				r.backend.DeleteFromLocalCache(key)
+			}
+			pipe.Del(ctx, redisTagPrefix+tag)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("delete keys tagged with %s: %w", tag, err)
+		}
+	}
+
+	return nil
+}
+
+// InvalidateLocal removes the entries, described by the invalidation,
+// from the local cache only, leaving redis intact.
+func (r *redisStore) InvalidateLocal(_ context.Context, inv Invalidation) error {
+	if r.local == nil {
+		return nil
+	}
+
+	for _, key := range inv.Keys {
+		r.dropLocal(key)
+	}
+
+	for _, prefix := range inv.Prefixes {
+		for _, key := range r.local.withPrefix(prefix) {
+			r.dropLocal(key)
+		}
+	}
+
+	for _, key := range r.local.take(inv.Tags...) {
+		r.backend.DeleteFromLocalCache(key)
+	}
+
+	return nil
+}
+
+// Increment atomically adds delta to the counter in redis, which
+// is kept without TTL. It requires the redis client to be set.
+func (r *redisStore) Increment(ctx context.Context, key string, delta uint64) (uint64, error) {
+	if r.client == nil {
+		return 0, errNoRedisClient
+	}
+	return incrementRedis(ctx, r.client, key, delta)
+}
+
+// incrementRedis atomically adds delta to the counter, kept without TTL.
+func incrementRedis(ctx context.Context, client redis.UniversalClient, key string, delta uint64) (uint64, error) {
+	n, err := client.IncrBy(ctx, key, int64(delta)).Uint64() //nolint:gosec // counters don't get near the limit
+	if err != nil {
+		return 0, fmt.Errorf("increment %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// CheckHealth pings the redis client, set with WithRedisClient.
+func (r *redisStore) CheckHealth(ctx context.Context) error {
+	if r.client == nil {
+		return errNoRedisClient
+	}
+	return pingRedis(ctx, r.client)
+}
+
+// pingRedis checks that the redis responds.
+func pingRedis(ctx context.Context, client redis.UniversalClient) error {
+	if err := client.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("ping redis: %w", err)
+	}
+	return nil
+}
+
+// effectiveTTL returns the TTL that go-redis/cache applies to the items.
+func (r *redisStore) effectiveTTL() time.Duration { return redisItemTTL(r.ttl) }
+
+// redisItemTTL returns the TTL that go-redis/cache applies to the item with the given TTL.
+func redisItemTTL(ttl time.Duration) time.Duration {
+	switch {
+	case ttl < 0:
+		return 0
+	case ttl < time.Second:
+		return time.Hour
+	default:
+		return ttl
+	}
+}
+
+// RemovePrefix removes all entries whose keys start with the given prefix.
+func (r *redisStore) RemovePrefix(ctx context.Context, prefix string) error {
+	if r.local != nil {
+		for _, key := range r.local.withPrefix(prefix) {
+			r.local.remove(key)
+			r.backend.DeleteFromLocalCache(key)
+		}
+	}
+
+	if r.client == nil {
+		return errNoRedisClient
+	}
+
+	err := scanRedis(ctx, r.client, "", redisGlobEscaper.Replace(prefix)+"*", func(keys []string) error {
+		_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Del(ctx, key)
+				r.backend.DeleteFromLocalCache(key)
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("remove keys with prefix %s: %w", prefix, err)
+	}
+
+	return nil
+}
+
+// redisGlobEscaper escapes the special characters of redis glob-style patterns.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// scanRedis iterates over the keys matching the pattern in batches,
+// on all master nodes in case of redis cluster. If slotKey is set,
+// the matching keys reside in its slot, so that only the master
+// that owns the slot is scanned.
+func scanRedis(ctx context.Context, client redis.UniversalClient, slotKey, match string, fn func(keys []string) error) error {
+	scanNode := func(ctx context.Context, client redis.Cmdable) error {
+		var cursor uint64
+		for {
+			keys, next, err := client.Scan(ctx, cursor, match, 100).Result()
+			if err != nil {
+				return fmt.Errorf("scan: %w", err)
+			}
+
+			if len(keys) > 0 {
+				if err = fn(keys); err != nil {
+					return err
+				}
+			}
+
+			if cursor = next; cursor == 0 {
+				return nil
+			}
+		}
+	}
+
+	cluster, ok := client.(*redis.ClusterClient)
+	switch {
+	case ok && slotKey != "":
+		master, err := cluster.MasterForKey(ctx, slotKey)
+		if err != nil {
+			return fmt.Errorf("get master of %s: %w", slotKey, err)
+		}
+		return scanNode(ctx, master)
+	case ok:
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scanNode(ctx, client)
+		})
+	}
+
+	return scanNode(ctx, client)
+}
+
+// localIndex tracks the keys that may reside in the local cache
+// of go-redis/cache, as the latter doesn't expose its keys.
+// It allows to purge the local cache on invalidations that were
+// received from other instances and already applied to redis.
+type localIndex struct {
+	keys *lru.Cache[string, struct{}]
+	tags tagIndex
+}
+
+func newLocalIndex(size int) *localIndex {
+	li := &localIndex{}
+	li.keys, _ = lru.NewWithEvict[string, struct{}](size, func(key string, _ struct{}) { li.tags.remove(key) })
+	return li
+}
+
+func (li *localIndex) add(key string, tags []string) {
+	li.keys.Add(key, struct{}{})
+	li.tags.add(key, tags)
+}
+
+func (li *localIndex) remove(key string) { li.keys.Remove(key) }
+
+func (li *localIndex) take(tags ...string) []string {
+	keys := li.tags.take(tags...)
+	for _, key := range keys {
+		li.keys.Remove(key)
+	}
+	return keys
+}
+
+func (li *localIndex) withPrefix(prefix string) []string {
+	var keys []string
+	for _, key := range li.keys.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
 	}
+	return keys
 }
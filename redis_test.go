@@ -0,0 +1,240 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	rediscache "github.com/go-redis/cache/v9"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStore_InvalidateTags(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	store := NewRedis(
+		rediscache.New(&rediscache.Options{Redis: client}),
+		WithRedisClient(client),
+		WithRedisTTL(time.Minute),
+		WithRedisSkipLocalCache(true),
+	)
+
+	ctx := context.Background()
+	store.Set(ctx, "get-order-42", Entry{Value: []byte("order"), Tags: []string{"order:42", "customer:7"}})
+	store.Set(ctx, "list-orders", Entry{Value: []byte("orders"), Tags: []string{"order:42", "order:43"}})
+	store.Set(ctx, "get-order-43", Entry{Value: []byte("order"), Tags: []string{"order:43"}})
+
+	members, err := mr.SMembers(redisTagPrefix + "order:42")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"get-order-42", "list-orders"}, members)
+	assert.Equal(t, time.Minute, mr.TTL(redisTagPrefix+"order:42"))
+
+	require.NoError(t, store.(TagInvalidator).InvalidateTags(ctx, "order:42"))
+
+	_, ok := store.Get(ctx, "get-order-42")
+	assert.False(t, ok)
+	_, ok = store.Get(ctx, "list-orders")
+	assert.False(t, ok)
+	_, ok = store.Get(ctx, "get-order-43")
+	assert.True(t, ok)
+	assert.False(t, mr.Exists(redisTagPrefix+"order:42"))
+}
+
+func TestRedisStore_RemovePrefix(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	store := NewRedis(rediscache.New(&rediscache.Options{Redis: client}), WithRedisClient(client))
+
+	ctx := context.Background()
+	store.Set(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("1")})
+	store.Set(ctx, "/svc.Orders/Get{02}", Entry{Value: []byte("2")})
+	store.Set(ctx, "/svc.Orders/GetAll{01}", Entry{Value: []byte("all")})
+	store.Set(ctx, "/svc.Orders/Get*{01}", Entry{Value: []byte("glob")})
+
+	require.NoError(t, store.(PrefixRemover).RemovePrefix(ctx, "/svc.Orders/Get{"))
+
+	_, ok := store.Get(ctx, "/svc.Orders/Get{01}")
+	assert.False(t, ok)
+	_, ok = store.Get(ctx, "/svc.Orders/Get{02}")
+	assert.False(t, ok)
+	_, ok = store.Get(ctx, "/svc.Orders/GetAll{01}")
+	assert.True(t, ok)
+	_, ok = store.Get(ctx, "/svc.Orders/Get*{01}")
+	assert.True(t, ok)
+
+	require.NoError(t, store.(PrefixRemover).RemovePrefix(ctx, "/svc.Orders/Get*{"))
+
+	_, ok = store.Get(ctx, "/svc.Orders/Get*{01}")
+	assert.False(t, ok)
+	_, ok = store.Get(ctx, "/svc.Orders/GetAll{01}")
+	assert.True(t, ok)
+}
+
+func TestRedisStore_LocalIndex(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	store := NewRedis(rediscache.New(&rediscache.Options{
+		Redis:      client,
+		LocalCache: rediscache.NewTinyLFU(100, time.Minute),
+	}), WithRedisClient(client))
+
+	ctx := context.Background()
+	store.Set(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("1"), Tags: []string{"order:1"}})
+	store.Set(ctx, "/svc.Orders/List{01}", Entry{Value: []byte("all")})
+
+	// another instance has already invalidated the entries in redis
+	mr.FlushAll()
+
+	_, ok := store.Get(ctx, "/svc.Orders/Get{01}")
+	require.True(t, ok, "must be served from the local cache")
+
+	require.NoError(t, store.(TagInvalidator).InvalidateTags(ctx, "order:1"))
+	_, ok = store.Get(ctx, "/svc.Orders/Get{01}")
+	assert.False(t, ok)
+
+	_, ok = store.Get(ctx, "/svc.Orders/List{01}")
+	require.True(t, ok, "must be served from the local cache")
+
+	require.NoError(t, store.(PrefixRemover).RemovePrefix(ctx, "/svc.Orders/List{"))
+	_, ok = store.Get(ctx, "/svc.Orders/List{01}")
+	assert.False(t, ok)
+}
+
+func TestRedisStore_InvalidateLocal(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	store := NewRedis(rediscache.New(&rediscache.Options{
+		Redis:      client,
+		LocalCache: rediscache.NewTinyLFU(100, time.Minute),
+	}), WithRedisClient(client))
+
+	ctx := context.Background()
+	store.Set(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("1"), Tags: []string{"order:1"}})
+	store.Set(ctx, "/svc.Orders/Get{02}", Entry{Value: []byte("2")})
+	store.Set(ctx, "/svc.Orders/List{01}", Entry{Value: []byte("all")})
+	store.Set(ctx, "/svc.Users/Get{01}", Entry{Value: []byte("user")})
+
+	err := store.(LocalInvalidator).InvalidateLocal(ctx, Invalidation{
+		Keys:     []string{"/svc.Orders/Get{02}"},
+		Prefixes: []string{"/svc.Orders/List{"},
+		Tags:     []string{"order:1"},
+	})
+	require.NoError(t, err)
+
+	// redis is left intact
+	assert.True(t, mr.Exists("/svc.Orders/Get{01}"))
+	assert.True(t, mr.Exists("/svc.Orders/Get{02}"))
+	assert.True(t, mr.Exists("/svc.Orders/List{01}"))
+	assert.True(t, mr.Exists(redisTagPrefix+"order:1"))
+
+	// another instance has invalidated the entries in redis
+	mr.FlushAll()
+
+	for _, key := range []string{"/svc.Orders/Get{01}", "/svc.Orders/Get{02}", "/svc.Orders/List{01}"} {
+		_, ok := store.Get(ctx, key)
+		assert.False(t, ok, "%s must be purged from the local cache", key)
+	}
+
+	_, ok := store.Get(ctx, "/svc.Users/Get{01}")
+	assert.True(t, ok, "must be served from the local cache")
+}
+
+func TestRedisStore_Failure(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+
+	store := NewRedis(rediscache.New(&rediscache.Options{Redis: client}), WithRedisSkipLocalCache(true))
+
+	ctx := context.Background()
+	store.Set(ctx, "key", Entry{Value: []byte("value"), ETag: "etag"})
+	mr.Close()
+
+	_, err := store.(StoreV2).Load(ctx, "key")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotFound)
+
+	_, ok := store.Get(ctx, "key")
+	assert.False(t, ok, "failure must not be reported as a hit")
+}
+
+func TestRedisStore_Batch(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	backend := rediscache.New(&rediscache.Options{Redis: client, LocalCache: rediscache.NewTinyLFU(100, time.Minute)})
+	ctx := context.Background()
+
+	for name, opts := range map[string][]RedisOption{
+		"pipelined":  {WithRedisClient(client), WithRedisTTL(time.Minute)},
+		"one by one": {WithRedisTTL(time.Minute)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mr.FlushAll()
+			store := NewRedis(backend, opts...).(BatchStore)
+
+			// stale value in the local cache must not survive the batch
+			store.(Store).Set(ctx, "a", Entry{Value: []byte("stale")})
+
+			require.NoError(t, store.SetMulti(ctx, map[string]Entry{
+				"a":       {Value: []byte("a"), Tags: []string{"tag"}},
+				"b":       {Value: []byte("b"), ETag: "v1", ExpiresAt: time.Now().Add(time.Hour)},
+				"expired": {Value: []byte("c"), ExpiresAt: time.Now().Add(-time.Second)},
+			}))
+
+			if name == "pipelined" {
+				members, err := mr.SMembers(redisTagPrefix + "tag")
+				require.NoError(t, err)
+				assert.Equal(t, []string{"a"}, members, "tags must be indexed by the pipelined batch")
+			}
+
+			entries, err := store.GetMulti(ctx, []string{"a", "b", "expired", "missing"})
+			require.NoError(t, err)
+			require.Len(t, entries, 2)
+			assert.Equal(t, []byte("a"), entries["a"].Value)
+			assert.Equal(t, "v1", entries["b"].ETag)
+			assert.Equal(t, time.Minute, mr.TTL("b"), "entry TTL is bounded by the store TTL")
+
+			e, ok := store.(Store).Get(ctx, "a")
+			require.True(t, ok)
+			assert.Equal(t, []byte("a"), e.Value)
+
+			require.NoError(t, store.RemoveMulti(ctx, []string{"a", "missing"}))
+			entries, err = store.GetMulti(ctx, []string{"a", "b"})
+			require.NoError(t, err)
+			assert.Len(t, entries, 1)
+			_, ok = store.(Store).Get(ctx, "a")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestRedisStore_CheckHealth(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { _ = client.Close() })
+
+	backend := rediscache.New(&rediscache.Options{Redis: client})
+
+	store := NewRedis(backend, WithRedisClient(client)).(HealthChecker)
+	require.NoError(t, store.CheckHealth(ctx))
+
+	assert.ErrorIs(t, NewRedis(backend).(HealthChecker).CheckHealth(ctx), ErrNotSupported,
+		"can't check without the client")
+
+	mr.Close()
+	assert.Error(t, store.CheckHealth(ctx))
+}
@@ -0,0 +1,457 @@
+package gcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WithRedisHashTags makes the store made with NewRedisHashStore to put
+// the keys of the same method into the same redis cluster slot,
+// by prefixing them with the method name in braces.
+// It makes removal by the method prefix to scan only the node that owns
+// the slot, at the cost of a less even distribution of the keys.
+func WithRedisHashTags(enabled bool) RedisOption {
+	return func(r *redisOptions) { r.hashTags = enabled }
+}
+
+// fields of the redis hash that holds the entry.
+const (
+	redisFieldValue     = "value"
+	redisFieldETag      = "etag"
+	redisFieldStoredAt  = "stored_at"  // unix milliseconds
+	redisFieldExpiresAt = "expires_at" // unix milliseconds, absent if the entry doesn't expire
+	redisFieldCost      = "cost"       // nanoseconds
+	redisFieldTags      = "tags"       // JSON array
+)
+
+// redisSaveScript replaces the hash of the entry, if the condition holds.
+// ARGV: mode ("any", "absent" or "etag"), expected ETag, TTL in
+// milliseconds (0 if the entry doesn't expire), field-value pairs.
+var redisSaveScript = redis.NewScript(`
+local mode = ARGV[1]
+if mode == 'absent' then
+	if redis.call('EXISTS', KEYS[1]) == 1 then return 0 end
+elseif mode == 'etag' then
+	if redis.call('HGET', KEYS[1], 'etag') ~= ARGV[2] then return 0 end
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], unpack(ARGV, 4))
+local ttl = tonumber(ARGV[3])
+if ttl > 0 then redis.call('PEXPIRE', KEYS[1], ttl) end
+return 1
+`)
+
+// redisTagScript adds the key to the tag set and extends the TTL of the set
+// to outlive the entry. ARGV: key, TTL of the entry in milliseconds
+// (0 if the entry doesn't expire).
+var redisTagScript = redis.NewScript(`
+local created = redis.call('EXISTS', KEYS[1]) == 0
+redis.call('SADD', KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+local current = redis.call('PTTL', KEYS[1])
+if ttl == 0 then
+	redis.call('PERSIST', KEYS[1])
+elseif created or (current >= 0 and current < ttl) then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+end
+return 1
+`)
+
+// redisPopScript removes the set and returns its members.
+var redisPopScript = redis.NewScript(`
+local members = redis.call('SMEMBERS', KEYS[1])
+redis.call('DEL', KEYS[1])
+return members
+`)
+
+type redisHashStore struct {
+	redisOptions
+	now func() time.Time
+}
+
+// NewRedisHashStore makes a store on top of the redis client, that keeps
+// every entry in a redis hash with its value, ETag, the moment it was stored
+// and tags. Entries are saved and indexed by their tags with Lua scripts,
+// so that concurrent writers never observe partially written entries.
+// The store supports invalidation by tags, removal by prefix and
+// CompareAndSwap. WithRedisTTL bounds the TTL of the entries.
+func NewRedisHashStore(client redis.UniversalClient, opts ...RedisOption) Store {
+	s := &redisHashStore{redisOptions: newRedisOptions(opts), now: time.Now}
+	s.client = client
+	return s
+}
+
+// Get returns the value for the given key.
+func (r *redisHashStore) Get(ctx context.Context, key string) (Entry, bool) {
+	return legacyStore{v2: r, logger: r.logger}.Get(ctx, key)
+}
+
+// Set sets the value for the given key.
+func (r *redisHashStore) Set(ctx context.Context, key string, e Entry) {
+	legacyStore{v2: r, logger: r.logger}.Set(ctx, key, e)
+}
+
+// Remove removes the value for the given key.
+func (r *redisHashStore) Remove(ctx context.Context, key string) {
+	legacyStore{v2: r, logger: r.logger}.Remove(ctx, key)
+}
+
+// Load returns the value for the given key, or ErrNotFound.
+func (r *redisHashStore) Load(ctx context.Context, key string) (Entry, error) {
+	fields, err := r.client.HGetAll(ctx, r.redisKey(key)).Result()
+	if err != nil {
+		return Entry{}, fmt.Errorf("get %s: %w", key, err)
+	}
+
+	if len(fields) == 0 {
+		return Entry{}, ErrNotFound
+	}
+
+	e, err := decodeRedisHash(fields)
+	if err != nil {
+		return Entry{}, fmt.Errorf("decode %s: %w", key, err)
+	}
+
+	if e.expired(r.now()) {
+		return Entry{}, ErrNotFound
+	}
+
+	return e, nil
+}
+
+// Save sets the value for the given key.
+func (r *redisHashStore) Save(ctx context.Context, key string, e Entry) error {
+	_, err := r.save(ctx, key, "any", "", e)
+	return err
+}
+
+// CompareAndSwap saves the entry only if the stored one has the given ETag,
+// or, if the ETag is empty, only if there is no stored entry.
+func (r *redisHashStore) CompareAndSwap(ctx context.Context, key, etag string, e Entry) (bool, error) {
+	if etag == "" {
+		return r.save(ctx, key, "absent", "", e)
+	}
+	return r.save(ctx, key, "etag", etag, e)
+}
+
+// Delete removes the value for the given key.
+func (r *redisHashStore) Delete(ctx context.Context, key string) error {
+	if err := r.client.Del(ctx, r.redisKey(key)).Err(); err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// InvalidateTags removes all entries that carry any of the given tags.
+func (r *redisHashStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		keys, err := redisPopScript.Run(ctx, r.client, []string{redisTagPrefix + tag}).StringSlice()
+		if err != nil {
+			return fmt.Errorf("get keys tagged with %s: %w", tag, err)
+		}
+
+		if err = r.del(ctx, keys); err != nil {
+			return fmt.Errorf("delete keys tagged with %s: %w", tag, err)
+		}
+	}
+
+	return nil
+}
+
+// RemovePrefix removes all entries whose keys start with the given prefix.
+func (r *redisHashStore) RemovePrefix(ctx context.Context, prefix string) error {
+	match := redisGlobEscaper.Replace(r.redisKey(prefix)) + "*"
+
+	var slotKey string
+	switch method, _, ok := strings.Cut(prefix, "{"); {
+	case r.hashTags && ok:
+		// the keys of the method reside in the slot of its hash tag
+		slotKey = "{" + method + "}"
+	case r.hashTags:
+		// the method is unknown, and so are the hash tags of the keys
+		match = "*" + redisGlobEscaper.Replace(prefix) + "*"
+	}
+
+	err := scanRedis(ctx, r.client, slotKey, match, func(keys []string) error {
+		keys = slices.DeleteFunc(keys, func(k string) bool { return !strings.HasPrefix(r.originalKey(k), prefix) })
+		return r.del(ctx, keys)
+	})
+	if err != nil {
+		return fmt.Errorf("remove keys with prefix %s: %w", prefix, err)
+	}
+
+	return nil
+}
+
+// Increment atomically adds delta to the counter, which is kept
+// in a plain redis key without TTL.
+func (r *redisHashStore) Increment(ctx context.Context, key string, delta uint64) (uint64, error) {
+	return incrementRedis(ctx, r.client, key, delta)
+}
+
+// InvalidateLocal does nothing, as the store doesn't keep local copies
+// of the entries, the invalidations received from other instances are
+// already applied to redis by their publisher.
+func (r *redisHashStore) InvalidateLocal(context.Context, Invalidation) error { return nil }
+
+// save runs the save script with the given condition and indexes
+// the tags of the entry, if it was saved.
+func (r *redisHashStore) save(ctx context.Context, key, mode, etag string, e Entry) (bool, error) {
+	ttlMillis, args, err := r.encode(e)
+	switch {
+	case errors.Is(err, errExpired):
+		return false, r.Delete(ctx, key)
+	case err != nil:
+		return false, fmt.Errorf("encode %s: %w", key, err)
+	}
+
+	rkey := r.redisKey(key)
+	saved, err := redisSaveScript.Run(ctx, r.client, []string{rkey},
+		append([]any{mode, etag, ttlMillis}, args...)...).Bool()
+	if err != nil {
+		return false, fmt.Errorf("set %s: %w", key, err)
+	}
+
+	if !saved || len(e.Tags) == 0 {
+		return saved, nil
+	}
+
+	// tag sets may reside in different cluster slots, thus
+	// the scripts are sent as a pipeline rather than as a single one
+	_, err = r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		r.indexTags(ctx, pipe, rkey, e.Tags, ttlMillis)
+		return nil
+	})
+	if err != nil {
+		return true, fmt.Errorf("index tags of %s: %w", key, err)
+	}
+
+	return true, nil
+}
+
+// GetMulti returns the entries found for the keys in a single round trip.
+func (r *redisHashStore) GetMulti(ctx context.Context, keys []string) (map[string]Entry, error) {
+	cmds, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.HGetAll(ctx, r.redisKey(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get entries: %w", err)
+	}
+
+	now := r.now()
+	entries := make(map[string]Entry, len(keys))
+	for i, cmd := range cmds {
+		fields := cmd.(*redis.MapStringStringCmd).Val()
+		if len(fields) == 0 {
+			continue
+		}
+
+		e, err := decodeRedisHash(fields)
+		if err != nil {
+			return nil, fmt.Errorf("decode %s: %w", keys[i], err)
+		}
+
+		if !e.expired(now) {
+			entries[keys[i]] = e
+		}
+	}
+
+	return entries, nil
+}
+
+// SetMulti puts the entries by their keys in a single round trip.
+func (r *redisHashStore) SetMulti(ctx context.Context, entries map[string]Entry) error {
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for key, e := range entries {
+			rkey := r.redisKey(key)
+
+			ttlMillis, args, err := r.encode(e)
+			switch {
+			case errors.Is(err, errExpired):
+				pipe.Del(ctx, rkey)
+				continue
+			case err != nil:
+				return fmt.Errorf("encode %s: %w", key, err)
+			}
+
+			redisSaveScript.Eval(ctx, pipe, []string{rkey}, append([]any{"any", "", ttlMillis}, args...)...)
+			r.indexTags(ctx, pipe, rkey, e.Tags, ttlMillis)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("set entries: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveMulti removes the entries for the keys in a single round trip.
+func (r *redisHashStore) RemoveMulti(ctx context.Context, keys []string) error {
+	rkeys := make([]string, len(keys))
+	for i, key := range keys {
+		rkeys[i] = r.redisKey(key)
+	}
+
+	if err := r.del(ctx, rkeys); err != nil {
+		return fmt.Errorf("delete entries: %w", err)
+	}
+
+	return nil
+}
+
+// errExpired is returned by encode for the entries that are already expired.
+var errExpired = errors.New("entry is expired")
+
+// encode returns the TTL in milliseconds (0 if the entry doesn't expire)
+// and the field-value pairs of the entry.
+func (r *redisHashStore) encode(e Entry) (ttlMillis int64, args []any, err error) {
+	now := r.now()
+
+	var ttl time.Duration
+	switch {
+	case !e.ExpiresAt.IsZero():
+		if ttl = e.ExpiresAt.Sub(now); ttl <= 0 {
+			return 0, nil, errExpired
+		}
+		if r.ttl > 0 {
+			ttl = min(ttl, r.ttl)
+		}
+	case r.ttl > 0:
+		ttl = r.ttl
+	}
+
+	// redis doesn't accept sub-millisecond expirations
+	if ttl > 0 {
+		ttlMillis = max(ttl.Milliseconds(), 1)
+	}
+
+	if args, err = encodeRedisHash(e, now); err != nil {
+		return 0, nil, err
+	}
+
+	return ttlMillis, args, nil
+}
+
+// indexTags adds the redis key of the entry to the sets of its tags.
+func (r *redisHashStore) indexTags(ctx context.Context, pipe redis.Pipeliner, rkey string, tags []string, ttlMillis int64) {
+	for _, tag := range tags {
+		redisTagScript.Eval(ctx, pipe, []string{redisTagPrefix + tag}, rkey, ttlMillis)
+	}
+}
+
+// CheckHealth pings the redis.
+func (r *redisHashStore) CheckHealth(ctx context.Context) error {
+	return pingRedis(ctx, r.client)
+}
+
+// del deletes the redis keys one by one, as they may reside in different cluster slots.
+func (r *redisHashStore) del(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
+
+// redisKey returns the redis key of the entry. With hash tags, the keys
+// produced by the interceptor, "<method>{<hash>}", are prefixed with
+// "{<method>}", so that redis cluster puts them into the same slot.
+func (r *redisHashStore) redisKey(key string) string {
+	if !r.hashTags {
+		return key
+	}
+
+	method, _, ok := strings.Cut(key, "{")
+	if !ok {
+		return key
+	}
+
+	return "{" + method + "}" + key
+}
+
+// originalKey is the inverse of redisKey.
+func (r *redisHashStore) originalKey(rkey string) string {
+	if !r.hashTags || !strings.HasPrefix(rkey, "{") {
+		return rkey
+	}
+
+	if _, key, ok := strings.Cut(rkey, "}"); ok {
+		return key
+	}
+
+	return rkey
+}
+
+// encodeRedisHash returns the field-value pairs of the entry.
+func encodeRedisHash(e Entry, storedAt time.Time) ([]any, error) {
+	args := []any{
+		redisFieldValue, e.Value,
+		redisFieldETag, e.ETag,
+		redisFieldStoredAt, storedAt.UnixMilli(),
+		redisFieldCost, int64(e.Cost),
+	}
+
+	if !e.ExpiresAt.IsZero() {
+		args = append(args, redisFieldExpiresAt, e.ExpiresAt.UnixMilli())
+	}
+
+	if len(e.Tags) > 0 {
+		tags, err := json.Marshal(e.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("marshal tags: %w", err)
+		}
+		args = append(args, redisFieldTags, tags)
+	}
+
+	return args, nil
+}
+
+// decodeRedisHash makes the entry from the fields of the hash.
+func decodeRedisHash(fields map[string]string) (e Entry, err error) {
+	if v := fields[redisFieldValue]; v != "" {
+		e.Value = []byte(v)
+	}
+	e.ETag = fields[redisFieldETag]
+
+	if v, ok := fields[redisFieldExpiresAt]; ok {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Entry{}, fmt.Errorf("parse %s: %w", redisFieldExpiresAt, err)
+		}
+		e.ExpiresAt = time.UnixMilli(ms)
+	}
+
+	if v, ok := fields[redisFieldCost]; ok {
+		ns, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return Entry{}, fmt.Errorf("parse %s: %w", redisFieldCost, err)
+		}
+		e.Cost = time.Duration(ns)
+	}
+
+	if v, ok := fields[redisFieldTags]; ok {
+		if err = json.Unmarshal([]byte(v), &e.Tags); err != nil {
+			return Entry{}, fmt.Errorf("parse %s: %w", redisFieldTags, err)
+		}
+	}
+
+	return e, nil
+}
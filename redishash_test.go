@@ -0,0 +1,215 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisHashStore(t *testing.T, opts ...RedisOption) (*redisHashStore, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return NewRedisHashStore(client, opts...).(*redisHashStore), mr
+}
+
+func TestRedisHashStore_LoadSave(t *testing.T) {
+	ctx := context.Background()
+	store, mr := newTestRedisHashStore(t, WithRedisTTL(time.Hour))
+
+	expiresAt := time.UnixMilli(time.Now().Add(time.Minute).UnixMilli())
+	e := Entry{Value: []byte("order"), ETag: "v1", Tags: []string{"order:1"}, ExpiresAt: expiresAt, Cost: time.Millisecond}
+	require.NoError(t, store.Save(ctx, "/svc.Orders/Get{01}", e))
+
+	assert.Equal(t, "order", mr.HGet("/svc.Orders/Get{01}", "value"))
+	assert.Equal(t, "v1", mr.HGet("/svc.Orders/Get{01}", "etag"))
+	assert.NotEmpty(t, mr.HGet("/svc.Orders/Get{01}", "stored_at"))
+	assert.Equal(t, `["order:1"]`, mr.HGet("/svc.Orders/Get{01}", "tags"))
+	assert.InDelta(t, time.Minute, mr.TTL("/svc.Orders/Get{01}"), float64(time.Second))
+
+	got, err := store.Load(ctx, "/svc.Orders/Get{01}")
+	require.NoError(t, err)
+	assert.True(t, expiresAt.Equal(got.ExpiresAt))
+	got.ExpiresAt = expiresAt
+	assert.Equal(t, e, got)
+
+	// replaces the entry as a whole
+	require.NoError(t, store.Save(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("new")}))
+	got, err = store.Load(ctx, "/svc.Orders/Get{01}")
+	require.NoError(t, err)
+	assert.Equal(t, Entry{Value: []byte("new")}, got)
+	assert.Equal(t, time.Hour, mr.TTL("/svc.Orders/Get{01}"))
+
+	require.NoError(t, store.Delete(ctx, "/svc.Orders/Get{01}"))
+	_, err = store.Load(ctx, "/svc.Orders/Get{01}")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	t.Run("failure", func(t *testing.T) {
+		store, mr := newTestRedisHashStore(t)
+		mr.Close()
+
+		_, err := store.Load(ctx, "key")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrNotFound)
+		assert.Error(t, store.Save(ctx, "key", Entry{}))
+	})
+}
+
+func TestRedisHashStore_CompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestRedisHashStore(t)
+
+	swapped, err := store.CompareAndSwap(ctx, "key", "", Entry{Value: []byte("1"), ETag: "v1"})
+	require.NoError(t, err)
+	assert.True(t, swapped)
+
+	swapped, err = store.CompareAndSwap(ctx, "key", "", Entry{Value: []byte("2"), ETag: "v2"})
+	require.NoError(t, err)
+	assert.False(t, swapped, "entry already exists")
+
+	swapped, err = store.CompareAndSwap(ctx, "key", "v0", Entry{Value: []byte("2"), ETag: "v2"})
+	require.NoError(t, err)
+	assert.False(t, swapped, "entry was changed")
+
+	swapped, err = store.CompareAndSwap(ctx, "key", "v1", Entry{Value: []byte("2"), ETag: "v2", Tags: []string{"t"}})
+	require.NoError(t, err)
+	assert.True(t, swapped)
+
+	e, err := store.Load(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "v2", e.ETag)
+
+	require.NoError(t, store.InvalidateTags(ctx, "t"))
+	_, err = store.Load(ctx, "key")
+	assert.ErrorIs(t, err, ErrNotFound, "tags of the swapped entry must be indexed")
+}
+
+func TestRedisHashStore_InvalidateTags(t *testing.T) {
+	ctx := context.Background()
+	store, mr := newTestRedisHashStore(t)
+
+	require.NoError(t, store.Save(ctx, "long", Entry{Tags: []string{"order:1"}, ExpiresAt: time.Now().Add(time.Hour)}))
+	require.NoError(t, store.Save(ctx, "short", Entry{Tags: []string{"order:1", "order:2"}, ExpiresAt: time.Now().Add(time.Minute)}))
+	require.NoError(t, store.Save(ctx, "other", Entry{Tags: []string{"order:2"}}))
+
+	// tag set outlives the longest-living entry
+	assert.InDelta(t, time.Hour, mr.TTL(redisTagPrefix+"order:1"), float64(time.Second))
+	assert.Zero(t, mr.TTL(redisTagPrefix+"order:2"), "entry without expiry keeps the set forever")
+
+	require.NoError(t, store.InvalidateTags(ctx, "order:1"))
+
+	for key, present := range map[string]bool{"long": false, "short": false, "other": true} {
+		_, err := store.Load(ctx, key)
+		assert.Equal(t, present, err == nil, key)
+	}
+	assert.False(t, mr.Exists(redisTagPrefix+"order:1"))
+}
+
+func TestRedisHashStore_RemovePrefix(t *testing.T) {
+	ctx := context.Background()
+
+	for name, hashTags := range map[string]bool{"plain keys": false, "hash tags": true} {
+		t.Run(name, func(t *testing.T) {
+			store, mr := newTestRedisHashStore(t, WithRedisHashTags(hashTags))
+
+			for _, key := range []string{"/svc.Orders/Get{01}", "/svc.Orders/Get{02}", "/svc.Orders/GetAll{01}", "/svc.Users/Get{01}", "plain"} {
+				require.NoError(t, store.Save(ctx, key, Entry{Value: []byte(key)}))
+			}
+
+			if hashTags {
+				assert.True(t, mr.Exists("{/svc.Orders/Get}/svc.Orders/Get{01}"))
+				assert.True(t, mr.Exists("plain"))
+			}
+
+			require.NoError(t, store.RemovePrefix(ctx, methodPrefix("/svc.Orders/Get")))
+			require.NoError(t, store.RemovePrefix(ctx, "/svc.Users/"))
+
+			for key, present := range map[string]bool{
+				"/svc.Orders/Get{01}":    false,
+				"/svc.Orders/Get{02}":    false,
+				"/svc.Orders/GetAll{01}": true,
+				"/svc.Users/Get{01}":     false,
+				"plain":                  true,
+			} {
+				e, err := store.Load(ctx, key)
+				if !present {
+					assert.ErrorIs(t, err, ErrNotFound, key)
+					continue
+				}
+				require.NoError(t, err, key)
+				assert.Equal(t, key, string(e.Value))
+			}
+		})
+	}
+}
+
+func TestRedisHashStore_RemovePrefixInCluster(t *testing.T) {
+	nodes := []*miniredis.Miniredis{miniredis.RunT(t), miniredis.RunT(t)}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		ClusterSlots: func(context.Context) ([]redis.ClusterSlot, error) {
+			return []redis.ClusterSlot{
+				{Start: 0, End: 8191, Nodes: []redis.ClusterNode{{Addr: nodes[0].Addr()}}},
+				{Start: 8192, End: 16383, Nodes: []redis.ClusterNode{{Addr: nodes[1].Addr()}}},
+			}, nil
+		},
+	})
+	t.Cleanup(func() { _ = client.Close() })
+
+	ctx := context.Background()
+	store := NewRedisHashStore(client, WithRedisHashTags(true)).(*redisHashStore)
+
+	require.NoError(t, store.Save(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("1")}))
+	require.NoError(t, store.Save(ctx, "/svc.Orders/Get{02}", Entry{Value: []byte("2")}))
+
+	owner, other := nodes[0], nodes[1]
+	if !owner.Exists(store.redisKey("/svc.Orders/Get{01}")) {
+		owner, other = other, owner
+	}
+	commands := other.CommandCount()
+
+	require.NoError(t, store.RemovePrefix(ctx, methodPrefix("/svc.Orders/Get")))
+	assert.False(t, owner.Exists(store.redisKey("/svc.Orders/Get{01}")))
+	assert.False(t, owner.Exists(store.redisKey("/svc.Orders/Get{02}")))
+	assert.Equal(t, commands, other.CommandCount(), "only the owner of the slot must be scanned")
+}
+
+func TestRedisHashStore_Batch(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestRedisHashStore(t, WithRedisHashTags(true))
+
+	require.NoError(t, store.SetMulti(ctx, map[string]Entry{
+		"/svc.Orders/Get{01}": {Value: []byte("1"), Tags: []string{"order:1"}},
+		"/svc.Orders/Get{02}": {Value: []byte("2"), ETag: "v2"},
+		"expired":             {Value: []byte("3"), ExpiresAt: time.Now().Add(-time.Second)},
+	}))
+
+	entries, err := store.GetMulti(ctx, []string{"/svc.Orders/Get{01}", "/svc.Orders/Get{02}", "expired", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Entry{
+		"/svc.Orders/Get{01}": {Value: []byte("1"), Tags: []string{"order:1"}},
+		"/svc.Orders/Get{02}": {Value: []byte("2"), ETag: "v2"},
+	}, entries)
+
+	require.NoError(t, store.InvalidateTags(ctx, "order:1"))
+	require.NoError(t, store.RemoveMulti(ctx, []string{"/svc.Orders/Get{02}"}))
+
+	entries, err = store.GetMulti(ctx, []string{"/svc.Orders/Get{01}", "/svc.Orders/Get{02}"})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRedisHashStore_CheckHealth(t *testing.T) {
+	ctx := context.Background()
+	store, mr := newTestRedisHashStore(t)
+
+	require.NoError(t, store.CheckHealth(ctx))
+
+	mr.Close()
+	assert.Error(t, store.CheckHealth(ctx))
+}
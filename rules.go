@@ -0,0 +1,255 @@
+package gcache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// Rule declares the cached responses that become stale after
+// a successful call of the mutating method.
+type Rule struct {
+	// Method is the full name of the mutating method,
+	// e.g. "/order.OrderService/UpdateOrder".
+	Method string
+	// Targets lists the read methods affected by the mutation.
+	Targets []Target
+	// Tags lists the tags to invalidate. Fields of the mutation request
+	// are referenced in braces, e.g. "order:{id}" or "customer:{order.customer_id}".
+	Tags []string
+}
+
+// Target describes the cached responses of the read method,
+// affected by the mutation.
+type Target struct {
+	// Method is the full name of the read method,
+	// e.g. "/order.OrderService/GetOrder".
+	Method string
+	// Fields maps the fields of the read request to the fields of
+	// the mutation request, e.g. {"id": "order.id"}. The read request
+	// is built from these fields only and its cached response is removed.
+	// If empty, all cached responses of the method are removed.
+	Fields map[string]string
+}
+
+// applyRules invalidates the cached responses, affected by the
+// successful call of the mutating method. Each rule is applied
+// as a single invalidation, so that the keys of its targets are
+// removed in a batch, if the store supports it.
+func (c *Interceptor) applyRules(ctx context.Context, rules []Rule, req any) {
+	msg, ok := req.(proto.Message)
+	if !ok {
+		c.logger.WarnContext(ctx, "gcache: request is not a proto message, invalidation rules are skipped")
+		return
+	}
+
+	for _, rule := range rules {
+		var inv Invalidation
+
+		for _, target := range rule.Targets {
+			if len(target.Fields) == 0 {
+				inv.Prefixes = append(inv.Prefixes, methodPrefix(target.Method))
+				continue
+			}
+
+			key, err := c.targetKey(target, msg.ProtoReflect())
+			if err != nil {
+				c.logger.WarnContext(ctx, "gcache: failed to apply invalidation rule",
+					slog.String("mutation", rule.Method),
+					slog.String("target", target.Method),
+					slog.Any(ErrKey, err))
+				continue
+			}
+			inv.Keys = append(inv.Keys, key)
+		}
+
+		for _, tmpl := range rule.Tags {
+			tag, err := expandTag(tmpl, msg.ProtoReflect())
+			if err != nil {
+				c.logger.WarnContext(ctx, "gcache: failed to expand tag of invalidation rule",
+					slog.String("mutation", rule.Method),
+					slog.String("tag", tmpl),
+					slog.Any(ErrKey, err))
+				continue
+			}
+			inv.Tags = append(inv.Tags, tag)
+		}
+
+		if len(inv.Keys)+len(inv.Prefixes)+len(inv.Tags) == 0 {
+			continue
+		}
+
+		if err := c.invalidate(ctx, inv); err != nil {
+			c.logger.WarnContext(ctx, "gcache: failed to apply invalidation rule",
+				slog.String("mutation", rule.Method), slog.Any(ErrKey, err))
+		}
+	}
+}
+
+// targetKey returns the key of the cached response of the target,
+// for the read request built from the fields of the mutation request.
+func (c *Interceptor) targetKey(target Target, src protoreflect.Message) (string, error) {
+	mt, err := requestType(target.Method)
+	if err != nil {
+		return "", fmt.Errorf("resolve request type: %w", err)
+	}
+
+	dst := mt.New()
+	for dstPath, srcPath := range target.Fields {
+		v, fd, err := lookupField(src, srcPath)
+		if err != nil {
+			return "", fmt.Errorf("lookup mutation request field %q: %w", srcPath, err)
+		}
+
+		if err = setField(dst, dstPath, v, fd); err != nil {
+			return "", fmt.Errorf("set read request field %q: %w", dstPath, err)
+		}
+	}
+
+	key, err := c.key(target.Method, dst.Interface())
+	if err != nil {
+		return "", fmt.Errorf("produce key: %w", err)
+	}
+
+	return key, nil
+}
+
+// requestType resolves the request message type of the method
+// by its full name through the global proto registry.
+func requestType(fullMethod string) (protoreflect.MessageType, error) {
+	md, err := methodDescriptor(fullMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	mt, err := protoregistry.GlobalTypes.FindMessageByName(md.Input().FullName())
+	if err != nil {
+		return nil, fmt.Errorf("find message %q: %w", md.Input().FullName(), err)
+	}
+
+	return mt, nil
+}
+
+// methodDescriptor resolves the descriptor of the method
+// by its full name through the global proto registry.
+func methodDescriptor(fullMethod string) (protoreflect.MethodDescriptor, error) {
+	svc, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid method name %q", fullMethod)
+	}
+
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(svc))
+	if err != nil {
+		return nil, fmt.Errorf("find service %q: %w", svc, err)
+	}
+
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a service", svc)
+	}
+
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("method %q not found in service %q", method, svc)
+	}
+
+	return md, nil
+}
+
+// lookupField returns the value of the singular field by its dot-separated path.
+func lookupField(msg protoreflect.Message, path string) (protoreflect.Value, protoreflect.FieldDescriptor, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return protoreflect.Value{}, nil, fmt.Errorf("field %q not found in %s", name, msg.Descriptor().FullName())
+		}
+
+		if fd.IsList() || fd.IsMap() {
+			return protoreflect.Value{}, nil, fmt.Errorf("field %q is not singular", name)
+		}
+
+		if i == len(names)-1 {
+			return msg.Get(fd), fd, nil
+		}
+
+		if fd.Message() == nil {
+			return protoreflect.Value{}, nil, fmt.Errorf("field %q is not a message", name)
+		}
+
+		msg = msg.Get(fd).Message()
+	}
+
+	return protoreflect.Value{}, nil, fmt.Errorf("empty field path")
+}
+
+// setField sets the value of the singular field by its dot-separated path,
+// populating the intermediate messages.
+func setField(msg protoreflect.Message, path string, v protoreflect.Value, src protoreflect.FieldDescriptor) error {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		fd := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return fmt.Errorf("field %q not found in %s", name, msg.Descriptor().FullName())
+		}
+
+		if fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("field %q is not singular", name)
+		}
+
+		if i < len(names)-1 {
+			if fd.Message() == nil {
+				return fmt.Errorf("field %q is not a message", name)
+			}
+			msg = msg.Mutable(fd).Message()
+			continue
+		}
+
+		if fd.Kind() != src.Kind() {
+			return fmt.Errorf("field %q is of kind %s, but source field is of kind %s", name, fd.Kind(), src.Kind())
+		}
+
+		if fd.Message() != nil && fd.Message().FullName() != src.Message().FullName() {
+			return fmt.Errorf("field %q is of type %s, but source field is of type %s",
+				name, fd.Message().FullName(), src.Message().FullName())
+		}
+
+		msg.Set(fd, v)
+	}
+
+	return nil
+}
+
+var tagFieldRx = regexp.MustCompile(`\{([\w.]+)}`)
+
+// expandTag substitutes the references to the request fields in the tag template.
+func expandTag(tmpl string, msg protoreflect.Message) (string, error) {
+	var err error
+	tag := tagFieldRx.ReplaceAllStringFunc(tmpl, func(ref string) string {
+		v, fd, lerr := lookupField(msg, strings.Trim(ref, "{}"))
+		if lerr != nil {
+			err = lerr
+			return ref
+		}
+
+		switch fd.Kind() {
+		case protoreflect.MessageKind, protoreflect.GroupKind:
+			err = fmt.Errorf("field %q is a message", fd.Name())
+			return ref
+		case protoreflect.EnumKind:
+			if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+				return string(ev.Name())
+			}
+		}
+
+		return v.String()
+	})
+
+	return tag, err
+}
@@ -0,0 +1,80 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cappuccinotm/gcache/internal/tspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestInterceptor_Rules(t *testing.T) {
+	const method = "/com.github.cappuccinotm.gcache.example.TestService/Test"
+
+	icptr := NewInterceptor(WithRules(Rule{
+		Method:  method,
+		Targets: []Target{{Method: method, Fields: map[string]string{"key": "key"}}},
+		Tags:    []string{"tagged:{key}"},
+	}))
+
+	ctx := context.Background()
+	keyOf := func(k string) string {
+		key, err := icptr.key(method, &tspb.TestRequest{Key: k})
+		require.NoError(t, err)
+		return key
+	}
+
+	save(t, icptr, keyOf("42"), Entry{Value: []byte("by-key")})
+	save(t, icptr, keyOf("43"), Entry{Value: []byte("must-stay")})
+	save(t, icptr, "tagged", Entry{Value: []byte("by-tag"), Tags: []string{"tagged:42"}})
+
+	calls := 0
+	addr := tspb.Run(t, tspb.MockTestService{
+		TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+			calls++
+			return &tspb.TestResponse{Value: "mutated"}, nil
+		},
+	}, grpc.UnaryInterceptor(icptr.UnaryServerInterceptor()))
+
+	cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	resp, err := tspb.NewTestServiceClient(cc).Test(ctx, &tspb.TestRequest{Key: "42"})
+	require.NoError(t, err)
+	assert.Equal(t, "mutated", resp.Value)
+	assert.Equal(t, 1, calls)
+
+	_, ok := lookup(t, icptr, keyOf("42"))
+	assert.False(t, ok)
+	_, ok = lookup(t, icptr, "tagged")
+	assert.False(t, ok)
+	_, ok = lookup(t, icptr, keyOf("43"))
+	assert.True(t, ok)
+}
+
+func TestExpandTag(t *testing.T) {
+	msg := (&tspb.TestRequest{Key: "42"}).ProtoReflect()
+
+	tag, err := expandTag("order:{key}", msg)
+	require.NoError(t, err)
+	assert.Equal(t, "order:42", tag)
+
+	_, err = expandTag("order:{unknown}", msg)
+	assert.Error(t, err)
+}
+
+func TestSetField(t *testing.T) {
+	src := (&tspb.TestResponse{Value: "42"}).ProtoReflect()
+	v, fd, err := lookupField(src, "value")
+	require.NoError(t, err)
+
+	dst := (&tspb.TestRequest{}).ProtoReflect()
+	require.NoError(t, setField(dst, "key", v, fd))
+	assert.Equal(t, "42", dst.Interface().(*tspb.TestRequest).Key)
+
+	assert.Error(t, setField(dst, "unknown", v, fd))
+	assert.Error(t, setField(dst, "key.nested", v, fd))
+}
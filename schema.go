@@ -0,0 +1,280 @@
+package gcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SchemaPolicy specifies which cached entries are served
+// after the schema of the request or response messages changes.
+type SchemaPolicy int
+
+const (
+	// SchemaStrict serves only the entries written with
+	// exactly the same request and response schemas.
+	SchemaStrict SchemaPolicy = iota
+	// SchemaAdditive also serves the entries written before fields,
+	// messages or enum values were added to the schemas, as long as
+	// all fields of the entry's schemas are still there, with the same
+	// names, numbers and types. Added fields of such entries are empty.
+	SchemaAdditive
+)
+
+// schemaMagic starts the values sealed with the schema fingerprint.
+// Protobuf messages never start with 0xff, as its wire type is invalid.
+var schemaMagic = []byte{0xff, 'S'}
+
+// schemaKeyPrefix starts the keys of the field sets of the schemas.
+const schemaKeyPrefix = "gcache:schema:"
+
+// schemaPublishInterval is how often the field set of the schema is
+// saved again, in case the store lost it, e.g. to eviction or Purge.
+const schemaPublishInterval = time.Minute
+
+// errSchemaMismatch is returned when the entry was written
+// with the incompatible schema.
+var errSchemaMismatch = errors.New("entry schema mismatch")
+
+// schema is the fingerprint of the request and response messages
+// of the method. Values are sealed as
+//
+//	magic | sum | value
+//
+// where sum is the hash of the whole schema. With SchemaAdditive, the
+// hashes of the fields, identifying them one by one, are saved once per
+// schema under schemaKeyPrefix and the sum, to be compared with the
+// fields of the other schemas.
+type schema struct {
+	policy    SchemaPolicy
+	sum       uint64
+	fields    map[uint64]struct{}
+	record    []byte       // field hashes, saved with SchemaAdditive
+	header    []byte       // magic and sum
+	published atomic.Int64 // unix nanoseconds of the last save of the record
+	known     sync.Map     // sum -> error, compatibility of the other schemas
+}
+
+// newSchema makes the fingerprint of the method's messages.
+func newSchema(md protoreflect.MethodDescriptor, policy SchemaPolicy) *schema {
+	var elems []uint64
+	add := func(el string) {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(el))
+		elems = append(elems, h.Sum64())
+	}
+
+	schemaElements("req", md.Input(), map[protoreflect.FullName]bool{}, add)
+	schemaElements("resp", md.Output(), map[protoreflect.FullName]bool{}, add)
+
+	slices.Sort(elems)
+	elems = slices.Compact(elems)
+
+	s := &schema{policy: policy, fields: make(map[uint64]struct{}, len(elems))}
+
+	sum := fnv.New64a()
+	for _, el := range elems {
+		_ = binary.Write(sum, binary.BigEndian, el)
+		s.fields[el] = struct{}{}
+		s.record = binary.BigEndian.AppendUint64(s.record, el)
+	}
+	s.sum = sum.Sum64()
+	s.header = binary.BigEndian.AppendUint64(append([]byte{}, schemaMagic...), s.sum)
+
+	return s
+}
+
+// schemaElements describes every field of the message and of the
+// messages and enums it refers to, one element per field or enum value.
+func schemaElements(role string, md protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool, add func(string)) {
+	if seen[md.FullName()] {
+		return
+	}
+	seen[md.FullName()] = true
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		el := role + ":" + string(f.FullName()) + "=" + strconv.Itoa(int(f.Number())) +
+			":" + f.Kind().String() + ":" + f.Cardinality().String()
+
+		switch {
+		case f.Message() != nil:
+			el += ":" + string(f.Message().FullName())
+			schemaElements(role, f.Message(), seen, add)
+		case f.Enum() != nil:
+			el += ":" + string(f.Enum().FullName())
+			enumElements(role, f.Enum(), seen, add)
+		}
+
+		add(el)
+	}
+}
+
+// enumElements describes every value of the enum.
+func enumElements(role string, ed protoreflect.EnumDescriptor, seen map[protoreflect.FullName]bool, add func(string)) {
+	if seen[ed.FullName()] {
+		return
+	}
+	seen[ed.FullName()] = true
+
+	values := ed.Values()
+	for i := 0; i < values.Len(); i++ {
+		v := values.Get(i)
+		add(role + ":" + string(v.FullName()) + "=" + strconv.Itoa(int(v.Number())))
+	}
+}
+
+// seal prefixes the value with the fingerprint.
+// A nil schema returns the value as is.
+func (s *schema) seal(value []byte) []byte {
+	if s == nil {
+		return value
+	}
+	return append(append(make([]byte, 0, len(s.header)+len(value)), s.header...), value...)
+}
+
+// schemaKey returns the key of the field set of the schema.
+func schemaKey(sum uint64) string {
+	return schemaKeyPrefix + strconv.FormatUint(sum, 16)
+}
+
+// publish saves the field set of the schema with SchemaAdditive,
+// unless it was saved within the schemaPublishInterval.
+// A nil schema does nothing.
+func (s *schema) publish(ctx context.Context, store StoreV2, now time.Time) error {
+	if s == nil || s.policy != SchemaAdditive {
+		return nil
+	}
+
+	last := s.published.Load()
+	if now.UnixNano()-last < int64(schemaPublishInterval) || !s.published.CompareAndSwap(last, now.UnixNano()) {
+		return nil
+	}
+
+	if err := store.Save(ctx, schemaKey(s.sum), Entry{Value: s.record}); err != nil {
+		s.published.Store(0)
+		return fmt.Errorf("save schema %x: %w", s.sum, err)
+	}
+
+	return nil
+}
+
+// open returns the value sealed with the compatible fingerprint, looking
+// up the field sets of the other schemas in the store with SchemaAdditive.
+// A nil schema returns the value as is.
+func (s *schema) open(ctx context.Context, store StoreV2, value []byte) ([]byte, error) {
+	if s == nil {
+		return value, nil
+	}
+
+	rest, ok := bytes.CutPrefix(value, schemaMagic)
+	if !ok || len(rest) < 8 {
+		return nil, fmt.Errorf("%w: no fingerprint", errSchemaMismatch)
+	}
+
+	sum, value := binary.BigEndian.Uint64(rest), rest[8:]
+	if sum == s.sum {
+		return value, nil
+	}
+
+	if s.policy != SchemaAdditive {
+		return nil, fmt.Errorf("%w: fingerprint %x, expected %x", errSchemaMismatch, sum, s.sum)
+	}
+
+	if err, ok := s.known.Load(sum); ok {
+		if err != nil {
+			return nil, err.(error)
+		}
+		return value, nil
+	}
+
+	e, err := store.Load(ctx, schemaKey(sum))
+	if err != nil {
+		// not remembered, the fields might be saved later
+		return nil, fmt.Errorf("%w: load fields of %x: %w", errSchemaMismatch, sum, err)
+	}
+
+	err = s.compatible(e.Value)
+	s.known.Store(sum, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// compatible checks that all fields of the other schema are still there.
+func (s *schema) compatible(record []byte) error {
+	if len(record)%8 != 0 {
+		return fmt.Errorf("%w: malformed fields", errSchemaMismatch)
+	}
+
+	for i := 0; i < len(record); i += 8 {
+		if _, ok := s.fields[binary.BigEndian.Uint64(record[i:])]; !ok {
+			return fmt.Errorf("%w: field is changed or removed", errSchemaMismatch)
+		}
+	}
+
+	return nil
+}
+
+// schemaOf returns the fingerprint of the method's messages, nil if the
+// fingerprints are disabled, or the method isn't in the proto registry.
+func (c *Interceptor) schemaOf(ctx context.Context, method string) *schema {
+	if !c.fingerprints {
+		return nil
+	}
+
+	if s, ok := c.schemas.Load(method); ok {
+		return s.(*schema)
+	}
+
+	var s *schema
+	md, err := methodDescriptor(method)
+	if err != nil {
+		c.logger.WarnContext(ctx, "gcache: failed to resolve method, entries won't be fingerprinted",
+			slog.String("method", method), slog.Any(ErrKey, err))
+	} else {
+		s = newSchema(md, c.schemaPolicy)
+	}
+
+	c.schemas.Store(method, s)
+	return s
+}
+
+// publishSchema saves the field set of the method's schema, so that
+// the binaries with the other schemas are able to compare it with theirs.
+func (c *Interceptor) publishSchema(ctx context.Context, method string, s *schema) {
+	if err := s.publish(ctx, c.store, time.Now()); err != nil {
+		c.logger.WarnContext(ctx, "gcache: failed to save schema, entries might be missed by other binaries",
+			slog.String("method", method), slog.Any(ErrKey, err))
+	}
+}
+
+// load loads the entry, opening its value with the fingerprint,
+// the entries of the incompatible schemas are reported and treated as misses.
+func (c *Interceptor) load(ctx context.Context, method, key string, s *schema) (Entry, error) {
+	e, err := c.store.Load(ctx, key)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	if e.Value, err = s.open(ctx, c.store, e.Value); err != nil {
+		c.observer.Observe(ctx, Event{Kind: EventSchemaMismatch, Method: method, Key: key, Err: err})
+		return Entry{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,154 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/cappuccinotm/gcache/internal/tspb"
+)
+
+func TestSchema(t *testing.T) {
+	name := &descriptorpb.FieldDescriptorProto{Name: proto.String("name"), Number: proto.Int32(1),
+		Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()}
+	age := &descriptorpb.FieldDescriptorProto{Name: proto.String("age"), Number: proto.Int32(2),
+		Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum()}
+	title := &descriptorpb.FieldDescriptorProto{Name: proto.String("title"), Number: proto.Int32(1),
+		Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum()}
+
+	v1 := schemaTestMethod(t, name)
+	added := schemaTestMethod(t, name, age)
+	renamed := schemaTestMethod(t, title)
+
+	value := []byte("value")
+	ctx := context.Background()
+
+	t.Run("strict", func(t *testing.T) {
+		old := newSchema(v1, SchemaStrict)
+
+		got, err := newSchema(v1, SchemaStrict).open(ctx, nil, old.seal(value))
+		require.NoError(t, err)
+		assert.Equal(t, value, got)
+
+		_, err = newSchema(added, SchemaStrict).open(ctx, nil, old.seal(value))
+		assert.ErrorIs(t, err, errSchemaMismatch)
+
+		_, err = newSchema(v1, SchemaStrict).open(ctx, nil, value)
+		assert.ErrorIs(t, err, errSchemaMismatch, "values without fingerprint must not be served")
+	})
+
+	t.Run("additive", func(t *testing.T) {
+		store := NewMemory(1 << 20).(StoreV2)
+		old := newSchema(v1, SchemaAdditive)
+		assert.Len(t, old.seal(value), len(schemaMagic)+8+len(value), "fields must not be sealed into entries")
+
+		_, err := newSchema(added, SchemaAdditive).open(ctx, store, old.seal(value))
+		assert.ErrorIs(t, err, errSchemaMismatch, "unknown fields must not be allowed")
+
+		require.NoError(t, old.publish(ctx, store, time.Now()))
+		got, err := newSchema(added, SchemaAdditive).open(ctx, store, old.seal(value))
+		require.NoError(t, err, "added field must be allowed")
+		assert.Equal(t, value, got)
+
+		_, err = newSchema(renamed, SchemaAdditive).open(ctx, store, old.seal(value))
+		assert.ErrorIs(t, err, errSchemaMismatch, "renamed field must not be allowed")
+
+		newer := newSchema(added, SchemaAdditive)
+		require.NoError(t, newer.publish(ctx, store, time.Now()))
+		_, err = newSchema(v1, SchemaAdditive).open(ctx, store, newer.seal(value))
+		assert.ErrorIs(t, err, errSchemaMismatch, "removed field must not be allowed")
+
+		_, err = newSchema(added, SchemaAdditive).open(ctx, store, newSchema(renamed, SchemaStrict).seal(value))
+		assert.ErrorIs(t, err, errSchemaMismatch, "strict schemas don't save their fields")
+	})
+
+	t.Run("publish", func(t *testing.T) {
+		store := &countingStore{StoreV2: NewMemory(1 << 20).(StoreV2)}
+		s, now := newSchema(v1, SchemaAdditive), time.Now()
+
+		for range 3 {
+			require.NoError(t, s.publish(ctx, store, now))
+		}
+		assert.Equal(t, 1, store.saves, "fields must be saved once per schema")
+
+		require.NoError(t, s.publish(ctx, store, now.Add(schemaPublishInterval)))
+		assert.Equal(t, 2, store.saves, "fields must be saved again after the interval")
+	})
+}
+
+func TestInterceptor_SchemaFingerprint(t *testing.T) {
+	var events []Event
+	icptr := NewInterceptor(WithSchemaFingerprint(SchemaStrict),
+		WithObserver(ObserverFunc(func(_ context.Context, ev Event) { events = append(events, ev) })))
+
+	bts, err := RawBytesCodec{}.Marshal(&tspb.TestResponse{Value: "old-binary"})
+	require.NoError(t, err)
+	save(t, icptr, emptyReqKey, Entry{Value: bts})
+
+	calls := 0
+	addr := tspb.Run(t, tspb.MockTestService{
+		TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+			calls++
+			return &tspb.TestResponse{Value: "from-handler"}, nil
+		},
+	}, grpc.UnaryInterceptor(icptr.UnaryServerInterceptor()))
+
+	cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	cl := tspb.NewTestServiceClient(cc)
+
+	for range 2 {
+		resp, err := cl.Test(context.Background(), &tspb.TestRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, "from-handler", resp.Value)
+	}
+	assert.Equal(t, 1, calls, "fingerprinted entry must be served")
+
+	require.Len(t, events, 3)
+	assert.Equal(t, EventSchemaMismatch, events[0].Kind)
+	assert.Equal(t, []EventKind{EventMiss, EventHit}, []EventKind{events[1].Kind, events[2].Kind})
+}
+
+// schemaTestMethod makes the descriptor of the method, whose response has the given fields.
+func schemaTestMethod(t *testing.T, respFields ...*descriptorpb.FieldDescriptorProto) protoreflect.MethodDescriptor {
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Request")},
+			{Name: proto.String("Response"), Field: respFields},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Service"),
+			Method: []*descriptorpb.MethodDescriptorProto{{
+				Name:       proto.String("Get"),
+				InputType:  proto.String(".test.Request"),
+				OutputType: proto.String(".test.Response"),
+			}},
+		}},
+	}, nil)
+	require.NoError(t, err)
+
+	return fd.Services().Get(0).Methods().Get(0)
+}
+
+// countingStore counts the saves to the store.
+type countingStore struct {
+	StoreV2
+	saves int
+}
+
+func (s *countingStore) Save(ctx context.Context, key string, e Entry) error {
+	s.saves++
+	return s.StoreV2.Save(ctx, key, e)
+}
@@ -0,0 +1,100 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+)
+
+type shardedStore struct {
+	seed   maphash.Seed
+	shards []*memoryStore
+}
+
+// NewSharded makes an in-memory store, that stripes the keys across
+// the given number of independent memory stores, each with its own lock,
+// so that concurrent calls for different keys rarely contend.
+// The budget is split evenly between the shards, thus an entry
+// must fit into maxBytes/shards to be stored.
+// Options are applied to every shard.
+func NewSharded(shards int, maxBytes int64, opts ...MemoryOption) Store {
+	shards = max(shards, 1)
+
+	s := &shardedStore{
+		seed:   maphash.MakeSeed(),
+		shards: make([]*memoryStore, shards),
+	}
+
+	for i := range s.shards {
+		s.shards[i] = NewMemory(maxBytes/int64(shards), opts...).(*memoryStore)
+	}
+
+	return s
+}
+
+// Get returns the value for the given key.
+func (s *shardedStore) Get(ctx context.Context, key string) (Entry, bool) {
+	return s.shard(key).Get(ctx, key)
+}
+
+// Set sets the value for the given key.
+func (s *shardedStore) Set(ctx context.Context, key string, e Entry) {
+	s.shard(key).Set(ctx, key, e)
+}
+
+// Remove removes the value for the given key.
+func (s *shardedStore) Remove(ctx context.Context, key string) {
+	s.shard(key).Remove(ctx, key)
+}
+
+// Load returns the value for the given key, or ErrNotFound.
+func (s *shardedStore) Load(ctx context.Context, key string) (Entry, error) {
+	return s.shard(key).Load(ctx, key)
+}
+
+// Save sets the value for the given key.
+func (s *shardedStore) Save(ctx context.Context, key string, e Entry) error {
+	return s.shard(key).Save(ctx, key, e)
+}
+
+// Delete removes the value for the given key.
+func (s *shardedStore) Delete(ctx context.Context, key string) error {
+	return s.shard(key).Delete(ctx, key)
+}
+
+// InvalidateTags removes all entries that carry any of the given tags.
+func (s *shardedStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	errs := make([]error, len(s.shards))
+	for i, shard := range s.shards {
+		errs[i] = shard.InvalidateTags(ctx, tags...)
+	}
+	return errors.Join(errs...)
+}
+
+// RemovePrefix removes all entries whose keys start with the given prefix.
+func (s *shardedStore) RemovePrefix(ctx context.Context, prefix string) error {
+	errs := make([]error, len(s.shards))
+	for i, shard := range s.shards {
+		errs[i] = shard.RemovePrefix(ctx, prefix)
+	}
+	return errors.Join(errs...)
+}
+
+// Range calls fn for the entries of every shard in turn.
+func (s *shardedStore) Range(ctx context.Context, fn func(key string, e Entry) bool) error {
+	stopped := false
+	for _, shard := range s.shards {
+		err := shard.Range(ctx, func(key string, e Entry) bool {
+			stopped = !fn(key, e)
+			return !stopped
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *shardedStore) shard(key string) *memoryStore {
+	return s.shards[maphash.String(s.seed, key)%uint64(len(s.shards))]
+}
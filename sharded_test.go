@@ -0,0 +1,86 @@
+package gcache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedStore(t *testing.T) {
+	ctx := context.Background()
+	const entrySize = memoryEntryOverhead + 10 + 2 // value + key
+
+	store := NewSharded(4, 4*10*entrySize).(*shardedStore)
+
+	for i := 0; i < 100; i++ {
+		store.Set(ctx, fmt.Sprintf("%02d", i), Entry{Value: make([]byte, 10), Tags: []string{fmt.Sprintf("tag:%d", i%2)}})
+	}
+
+	var total int64
+	for _, shard := range store.shards {
+		assert.LessOrEqual(t, shard.size, shard.maxBytes)
+		total += shard.size
+	}
+	assert.LessOrEqual(t, total, int64(4*10*entrySize))
+
+	_, ok := store.Get(ctx, "99")
+	require.True(t, ok, "the latest entry must be present")
+
+	require.NoError(t, store.InvalidateTags(ctx, "tag:1"))
+	_, ok = store.Get(ctx, "99")
+	assert.False(t, ok)
+
+	require.NoError(t, store.RemovePrefix(ctx, "9"))
+	_, ok = store.Get(ctx, "98")
+	assert.False(t, ok)
+
+	store.Remove(ctx, "97")
+	_, ok = store.Get(ctx, "97")
+	assert.False(t, ok)
+}
+
+func BenchmarkStores_Parallel(b *testing.B) {
+	const keys = 1 << 14
+
+	stores := map[string]func() Store{
+		"lru": func() Store {
+			l, _ := lru.New[string, Entry](keys / 2)
+			return NewLRU(l)
+		},
+		"memory": func() Store { return NewMemory(keys / 2 * (memoryEntryOverhead + 128)) },
+		"sharded": func() Store {
+			return NewSharded(64, keys/2*(memoryEntryOverhead+128))
+		},
+	}
+
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = fmt.Sprintf("/svc.Orders/Get{%016x}", i)
+	}
+
+	for _, name := range []string{"lru", "memory", "sharded"} {
+		b.Run(name, func(b *testing.B) {
+			store := stores[name]()
+			ctx := context.Background()
+			value := make([]byte, 100)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(rand.Int63())) //nolint:gosec // OK for benchmarks
+				zipf := rand.NewZipf(rnd, 1.1, 1, keys-1)
+				for pb.Next() {
+					key := names[zipf.Uint64()]
+					if _, ok := store.Get(ctx, key); !ok {
+						store.Set(ctx, key, Entry{Value: value})
+					}
+				}
+			})
+		})
+	}
+}
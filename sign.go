@@ -0,0 +1,155 @@
+package gcache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// SigningKey is the HMAC key of the signing store with its ID,
+// which is stored along with the signatures.
+type SigningKey struct {
+	ID  string
+	Key []byte
+}
+
+// SigningOption is a configuration option for the signing store.
+type SigningOption func(*signer)
+
+// WithSigningObserver sets the observer to report the entries
+// that fail the verification to.
+func WithSigningObserver(o Observer) SigningOption {
+	return func(g *signer) { g.observer = o }
+}
+
+// WithSigningLogger sets the logger.
+func WithSigningLogger(l *slog.Logger) SigningOption {
+	return func(g *signer) { g.logger = l }
+}
+
+// errTampered is returned when the entry fails the verification.
+var errTampered = errors.New("entry is tampered")
+
+type signer struct {
+	primary  string
+	keys     map[string][]byte // by key ID
+	observer Observer
+	logger   *slog.Logger
+}
+
+// NewSigned makes a store that signs the entries with HMAC-SHA256 before
+// saving them to the given store, and verifies them on load, so that
+// the entries planted into a shared store by other writers are never
+// served. The signature covers the cache key, the value, the ETag and
+// the expiry of the entry, with the expiry truncated to milliseconds,
+// as some stores keep it so. Entries are signed with the first key and
+// verified with the key of the ID stored along with the signature, so
+// the keys are rotated by putting the new key first and keeping the old
+// ones until their entries expire.
+//
+// Entries that fail the verification are treated as misses and reported
+// to the observer as EventTampered. Values aren't encrypted, see
+// NewEncrypted for that. Tags, prefixes, snapshots, batches and
+// CompareAndSwap are passed through to the given store.
+func NewSigned(store Store, keys []SigningKey, opts ...SigningOption) (Store, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+
+	g := &signer{
+		primary:  keys[0].ID,
+		keys:     make(map[string][]byte, len(keys)),
+		observer: nopObserver{},
+		logger:   discardLogger,
+	}
+
+	for _, k := range keys {
+		if _, ok := g.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate signing key %q", k.ID)
+		}
+		if len(k.Key) == 0 {
+			return nil, fmt.Errorf("empty signing key %q", k.ID)
+		}
+		g.keys[k.ID] = k.Key
+	}
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	return wrapStore(store, middlewareStore{logger: g.logger, encode: g.encode, decode: g.decode}), nil
+}
+
+// encode signs the entry.
+func (g *signer) encode(_ context.Context, key string, e Entry) (Entry, error) {
+	return g.sign(key, e), nil
+}
+
+// decode verifies the entry, the entries that fail
+// the verification are reported and treated as misses.
+func (g *signer) decode(ctx context.Context, key string, e Entry) (Entry, error) {
+	e, err := g.verify(key, e)
+	if err != nil {
+		g.logger.WarnContext(ctx, "gcache: entry failed the verification",
+			slog.String("key", key), slog.Any(ErrKey, err))
+		g.observer.Observe(ctx, Event{Kind: EventTampered, Key: key, Op: "load", Err: err})
+		return Entry{}, fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return e, nil
+}
+
+// sign prefixes the value with the signature made with the primary key:
+//
+//	uvarint len | key ID | HMAC-SHA256 | value
+func (g *signer) sign(key string, e Entry) Entry {
+	buf := binary.AppendUvarint(nil, uint64(len(g.primary)))
+	buf = append(buf, g.primary...)
+	buf = append(buf, g.mac(g.keys[g.primary], key, e)...)
+	e.Value = append(buf, e.Value...)
+	return e
+}
+
+// verify checks the signature of the entry made by sign,
+// returning the entry with the original value.
+func (g *signer) verify(key string, e Entry) (Entry, error) {
+	n, read := binary.Uvarint(e.Value)
+	if read <= 0 || n > uint64(len(e.Value)-read) {
+		return Entry{}, fmt.Errorf("%w: malformed signature", errTampered)
+	}
+	id, rest := string(e.Value[read:read+int(n)]), e.Value[read+int(n):]
+
+	secret, ok := g.keys[id]
+	if !ok {
+		return Entry{}, fmt.Errorf("%w: unknown key %q", errTampered, id)
+	}
+
+	if len(rest) < sha256.Size {
+		return Entry{}, fmt.Errorf("%w: malformed signature", errTampered)
+	}
+
+	sig := rest[:sha256.Size]
+	e.Value = rest[sha256.Size:]
+	if !hmac.Equal(sig, g.mac(secret, key, e)) {
+		return Entry{}, fmt.Errorf("%w: signature mismatch", errTampered)
+	}
+
+	return e, nil
+}
+
+// mac returns the HMAC of the signed fields of the entry,
+// encoded as the record of the disk store.
+func (g *signer) mac(secret []byte, key string, e Entry) []byte {
+	signed := Entry{Value: e.Value, ETag: e.ETag}
+	if !e.ExpiresAt.IsZero() {
+		signed.ExpiresAt = time.UnixMilli(e.ExpiresAt.UnixMilli())
+	}
+
+	h := hmac.New(sha256.New, secret)
+	_, _ = h.Write(appendRecord(nil, key, signed))
+	return h.Sum(nil)
+}
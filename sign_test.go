@@ -0,0 +1,103 @@
+package gcache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedStore(t *testing.T) {
+	ctx := context.Background()
+	k1 := SigningKey{ID: "k1", Key: []byte("secret-1")}
+	k2 := SigningKey{ID: "k2", Key: []byte("secret-2")}
+
+	var events []Event
+	observer := ObserverFunc(func(_ context.Context, ev Event) { events = append(events, ev) })
+
+	inner := NewMemory(1 << 20)
+	store, err := NewSigned(inner, []SigningKey{k1}, WithSigningObserver(observer))
+	require.NoError(t, err)
+
+	expiresAt := time.Now().Add(time.Hour)
+	e := Entry{Value: []byte("order"), ETag: "v1", ExpiresAt: expiresAt, Tags: []string{"order:1"}}
+	require.NoError(t, AdaptStore(store).Save(ctx, "a", e))
+
+	got, err := AdaptStore(store).Load(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, e, got)
+
+	tamper := map[string]func(e *Entry){
+		"value":  func(e *Entry) { e.Value = append(bytes.Clone(e.Value[:len(e.Value)-1]), 'X') },
+		"etag":   func(e *Entry) { e.ETag = "v2" },
+		"expiry": func(e *Entry) { e.ExpiresAt = e.ExpiresAt.Add(time.Hour) },
+		"planted": func(e *Entry) {
+			*e = Entry{Value: []byte("planted")}
+		},
+	}
+
+	for name, fn := range tamper {
+		t.Run(name, func(t *testing.T) {
+			events = nil
+
+			raw, err := AdaptStore(inner).Load(ctx, "a")
+			require.NoError(t, err)
+			fn(&raw)
+			inner.Set(ctx, "tampered", raw)
+
+			_, err = AdaptStore(store).Load(ctx, "tampered")
+			assert.ErrorIs(t, err, ErrNotFound)
+			require.Len(t, events, 1)
+			assert.Equal(t, EventTampered, events[0].Kind)
+			assert.Equal(t, "tampered", events[0].Key)
+			assert.ErrorIs(t, events[0].Err, errTampered)
+		})
+	}
+
+	t.Run("copied under another key", func(t *testing.T) {
+		raw, err := AdaptStore(inner).Load(ctx, "a")
+		require.NoError(t, err)
+		inner.Set(ctx, "copy", raw)
+
+		_, err = AdaptStore(store).Load(ctx, "copy")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		rotated, err := NewSigned(inner, []SigningKey{k2, k1})
+		require.NoError(t, err)
+
+		_, err = AdaptStore(rotated).Load(ctx, "a")
+		require.NoError(t, err, "entries of the old key are still verified")
+
+		require.NoError(t, AdaptStore(rotated).Save(ctx, "b", Entry{Value: []byte("b")}))
+		_, err = AdaptStore(store).Load(ctx, "b")
+		assert.ErrorIs(t, err, ErrNotFound, "entries of the unknown key are rejected")
+	})
+
+	t.Run("redis hash store", func(t *testing.T) {
+		hashStore, _ := newTestRedisHashStore(t)
+		store, err := NewSigned(hashStore, []SigningKey{k1})
+		require.NoError(t, err)
+
+		// the store keeps the expiry in milliseconds
+		require.NoError(t, AdaptStore(store).Save(ctx, "a", e))
+		got, err := AdaptStore(store).Load(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, e.Value, got.Value)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		_, err := NewSigned(inner, nil)
+		assert.Error(t, err)
+
+		_, err = NewSigned(inner, []SigningKey{{ID: "empty"}})
+		assert.Error(t, err)
+
+		_, err = NewSigned(inner, []SigningKey{k1, k1})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,223 @@
+package gcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// snapshotMagic opens every snapshot.
+const snapshotMagic = "GCACHESNAP"
+
+// snapshotVersion is the version of the snapshot format, it must be
+// increased on any incompatible change of the format. Version 1 had
+// no generation in the header.
+const snapshotVersion = 2
+
+// ErrSnapshotVersion is returned when restoring a snapshot of unknown version.
+var ErrSnapshotVersion = errors.New("gcache: unsupported snapshot version")
+
+// ErrSnapshotGeneration is returned when restoring a snapshot made
+// before the purge, see WithGenerations.
+var ErrSnapshotGeneration = errors.New("gcache: snapshot of a previous generation")
+
+// Snapshot writes the entries of the store to w. The snapshot is
+//
+//	magic | uvarint version | uvarint generation | records | end marker | uvarint count
+//
+// where records are the entries in the order the store ranges over them,
+// encoded the same way the disk store keeps them, and generation is
+// the one of the interceptor, zero for the stores snapshotted directly.
+func Snapshot(ctx context.Context, w io.Writer, store Ranger) error {
+	return writeSnapshot(ctx, w, store, 0)
+}
+
+// writeSnapshot writes the snapshot of the entries of the given generation.
+func writeSnapshot(ctx context.Context, w io.Writer, store Ranger, gen uint64) error {
+	bw := bufio.NewWriter(w)
+
+	buf := binary.AppendUvarint([]byte(snapshotMagic), snapshotVersion)
+	buf = binary.AppendUvarint(buf, gen)
+	if _, err := bw.Write(buf); err != nil {
+		return fmt.Errorf("write header: %w", err)
+	}
+
+	var count uint64
+	var werr error
+	err := store.Range(ctx, func(key string, e Entry) bool {
+		if werr = ctx.Err(); werr != nil {
+			return false
+		}
+		buf = appendRecord(buf[:0], key, e)
+		if _, werr = bw.Write(buf); werr != nil {
+			werr = fmt.Errorf("write entry %s: %w", key, werr)
+			return false
+		}
+		count++
+		return true
+	})
+	if err = errors.Join(err, werr); err != nil {
+		return fmt.Errorf("range over entries: %w", err)
+	}
+
+	buf = binary.AppendUvarint(appendRecordsEnd(buf[:0]), count)
+	if _, err = bw.Write(buf); err != nil {
+		return fmt.Errorf("write trailer: %w", err)
+	}
+
+	if err = bw.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+
+	return nil
+}
+
+// Restore saves the entries from the snapshot made by Snapshot to the store,
+// skipping the expired ones. A truncated or corrupt snapshot is reported
+// with ErrCorruptRecord, the entries read before the damage stay restored.
+func Restore(ctx context.Context, r io.Reader, store StoreV2) error {
+	return readSnapshot(ctx, r, store, 0)
+}
+
+// readSnapshot restores the snapshot, unless it was made
+// before the given generation.
+func readSnapshot(ctx context.Context, r io.Reader, store StoreV2, minGen uint64) error {
+	br := bufio.NewReader(r)
+
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != snapshotMagic {
+		return fmt.Errorf("%w: not a snapshot", ErrCorruptRecord)
+	}
+
+	version, err := binary.ReadUvarint(br)
+	if err != nil {
+		return fmt.Errorf("%w: read version: %w", ErrCorruptRecord, err)
+	}
+	if version < 1 || version > snapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+
+	var gen uint64
+	if version > 1 {
+		if gen, err = binary.ReadUvarint(br); err != nil {
+			return fmt.Errorf("%w: read generation: %w", ErrCorruptRecord, err)
+		}
+	}
+	if gen < minGen {
+		return fmt.Errorf("%w: %d, current is %d", ErrSnapshotGeneration, gen, minGen)
+	}
+
+	var count uint64
+	now := time.Now()
+	for {
+		key, e, err := readRecord(br)
+		switch {
+		case errors.Is(err, errRecordsEnd):
+			total, err := binary.ReadUvarint(br)
+			if err != nil || total != count {
+				return fmt.Errorf("%w: snapshot is incomplete", ErrCorruptRecord)
+			}
+			return nil
+		case errors.Is(err, io.EOF):
+			return fmt.Errorf("%w: snapshot is truncated", ErrCorruptRecord)
+		case err != nil:
+			return fmt.Errorf("read entry: %w", err)
+		}
+
+		count++
+		if e.expired(now) {
+			continue
+		}
+
+		if err = store.Save(ctx, key, e); err != nil {
+			return fmt.Errorf("save entry %s: %w", key, err)
+		}
+	}
+}
+
+// Snapshot writes the entries of the interceptor's store to w,
+// along with the current generation, the store must implement Ranger.
+func (c *Interceptor) Snapshot(ctx context.Context, w io.Writer) error {
+	r, ok := c.store.(Ranger)
+	if !ok {
+		return ErrNotSupported
+	}
+	return writeSnapshot(ctx, w, r, c.currentGeneration())
+}
+
+// Restore saves the entries from the snapshot to the interceptor's store.
+// Snapshots made before the current generation are rejected with
+// ErrSnapshotGeneration, as the purge dropped their entries.
+func (c *Interceptor) Restore(ctx context.Context, r io.Reader) error {
+	return readSnapshot(ctx, r, c.store, c.currentGeneration())
+}
+
+// currentGeneration returns the current generation,
+// zero if generations are disabled.
+func (c *Interceptor) currentGeneration() uint64 {
+	if c.generation == nil {
+		return 0
+	}
+	return c.generation.current.Load()
+}
+
+// restoreFile restores the snapshot from the file, if it exists.
+func (c *Interceptor) restoreFile(ctx context.Context) {
+	f, err := os.Open(c.snapshotFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return
+	case err != nil:
+		c.logger.WarnContext(ctx, "gcache: failed to open snapshot", slog.Any(ErrKey, err))
+		return
+	}
+	defer f.Close()
+
+	switch err = c.Restore(ctx, f); {
+	case errors.Is(err, ErrSnapshotGeneration):
+		c.logger.InfoContext(ctx, "gcache: snapshot is outdated by purge, skipping", slog.Any(ErrKey, err))
+	case err != nil:
+		c.logger.WarnContext(ctx, "gcache: failed to restore snapshot", slog.Any(ErrKey, err))
+	}
+}
+
+// snapshotToFile atomically replaces the snapshot file.
+func (c *Interceptor) snapshotToFile(ctx context.Context) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(c.snapshotFile), filepath.Base(c.snapshotFile)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if err = c.Snapshot(ctx, f); err != nil {
+		return err
+	}
+
+	if err = f.Sync(); err != nil {
+		return fmt.Errorf("sync temporary file: %w", err)
+	}
+
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("close temporary file: %w", err)
+	}
+
+	if err = os.Rename(f.Name(), c.snapshotFile); err != nil {
+		return fmt.Errorf("rename temporary file: %w", err)
+	}
+
+	return nil
+}
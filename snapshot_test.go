@@ -0,0 +1,154 @@
+package gcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	stores := map[string]func() Store{
+		"lru": func() Store {
+			l, _ := lru.New[string, Entry](3)
+			return NewLRU(l)
+		},
+		"arc": func() Store {
+			s, _ := NewARC(3)
+			return s
+		},
+		"tinylfu": func() Store {
+			s, _ := NewTinyLFU(3)
+			return s
+		},
+		"memory":  func() Store { return NewMemory(1 << 20) },
+		"sharded": func() Store { return NewSharded(4, 1<<20) },
+	}
+
+	expiresAt := time.Unix(0, time.Now().Add(time.Hour).UnixNano())
+
+	for name, mk := range stores {
+		t.Run(name, func(t *testing.T) {
+			src := mk()
+			src.Set(ctx, "a", Entry{Value: []byte("a"), ETag: "1", Tags: []string{"tag"}})
+			src.Set(ctx, "b", Entry{Value: []byte("b"), ExpiresAt: expiresAt, Cost: time.Second})
+			src.Set(ctx, "expired", Entry{Value: []byte("c"), ExpiresAt: time.Now().Add(-time.Second)})
+
+			var buf bytes.Buffer
+			require.NoError(t, Snapshot(ctx, &buf, src.(Ranger)))
+
+			dst := mk()
+			require.NoError(t, Restore(ctx, &buf, AdaptStore(dst)))
+
+			e, ok := dst.Get(ctx, "a")
+			require.True(t, ok)
+			assert.Equal(t, Entry{Value: []byte("a"), ETag: "1", Tags: []string{"tag"}}, e)
+
+			e, ok = dst.Get(ctx, "b")
+			require.True(t, ok)
+			assert.True(t, expiresAt.Equal(e.ExpiresAt))
+			assert.Equal(t, time.Second, e.Cost)
+
+			_, ok = dst.Get(ctx, "expired")
+			assert.False(t, ok)
+
+			require.NoError(t, dst.(TagInvalidator).InvalidateTags(ctx, "tag"))
+			_, ok = dst.Get(ctx, "a")
+			assert.False(t, ok, "tags must be restored")
+		})
+	}
+
+	t.Run("keeps recency", func(t *testing.T) {
+		src := stores["lru"]()
+		src.Set(ctx, "a", Entry{})
+		src.Set(ctx, "b", Entry{})
+		src.Set(ctx, "c", Entry{})
+		_, _ = src.Get(ctx, "a")
+
+		var buf bytes.Buffer
+		require.NoError(t, Snapshot(ctx, &buf, src.(Ranger)))
+
+		dst := stores["lru"]()
+		require.NoError(t, Restore(ctx, &buf, AdaptStore(dst)))
+		dst.Set(ctx, "d", Entry{})
+
+		_, ok := dst.Get(ctx, "b")
+		assert.False(t, ok)
+		_, ok = dst.Get(ctx, "a")
+		assert.True(t, ok)
+	})
+
+	t.Run("under writes", func(t *testing.T) {
+		for name, mk := range stores {
+			src := mk()
+			src.Set(ctx, "a", Entry{Value: []byte("a")})
+			src.Set(ctx, "b", Entry{Value: []byte("b")})
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 100; i++ {
+					src.Set(ctx, "a", Entry{Value: []byte(strconv.Itoa(i))})
+					src.Get(ctx, "b")
+				}
+			}()
+
+			for i := 0; i < 100; i++ {
+				require.NoError(t, Snapshot(ctx, io.Discard, src.(Ranger)), name)
+			}
+			<-done
+		}
+	})
+
+	t.Run("damaged", func(t *testing.T) {
+		src := stores["memory"]()
+		src.Set(ctx, "a", Entry{Value: []byte("a")})
+		src.Set(ctx, "b", Entry{Value: []byte("b")})
+
+		var buf bytes.Buffer
+		require.NoError(t, Snapshot(ctx, &buf, src.(Ranger)))
+		snap := buf.Bytes()
+
+		err := Restore(ctx, bytes.NewReader(snap[:len(snap)-2]), AdaptStore(stores["memory"]()))
+		assert.ErrorIs(t, err, ErrCorruptRecord)
+
+		err = Restore(ctx, bytes.NewReader([]byte("garbage")), AdaptStore(stores["memory"]()))
+		assert.ErrorIs(t, err, ErrCorruptRecord)
+
+		future := binary.AppendUvarint([]byte(snapshotMagic), snapshotVersion+1)
+		err = Restore(ctx, bytes.NewReader(future), AdaptStore(stores["memory"]()))
+		assert.ErrorIs(t, err, ErrSnapshotVersion)
+	})
+
+	t.Run("not supported", func(t *testing.T) {
+		icptr := NewInterceptor(WithStore(nopStore{}))
+		assert.ErrorIs(t, icptr.Snapshot(ctx, &bytes.Buffer{}), ErrNotSupported)
+	})
+}
+
+func TestInterceptor_SnapshotFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "cache.snap")
+
+	store := NewMemory(1 << 20)
+	icptr := NewInterceptor(WithStore(store), WithSnapshotFile(path))
+	store.Set(ctx, "a", Entry{Value: []byte("a")})
+	require.NoError(t, icptr.Close(ctx))
+
+	store = NewMemory(1 << 20)
+	icptr = NewInterceptor(WithStore(store), WithSnapshotFile(path))
+	e, ok := store.Get(ctx, "a")
+	require.True(t, ok)
+	assert.Equal(t, []byte("a"), e.Value)
+	require.NoError(t, icptr.Close(ctx))
+}
@@ -2,8 +2,16 @@ package gcache
 
 import (
 	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
 )
 
+// ErrNotFound is returned by StoreV2 when there is no entry for the key.
+var ErrNotFound = errors.New("gcache: entry not found")
+
 // Store is a cache store.
 type Store interface {
 	Get(ctx context.Context, key string) (e Entry, ok bool)
@@ -11,10 +19,229 @@ type Store interface {
 	Remove(ctx context.Context, key string)
 }
 
+// StoreV2 is a cache store that reports its failures, so that
+// interceptors could tell misses apart from failures.
+// Built-in stores implement both Store and StoreV2.
+type StoreV2 interface {
+	// Load returns the entry for the key, or ErrNotFound if there is none.
+	Load(ctx context.Context, key string) (Entry, error)
+	// Save puts the entry for the key.
+	Save(ctx context.Context, key string, e Entry) error
+	// Delete removes the entry for the key.
+	Delete(ctx context.Context, key string) error
+}
+
+// TagInvalidator is implemented by stores that are able to drop
+// the entries by their tags.
+type TagInvalidator interface {
+	// InvalidateTags removes all entries that carry any of the given tags.
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// PrefixRemover is implemented by stores that are able to drop
+// the entries by the prefix of their keys.
+type PrefixRemover interface {
+	// RemovePrefix removes all entries whose keys start with the given prefix.
+	RemovePrefix(ctx context.Context, prefix string) error
+}
+
+// LocalInvalidator is implemented by stores that keep local copies of the
+// entries of a shared backend, e.g. the local cache of go-redis/cache.
+// Invalidations received from other instances are already applied to
+// the backend by their publisher, so that only the local copies are purged.
+// Stores that are local as a whole are invalidated as usual.
+type LocalInvalidator interface {
+	// InvalidateLocal removes the local copies of the entries,
+	// described by the invalidation, leaving the backend intact.
+	InvalidateLocal(ctx context.Context, inv Invalidation) error
+}
+
+// BatchStore is implemented by stores that are able to process
+// several entries at once, e.g. in a single round trip.
+type BatchStore interface {
+	// GetMulti returns the entries found for the keys, the keys
+	// without entries are absent from the result.
+	GetMulti(ctx context.Context, keys []string) (map[string]Entry, error)
+	// SetMulti puts the entries by their keys.
+	SetMulti(ctx context.Context, entries map[string]Entry) error
+	// RemoveMulti removes the entries for the keys.
+	RemoveMulti(ctx context.Context, keys []string) error
+}
+
+// CompareAndSwapper is implemented by stores that are able to
+// replace the entry atomically, if it wasn't changed concurrently.
+type CompareAndSwapper interface {
+	// CompareAndSwap saves the entry only if the stored one has the given
+	// ETag, or, if the ETag is empty, only if there is no stored entry.
+	// It reports whether the entry was saved.
+	CompareAndSwap(ctx context.Context, key, etag string, e Entry) (swapped bool, err error)
+}
+
+// Incrementer is implemented by stores that are able to keep atomic
+// counters apart from the entries, so that they neither expire nor are
+// evicted, e.g. the generation counter, see WithGenerations.
+type Incrementer interface {
+	// Increment atomically adds delta to the counter and returns its
+	// new value. A missing counter is zero.
+	Increment(ctx context.Context, key string, delta uint64) (uint64, error)
+}
+
+// Ranger is implemented by stores that are able to iterate over
+// their entries, e.g. to snapshot them.
+type Ranger interface {
+	// Range calls fn for the entries that are not expired, from the ones
+	// that are about to be evicted to the most recently used ones,
+	// until fn returns false.
+	Range(ctx context.Context, fn func(key string, e Entry) bool) error
+}
+
+// HealthChecker is implemented by stores that are able to check
+// the connectivity to their backend, e.g. for readiness probes.
+// Stores that hold resources, such as connections, implement io.Closer.
+type HealthChecker interface {
+	// CheckHealth returns an error, if the backend is unreachable.
+	CheckHealth(ctx context.Context) error
+}
+
 // Entry is a cache entry to store.
 type Entry struct {
-	Value []byte `json:"value"`
-	ETag  string `json:"etag"`
+	Value []byte   `json:"value"`
+	ETag  string   `json:"etag"`
+	Tags  []string `json:"tags,omitempty"`
+	// ExpiresAt is the moment the entry must not be served after,
+	// zero means that the entry doesn't expire by itself.
+	ExpiresAt time.Time `json:"expires_at"`
+	// Cost is the time it took to produce the value, it is used
+	// by the cost-aware eviction of the memory store.
+	Cost time.Duration `json:"cost,omitempty"`
+}
+
+// expired returns true if the entry must not be served at the given moment.
+func (e Entry) expired(now time.Time) bool { return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt) }
+
+// AdaptStore returns the store as StoreV2. Stores that implement
+// StoreV2 themselves are returned as is, others are never
+// considered failing.
+func AdaptStore(s Store) StoreV2 {
+	if v2, ok := s.(StoreV2); ok {
+		return v2
+	}
+	return storeAdapter{Store: s}
+}
+
+type storeAdapter struct{ Store }
+
+func (a storeAdapter) Load(ctx context.Context, key string) (Entry, error) {
+	e, ok := a.Get(ctx, key)
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return e, nil
+}
+
+func (a storeAdapter) Save(ctx context.Context, key string, e Entry) error {
+	a.Set(ctx, key, e)
+	return nil
+}
+
+func (a storeAdapter) Delete(ctx context.Context, key string) error {
+	a.Remove(ctx, key)
+	return nil
+}
+
+func (a storeAdapter) InvalidateTags(ctx context.Context, tags ...string) error {
+	if ti, ok := a.Store.(TagInvalidator); ok {
+		return ti.InvalidateTags(ctx, tags...)
+	}
+	return ErrNotSupported
+}
+
+func (a storeAdapter) RemovePrefix(ctx context.Context, prefix string) error {
+	if pr, ok := a.Store.(PrefixRemover); ok {
+		return pr.RemovePrefix(ctx, prefix)
+	}
+	return ErrNotSupported
+}
+
+func (a storeAdapter) Range(ctx context.Context, fn func(key string, e Entry) bool) error {
+	if r, ok := a.Store.(Ranger); ok {
+		return r.Range(ctx, fn)
+	}
+	return ErrNotSupported
+}
+
+func (a storeAdapter) InvalidateLocal(ctx context.Context, inv Invalidation) error {
+	if li, ok := a.Store.(LocalInvalidator); ok {
+		return li.InvalidateLocal(ctx, inv)
+	}
+	return ErrNotSupported
+}
+
+func (a storeAdapter) Increment(ctx context.Context, key string, delta uint64) (uint64, error) {
+	if inc, ok := a.Store.(Incrementer); ok {
+		return inc.Increment(ctx, key, delta)
+	}
+	return 0, ErrNotSupported
+}
+
+func (a storeAdapter) GetMulti(ctx context.Context, keys []string) (map[string]Entry, error) {
+	if b, ok := a.Store.(BatchStore); ok {
+		return b.GetMulti(ctx, keys)
+	}
+	return nil, ErrNotSupported
+}
+
+func (a storeAdapter) SetMulti(ctx context.Context, entries map[string]Entry) error {
+	if b, ok := a.Store.(BatchStore); ok {
+		return b.SetMulti(ctx, entries)
+	}
+	return ErrNotSupported
+}
+
+func (a storeAdapter) RemoveMulti(ctx context.Context, keys []string) error {
+	if b, ok := a.Store.(BatchStore); ok {
+		return b.RemoveMulti(ctx, keys)
+	}
+	return ErrNotSupported
+}
+
+func (a storeAdapter) CheckHealth(ctx context.Context) error {
+	return checkHealth(ctx, a.Store)
+}
+
+func (a storeAdapter) Close() error {
+	return closeStore(a.Store)
+}
+
+// legacyStore implements Store methods on top of StoreV2,
+// logging the failures and reporting them as misses.
+type legacyStore struct {
+	v2     StoreV2
+	logger *slog.Logger
+}
+
+func (l legacyStore) Get(ctx context.Context, key string) (Entry, bool) {
+	e, err := l.v2.Load(ctx, key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return Entry{}, false
+	case err != nil:
+		l.logger.WarnContext(ctx, "gcache: failed to get from the store", slog.Any(ErrKey, err))
+		return Entry{}, false
+	}
+	return e, true
+}
+
+func (l legacyStore) Set(ctx context.Context, key string, e Entry) {
+	if err := l.v2.Save(ctx, key, e); err != nil {
+		l.logger.WarnContext(ctx, "gcache: failed to set to the store", slog.Any(ErrKey, err))
+	}
+}
+
+func (l legacyStore) Remove(ctx context.Context, key string) {
+	if err := l.v2.Delete(ctx, key); err != nil {
+		l.logger.WarnContext(ctx, "gcache: failed to remove from the store", slog.Any(ErrKey, err))
+	}
 }
 
 // LRUBackend specifies interface to be implemented by hashicorp LRU cache backends.
@@ -24,11 +251,251 @@ type LRUBackend interface {
 	Remove(key string) (present bool)
 }
 
-type lruWrapper struct{ backend LRUBackend }
+// lruPeeker is implemented by all hashicorp LRU cache backends,
+// it is used to iterate over the entries without updating their recency.
+type lruPeeker interface {
+	Peek(key string) (value Entry, ok bool)
+}
+
+// lruInspector is implemented by all hashicorp LRU cache backends,
+// it is used to drop the evicted keys from the tag index and
+// to look up the keys by prefix.
+type lruInspector interface {
+	Contains(key string) bool
+	Len() int
+	Keys() []string
+}
+
+type lruWrapper struct {
+	backend LRUBackend
+	index   tagIndex
+}
 
 // NewLRU wraps hashicorp/golang-lru/v2 cache implementations to be used as interceptor's store.
-func NewLRU(backend LRUBackend) Store { return lruWrapper{backend: backend} }
+// Removal by prefix requires the backend to list its keys, which
+// all hashicorp implementations do.
+func NewLRU(backend LRUBackend) Store { return &lruWrapper{backend: backend} }
+
+// Get returns the value for the given key.
+func (l *lruWrapper) Get(_ context.Context, key string) (e Entry, ok bool) {
+	if e, ok = l.backend.Get(key); !ok {
+		l.index.remove(key)
+		return Entry{}, false
+	}
+
+	if e.expired(time.Now()) {
+		l.backend.Remove(key)
+		l.index.remove(key)
+		return Entry{}, false
+	}
+
+	return e, true
+}
+
+// Set sets the value for the given key.
+func (l *lruWrapper) Set(_ context.Context, key string, e Entry) {
+	if evicted := l.backend.Add(key, e); evicted {
+		l.pruneIndex()
+	}
+	l.index.add(key, e.Tags)
+}
+
+// Remove removes the value for the given key.
+func (l *lruWrapper) Remove(_ context.Context, key string) {
+	l.backend.Remove(key)
+	l.index.remove(key)
+}
+
+// Load returns the value for the given key, or ErrNotFound.
+func (l *lruWrapper) Load(ctx context.Context, key string) (Entry, error) {
+	e, ok := l.Get(ctx, key)
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+	return e, nil
+}
+
+// Save sets the value for the given key.
+func (l *lruWrapper) Save(ctx context.Context, key string, e Entry) error {
+	l.Set(ctx, key, e)
+	return nil
+}
+
+// Delete removes the value for the given key.
+func (l *lruWrapper) Delete(ctx context.Context, key string) error {
+	l.Remove(ctx, key)
+	return nil
+}
 
-func (l lruWrapper) Get(_ context.Context, key string) (e Entry, ok bool) { return l.backend.Get(key) }
-func (l lruWrapper) Set(_ context.Context, key string, e Entry)           { l.backend.Add(key, e) }
-func (l lruWrapper) Remove(_ context.Context, key string)                 { l.backend.Remove(key) }
+// GetMulti returns the entries found for the keys.
+func (l *lruWrapper) GetMulti(ctx context.Context, keys []string) (map[string]Entry, error) {
+	entries := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		if e, ok := l.Get(ctx, key); ok {
+			entries[key] = e
+		}
+	}
+	return entries, nil
+}
+
+// SetMulti puts the entries by their keys.
+func (l *lruWrapper) SetMulti(ctx context.Context, entries map[string]Entry) error {
+	for key, e := range entries {
+		l.Set(ctx, key, e)
+	}
+	return nil
+}
+
+// RemoveMulti removes the entries for the keys.
+func (l *lruWrapper) RemoveMulti(ctx context.Context, keys []string) error {
+	for _, key := range keys {
+		l.Remove(ctx, key)
+	}
+	return nil
+}
+
+// InvalidateTags removes all entries that carry any of the given tags.
+func (l *lruWrapper) InvalidateTags(_ context.Context, tags ...string) error {
+	for _, key := range l.index.take(tags...) {
+		l.backend.Remove(key)
+	}
+	return nil
+}
+
+// RemovePrefix removes all entries whose keys start with the given prefix.
+func (l *lruWrapper) RemovePrefix(_ context.Context, prefix string) error {
+	insp, ok := l.backend.(lruInspector)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	for _, key := range insp.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			l.backend.Remove(key)
+			l.index.remove(key)
+		}
+	}
+
+	return nil
+}
+
+// Range calls fn for the entries from the least recently used to the most
+// recently used one. It requires the backend to list its keys.
+func (l *lruWrapper) Range(_ context.Context, fn func(key string, e Entry) bool) error {
+	insp, ok := l.backend.(lruInspector)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	get := l.backend.Get
+	if p, ok := l.backend.(lruPeeker); ok {
+		get = p.Peek
+	}
+
+	now := time.Now()
+	for _, key := range insp.Keys() {
+		e, ok := get(key)
+		if !ok || e.expired(now) {
+			continue
+		}
+		if !fn(key, e) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// pruneIndex drops the evicted keys from the tag index, once it
+// significantly outgrows the backend.
+func (l *lruWrapper) pruneIndex() {
+	insp, ok := l.backend.(lruInspector)
+	if !ok || l.index.len() <= 2*insp.Len() {
+		return
+	}
+	l.index.prune(insp.Contains)
+}
+
+// loadMulti loads the entries for the keys in a batch,
+// if the store supports it, or one by one otherwise.
+func loadMulti(ctx context.Context, s StoreV2, keys []string) (map[string]Entry, error) {
+	if b, ok := s.(BatchStore); ok {
+		if entries, err := b.GetMulti(ctx, keys); !errors.Is(err, ErrNotSupported) {
+			return entries, err
+		}
+	}
+	return loadEach(ctx, s, keys)
+}
+
+// deleteMulti removes the entries for the keys in a batch,
+// if the store supports it, or one by one otherwise.
+func deleteMulti(ctx context.Context, s StoreV2, keys []string) error {
+	if b, ok := s.(BatchStore); ok {
+		if err := b.RemoveMulti(ctx, keys); !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return deleteEach(ctx, s, keys)
+}
+
+// saveMulti puts the entries in a batch, if the store supports it,
+// or one by one otherwise.
+func saveMulti(ctx context.Context, s StoreV2, entries map[string]Entry) error {
+	if b, ok := s.(BatchStore); ok {
+		if err := b.SetMulti(ctx, entries); !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return saveEach(ctx, s, entries)
+}
+
+// loadEach loads the entries for the keys one by one.
+func loadEach(ctx context.Context, s StoreV2, keys []string) (map[string]Entry, error) {
+	entries := make(map[string]Entry, len(keys))
+	for _, key := range keys {
+		e, err := s.Load(ctx, key)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			continue
+		case err != nil:
+			return nil, err
+		}
+		entries[key] = e
+	}
+	return entries, nil
+}
+
+// saveEach puts the entries one by one.
+func saveEach(ctx context.Context, s StoreV2, entries map[string]Entry) error {
+	var errs []error
+	for key, e := range entries {
+		errs = append(errs, s.Save(ctx, key, e))
+	}
+	return errors.Join(errs...)
+}
+
+// deleteEach removes the entries for the keys one by one.
+func deleteEach(ctx context.Context, s StoreV2, keys []string) error {
+	var errs []error
+	for _, key := range keys {
+		errs = append(errs, s.Delete(ctx, key))
+	}
+	return errors.Join(errs...)
+}
+
+// checkHealth checks the health of the store,
+// returns ErrNotSupported if it isn't HealthChecker.
+func checkHealth(ctx context.Context, s any) error {
+	if hc, ok := s.(HealthChecker); ok {
+		return hc.CheckHealth(ctx)
+	}
+	return ErrNotSupported
+}
+
+// closeStore closes the store, if it is io.Closer.
+func closeStore(s any) error {
+	if c, ok := s.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package gcache
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Tagger returns the tags to attach to the cached response of the given method.
+type Tagger func(fullMethod string, req, resp any) []string
+
+type tagsCtxKey struct{}
+
+type tagCollector struct {
+	mu   sync.Mutex
+	tags []string
+}
+
+// withTagCollector puts a collector to the context, so that the handler
+// could attach tags to its response via Tag.
+func withTagCollector(ctx context.Context) (context.Context, *tagCollector) {
+	tc := &tagCollector{}
+	return context.WithValue(ctx, tagsCtxKey{}, tc), tc
+}
+
+func (tc *tagCollector) add(tags ...string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.tags = append(tc.tags, tags...)
+}
+
+func (tc *tagCollector) collected() []string {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	return slices.Clone(tc.tags)
+}
+
+// tagIndex keeps track of the keys that carry tags for stores that
+// are not able to look up entries by tag natively.
+type tagIndex struct {
+	mu   sync.Mutex
+	tags map[string]map[string]struct{} // tag -> keys
+	keys map[string][]string            // key -> tags
+}
+
+// add replaces the tags of the key with the given ones.
+func (ti *tagIndex) add(key string, tags []string) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	ti.unlink(key)
+
+	if len(tags) == 0 {
+		return
+	}
+
+	if ti.tags == nil {
+		ti.tags = map[string]map[string]struct{}{}
+		ti.keys = map[string][]string{}
+	}
+
+	for _, tag := range tags {
+		if ti.tags[tag] == nil {
+			ti.tags[tag] = map[string]struct{}{}
+		}
+		ti.tags[tag][key] = struct{}{}
+	}
+
+	ti.keys[key] = slices.Clone(tags)
+}
+
+// remove drops the key from the index.
+func (ti *tagIndex) remove(key string) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.unlink(key)
+}
+
+// take drops the keys that carry any of the given tags
+// from the index and returns them.
+func (ti *tagIndex) take(tags ...string) []string {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	var keys []string
+	for _, tag := range tags {
+		for key := range ti.tags[tag] {
+			keys = append(keys, key)
+			ti.unlink(key)
+		}
+	}
+
+	return keys
+}
+
+// prune drops the keys that are no longer present in the store.
+func (ti *tagIndex) prune(contains func(key string) bool) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	for key := range ti.keys {
+		if !contains(key) {
+			ti.unlink(key)
+		}
+	}
+}
+
+func (ti *tagIndex) len() int {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	return len(ti.keys)
+}
+
+func (ti *tagIndex) unlink(key string) {
+	for _, tag := range ti.keys[key] {
+		delete(ti.tags[tag], key)
+		if len(ti.tags[tag]) == 0 {
+			delete(ti.tags, tag)
+		}
+	}
+	delete(ti.keys, key)
+}
@@ -0,0 +1,219 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// TieredOption is a configuration option for the tiered store.
+type TieredOption func(*tieredStore)
+
+// WithTieredL1TTL sets the TTL of the entries in the first tier.
+func WithTieredL1TTL(ttl time.Duration) TieredOption {
+	return func(t *tieredStore) { t.l1TTL = ttl }
+}
+
+// WithTieredL2TTL sets the TTL of the entries in the second tier.
+func WithTieredL2TTL(ttl time.Duration) TieredOption {
+	return func(t *tieredStore) { t.l2TTL = ttl }
+}
+
+// WithTieredLogger sets the logger.
+func WithTieredLogger(l *slog.Logger) TieredOption {
+	return func(t *tieredStore) { t.logger = l }
+}
+
+type tieredStore struct {
+	l1, l2       StoreV2
+	l1TTL, l2TTL time.Duration
+	logger       *slog.Logger
+	now          func() time.Time
+}
+
+// NewTiered makes a store that looks up the entries in l1 first, then in l2,
+// promoting the entries found in l2 to l1. Writes and invalidations go
+// through both tiers. Usually, l1 is an in-process store, e.g. LRU,
+// and l2 is a shared one, e.g. Redis.
+func NewTiered(l1, l2 Store, opts ...TieredOption) Store {
+	t := &tieredStore{
+		l1:     AdaptStore(l1),
+		l2:     AdaptStore(l2),
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		now:    time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t
+}
+
+// Get returns the value for the given key.
+func (t *tieredStore) Get(ctx context.Context, key string) (Entry, bool) {
+	return legacyStore{v2: t, logger: t.logger}.Get(ctx, key)
+}
+
+// Set sets the value for the given key.
+func (t *tieredStore) Set(ctx context.Context, key string, e Entry) {
+	legacyStore{v2: t, logger: t.logger}.Set(ctx, key, e)
+}
+
+// Remove removes the value for the given key.
+func (t *tieredStore) Remove(ctx context.Context, key string) {
+	legacyStore{v2: t, logger: t.logger}.Remove(ctx, key)
+}
+
+// Load returns the value for the given key from the first tier that has it.
+func (t *tieredStore) Load(ctx context.Context, key string) (Entry, error) {
+	now := t.now()
+
+	e, l1Err := t.l1.Load(ctx, key)
+	switch {
+	case l1Err == nil && !e.expired(now):
+		return e, nil
+	case l1Err == nil:
+		l1Err = ErrNotFound
+	case !errors.Is(l1Err, ErrNotFound):
+		l1Err = fmt.Errorf("load from l1: %w", l1Err)
+	}
+
+	e, err := t.l2.Load(ctx, key)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return Entry{}, joinNotFound(l1Err)
+	case err != nil && errors.Is(l1Err, ErrNotFound):
+		return Entry{}, fmt.Errorf("load from l2: %w", err)
+	case err != nil:
+		return Entry{}, errors.Join(l1Err, fmt.Errorf("load from l2: %w", err))
+	case e.expired(now):
+		return Entry{}, joinNotFound(l1Err)
+	}
+
+	if err = t.l1.Save(ctx, key, t.withTTL(e, t.l1TTL, now)); err != nil {
+		t.logger.WarnContext(ctx, "gcache: failed to promote entry to l1", slog.Any(ErrKey, err))
+	}
+
+	return e, nil
+}
+
+// Save sets the value for the given key in both tiers.
+func (t *tieredStore) Save(ctx context.Context, key string, e Entry) error {
+	now := t.now()
+
+	var errs []error
+	if err := t.l2.Save(ctx, key, t.withTTL(e, t.l2TTL, now)); err != nil {
+		errs = append(errs, fmt.Errorf("save to l2: %w", err))
+	}
+
+	if err := t.l1.Save(ctx, key, t.withTTL(e, t.l1TTL, now)); err != nil {
+		errs = append(errs, fmt.Errorf("save to l1: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Delete removes the value for the given key from both tiers.
+func (t *tieredStore) Delete(ctx context.Context, key string) error {
+	return t.both(func(s StoreV2) error { return s.Delete(ctx, key) })
+}
+
+// InvalidateTags removes all entries that carry any of the given tags from both tiers.
+func (t *tieredStore) InvalidateTags(ctx context.Context, tags ...string) error {
+	return t.both(func(s StoreV2) error {
+		if ti, ok := s.(TagInvalidator); ok {
+			return ti.InvalidateTags(ctx, tags...)
+		}
+		return ErrNotSupported
+	})
+}
+
+// RemovePrefix removes all entries whose keys start with the given prefix from both tiers.
+func (t *tieredStore) RemovePrefix(ctx context.Context, prefix string) error {
+	return t.both(func(s StoreV2) error {
+		if pr, ok := s.(PrefixRemover); ok {
+			return pr.RemovePrefix(ctx, prefix)
+		}
+		return ErrNotSupported
+	})
+}
+
+// InvalidateLocal removes the entries from the first tier, and the local
+// copies of the entries from the second one, if it keeps them apart.
+func (t *tieredStore) InvalidateLocal(ctx context.Context, inv Invalidation) error {
+	return t.both(func(s StoreV2) error { return invalidateLocal(ctx, s, inv) })
+}
+
+// Increment atomically adds delta to the counter of the second tier,
+// as the first one is usually local to the process.
+func (t *tieredStore) Increment(ctx context.Context, key string, delta uint64) (uint64, error) {
+	if inc, ok := t.l2.(Incrementer); ok {
+		return inc.Increment(ctx, key, delta)
+	}
+	return 0, ErrNotSupported
+}
+
+// CheckHealth checks the health of the tiers that are HealthChecker.
+// Returns ErrNotSupported, if none of them is.
+func (t *tieredStore) CheckHealth(ctx context.Context) error {
+	l2Err, l1Err := checkHealth(ctx, t.l2), checkHealth(ctx, t.l1)
+	if errors.Is(l2Err, ErrNotSupported) && errors.Is(l1Err, ErrNotSupported) {
+		return ErrNotSupported
+	}
+
+	var errs []error
+	if l2Err != nil && !errors.Is(l2Err, ErrNotSupported) {
+		errs = append(errs, fmt.Errorf("l2: %w", l2Err))
+	}
+	if l1Err != nil && !errors.Is(l1Err, ErrNotSupported) {
+		errs = append(errs, fmt.Errorf("l1: %w", l1Err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close closes the tiers that are io.Closer.
+func (t *tieredStore) Close() error {
+	return t.both(func(s StoreV2) error { return closeStore(s) })
+}
+
+// both applies fn to the second tier, then to the first one, even if
+// the second tier fails, so that the first one isn't left behind.
+func (t *tieredStore) both(fn func(StoreV2) error) error {
+	var errs []error
+
+	if err := fn(t.l2); err != nil {
+		errs = append(errs, fmt.Errorf("l2: %w", err))
+	}
+
+	if err := fn(t.l1); err != nil {
+		errs = append(errs, fmt.Errorf("l1: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// withTTL returns the entry that expires not later than after the ttl.
+func (t *tieredStore) withTTL(e Entry, ttl time.Duration, now time.Time) Entry {
+	if ttl <= 0 {
+		return e
+	}
+
+	if exp := now.Add(ttl); e.ExpiresAt.IsZero() || exp.Before(e.ExpiresAt) {
+		e.ExpiresAt = exp
+	}
+
+	return e
+}
+
+// joinNotFound returns ErrNotFound, keeping the failure of the first tier, if any.
+func joinNotFound(l1Err error) error {
+	if errors.Is(l1Err, ErrNotFound) {
+		return ErrNotFound
+	}
+	return errors.Join(ErrNotFound, l1Err)
+}
@@ -0,0 +1,125 @@
+package gcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTieredStore(t *testing.T) {
+	newLRU := func() Store {
+		l, err := lru.New[string, Entry](10)
+		require.NoError(t, err)
+		return NewLRU(l)
+	}
+
+	ctx := context.Background()
+
+	t.Run("promotes l2 hits", func(t *testing.T) {
+		l1, l2 := newLRU(), newLRU()
+		store := NewTiered(l1, l2, WithTieredL1TTL(time.Minute))
+
+		l2.Set(ctx, "key", Entry{Value: []byte("value")})
+
+		e, ok := store.Get(ctx, "key")
+		require.True(t, ok)
+		assert.Equal(t, []byte("value"), e.Value)
+
+		promoted, ok := l1.Get(ctx, "key")
+		require.True(t, ok)
+		assert.Equal(t, []byte("value"), promoted.Value)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), promoted.ExpiresAt, time.Second)
+	})
+
+	t.Run("writes and invalidates through both tiers", func(t *testing.T) {
+		l1, l2 := newLRU(), newLRU()
+		store := NewTiered(l1, l2, WithTieredL1TTL(time.Minute), WithTieredL2TTL(time.Hour))
+
+		store.Set(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("value"), Tags: []string{"order:1"}})
+
+		e, ok := l1.Get(ctx, "/svc.Orders/Get{01}")
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), e.ExpiresAt, time.Second)
+
+		e, ok = l2.Get(ctx, "/svc.Orders/Get{01}")
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), e.ExpiresAt, time.Second)
+
+		require.NoError(t, store.(TagInvalidator).InvalidateTags(ctx, "order:1"))
+		_, ok = l1.Get(ctx, "/svc.Orders/Get{01}")
+		assert.False(t, ok)
+		_, ok = l2.Get(ctx, "/svc.Orders/Get{01}")
+		assert.False(t, ok)
+
+		store.Set(ctx, "/svc.Orders/Get{01}", Entry{Value: []byte("value")})
+		require.NoError(t, store.(PrefixRemover).RemovePrefix(ctx, "/svc.Orders/"))
+		_, ok = l1.Get(ctx, "/svc.Orders/Get{01}")
+		assert.False(t, ok)
+		_, ok = l2.Get(ctx, "/svc.Orders/Get{01}")
+		assert.False(t, ok)
+
+		store.Set(ctx, "key", Entry{Value: []byte("value")})
+		store.Remove(ctx, "key")
+		_, ok = l1.Get(ctx, "key")
+		assert.False(t, ok)
+		_, ok = l2.Get(ctx, "key")
+		assert.False(t, ok)
+	})
+
+	t.Run("expired entries are not served", func(t *testing.T) {
+		l1, l2 := newLRU(), newLRU()
+		store := NewTiered(l1, l2, WithTieredL1TTL(time.Minute), WithTieredL2TTL(time.Hour)).(*tieredStore)
+
+		store.Set(ctx, "key", Entry{Value: []byte("value")})
+
+		store.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+		_, err := store.Load(ctx, "key")
+		require.NoError(t, err, "must be served from l2")
+
+		store.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+		_, err = store.Load(ctx, "key")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("l2 failure", func(t *testing.T) {
+		errL2 := errors.New("l2 is down")
+		store := NewTiered(newLRU(), storeV1{failingStore{err: errL2}}).(*tieredStore)
+
+		_, err := store.Load(ctx, "key")
+		assert.ErrorIs(t, err, errL2)
+		assert.NotErrorIs(t, err, ErrNotFound)
+
+		assert.ErrorIs(t, store.Save(ctx, "key", Entry{}), errL2)
+		_, err = store.l1.Load(ctx, "key")
+		assert.NoError(t, err, "l1 must be written regardless")
+	})
+}
+
+func TestTieredStore_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	errDown := errors.New("store is down")
+
+	assert.ErrorIs(t, NewTiered(NewMemory(1<<20), NewMemory(1<<20)).(HealthChecker).CheckHealth(ctx), ErrNotSupported)
+
+	l2 := &healthStore{Store: NewMemory(1 << 20)}
+	store := NewTiered(NewMemory(1<<20), l2).(*tieredStore)
+	require.NoError(t, store.CheckHealth(ctx), "tiers without health checks must be skipped")
+
+	l2.err = errDown
+	assert.ErrorIs(t, store.CheckHealth(ctx), errDown)
+
+	require.NoError(t, store.Close())
+	assert.True(t, l2.closed)
+}
+
+// storeV1 exposes StoreV2 as both Store and StoreV2.
+type storeV1 struct{ StoreV2 }
+
+func (s storeV1) Get(context.Context, string) (Entry, bool) { panic("must not be called") }
+func (s storeV1) Set(context.Context, string, Entry)        { panic("must not be called") }
+func (s storeV1) Remove(context.Context, string)            { panic("must not be called") }
@@ -0,0 +1,384 @@
+package gcache
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TinyLFUOption is a configuration option for the TinyLFU store.
+type TinyLFUOption func(*tinyLFUStore)
+
+// WithTinyLFUObserver sets the observer to report evictions to,
+// including the new entries that were not admitted to the store.
+func WithTinyLFUObserver(o Observer) TinyLFUOption {
+	return func(t *tinyLFUStore) { t.observer = o }
+}
+
+// withTinyLFUSeed sets the seed of the frequency sketch hashes, so that
+// the admission decisions are reproducible.
+func withTinyLFUSeed(seed uint64) TinyLFUOption {
+	return func(t *tinyLFUStore) { t.sketch.seed = seed }
+}
+
+// tinyLFUStore is a W-TinyLFU store: new entries are put into a small
+// LRU window, and the entries evicted from the window are admitted to
+// the main segmented LRU only if they are used more frequently than
+// the entry that would be evicted from the main segment instead.
+// The frequencies are estimated with a count-min sketch.
+type tinyLFUStore struct {
+	mu        sync.Mutex
+	items     map[string]*list.Element
+	window    *list.List
+	probation *list.List
+	protected *list.List
+	sketch    *cmSketch
+
+	windowCap    int
+	mainCap      int
+	protectedCap int
+
+	observer Observer
+	index    tagIndex
+	now      func() time.Time
+}
+
+type tinyLFUItem struct {
+	key     string
+	entry   Entry
+	segment *list.List
+}
+
+// NewTinyLFU makes a store of the given number of entries with the
+// W-TinyLFU eviction policy. 1% of the store is the window for the new
+// entries, the rest of it is admitted only to the entries that are
+// used more frequently than the ones they would evict, thus one-off
+// scans don't flush the frequently used entries.
+func NewTinyLFU(size int, opts ...TinyLFUOption) (Store, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("make TinyLFU cache: size must be positive, got %d", size)
+	}
+
+	t := &tinyLFUStore{
+		items:     map[string]*list.Element{},
+		window:    list.New(),
+		probation: list.New(),
+		protected: list.New(),
+		sketch:    newCMSketch(size),
+		windowCap: max(size/100, 1),
+		observer:  nopObserver{},
+		now:       time.Now,
+	}
+	t.mainCap = size - t.windowCap
+	t.protectedCap = t.mainCap * 8 / 10
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	return t, nil
+}
+
+// Get returns the value for the given key.
+func (t *tinyLFUStore) Get(ctx context.Context, key string) (Entry, bool) {
+	return legacyStore{v2: t, logger: discardLogger}.Get(ctx, key)
+}
+
+// Set sets the value for the given key.
+func (t *tinyLFUStore) Set(ctx context.Context, key string, e Entry) {
+	legacyStore{v2: t, logger: discardLogger}.Set(ctx, key, e)
+}
+
+// Remove removes the value for the given key.
+func (t *tinyLFUStore) Remove(ctx context.Context, key string) {
+	legacyStore{v2: t, logger: discardLogger}.Remove(ctx, key)
+}
+
+// Load returns the value for the given key, or ErrNotFound.
+func (t *tinyLFUStore) Load(_ context.Context, key string) (Entry, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	el, ok := t.items[key]
+	if !ok {
+		return Entry{}, ErrNotFound
+	}
+
+	it := el.Value.(*tinyLFUItem)
+	if it.entry.expired(t.now()) {
+		t.remove(el)
+		return Entry{}, ErrNotFound
+	}
+
+	t.sketch.increment(key)
+	t.touch(el)
+
+	return it.entry, nil
+}
+
+// Save sets the value for the given key, evicting other entries
+// if the store is full.
+func (t *tinyLFUStore) Save(ctx context.Context, key string, e Entry) error {
+	t.mu.Lock()
+
+	t.sketch.increment(key)
+
+	if el, ok := t.items[key]; ok {
+		it := el.Value.(*tinyLFUItem)
+		it.entry = e
+		t.index.add(key, e.Tags)
+		t.touch(el)
+		t.mu.Unlock()
+		return nil
+	}
+
+	t.items[key] = t.window.PushFront(&tinyLFUItem{key: key, entry: e, segment: t.window})
+	t.index.add(key, e.Tags)
+
+	var evicted *tinyLFUItem
+	if t.window.Len() > t.windowCap {
+		evicted = t.admit(t.window.Back())
+	}
+
+	t.mu.Unlock()
+
+	if evicted != nil {
+		t.observer.Observe(ctx, Event{Kind: EventEvict, Key: evicted.key, Size: entrySize(evicted.key, evicted.entry)})
+	}
+
+	return nil
+}
+
+// Delete removes the value for the given key.
+func (t *tinyLFUStore) Delete(_ context.Context, key string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if el, ok := t.items[key]; ok {
+		t.remove(el)
+	}
+
+	return nil
+}
+
+// InvalidateTags removes all entries that carry any of the given tags.
+func (t *tinyLFUStore) InvalidateTags(_ context.Context, tags ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range t.index.take(tags...) {
+		if el, ok := t.items[key]; ok {
+			t.remove(el)
+		}
+	}
+
+	return nil
+}
+
+// RemovePrefix removes all entries whose keys start with the given prefix.
+func (t *tinyLFUStore) RemovePrefix(_ context.Context, prefix string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, el := range t.items {
+		if strings.HasPrefix(key, prefix) {
+			t.remove(el)
+		}
+	}
+
+	return nil
+}
+
+// Range calls fn for the entries from the probation segment, which are
+// evicted first, to the protected segment and the window.
+func (t *tinyLFUStore) Range(_ context.Context, fn func(key string, e Entry) bool) error {
+	t.mu.Lock()
+	// items are copied, as Save updates their entries in place
+	items := make([]tinyLFUItem, 0, len(t.items))
+	for _, segment := range []*list.List{t.probation, t.protected, t.window} {
+		for el := segment.Back(); el != nil; el = el.Prev() {
+			items = append(items, *el.Value.(*tinyLFUItem))
+		}
+	}
+	now := t.now()
+	t.mu.Unlock()
+
+	for _, it := range items {
+		if it.entry.expired(now) {
+			continue
+		}
+		if !fn(it.key, it.entry) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// admit moves the candidate evicted from the window to the main segment,
+// if it's used more frequently than the victim of the main segment, and
+// returns the item that was evicted from the store, if any.
+// Must be called under the lock.
+func (t *tinyLFUStore) admit(candidate *list.Element) (evicted *tinyLFUItem) {
+	it := candidate.Value.(*tinyLFUItem)
+	t.window.Remove(candidate)
+
+	if t.probation.Len()+t.protected.Len() >= t.mainCap {
+		victim := t.probation.Back()
+		if victim == nil {
+			victim = t.protected.Back()
+		}
+
+		if victim == nil || t.sketch.estimate(it.key) <= t.sketch.estimate(victim.Value.(*tinyLFUItem).key) {
+			delete(t.items, it.key)
+			t.index.remove(it.key)
+			return it
+		}
+
+		evicted = victim.Value.(*tinyLFUItem)
+		t.remove(victim)
+	}
+
+	t.items[it.key] = t.moveTo(it, t.probation)
+	return evicted
+}
+
+// touch moves the accessed entry to the front of its segment, promoting
+// it from the probation to the protected segment.
+// Must be called under the lock.
+func (t *tinyLFUStore) touch(el *list.Element) {
+	it := el.Value.(*tinyLFUItem)
+	if it.segment != t.probation {
+		it.segment.MoveToFront(el)
+		return
+	}
+
+	t.probation.Remove(el)
+	t.items[it.key] = t.moveTo(it, t.protected)
+
+	if t.protected.Len() > t.protectedCap {
+		demoted := t.protected.Back()
+		t.protected.Remove(demoted)
+		d := demoted.Value.(*tinyLFUItem)
+		t.items[d.key] = t.moveTo(d, t.probation)
+	}
+}
+
+// moveTo puts the item to the front of the given segment.
+func (t *tinyLFUStore) moveTo(it *tinyLFUItem, segment *list.List) *list.Element {
+	it.segment = segment
+	return segment.PushFront(it)
+}
+
+// remove drops the entry from the store, must be called under the lock.
+func (t *tinyLFUStore) remove(el *list.Element) {
+	it := el.Value.(*tinyLFUItem)
+	it.segment.Remove(el)
+	delete(t.items, it.key)
+	t.index.remove(it.key)
+}
+
+// cmDepth is the number of rows in the count-min sketch.
+const cmDepth = 4
+
+// cmMaxCount is the value the counters saturate at.
+const cmMaxCount = 15
+
+// cmRowSeeds are the odd multipliers that derive the independent
+// row indexes from the hash of the key.
+var cmRowSeeds = [cmDepth]uint64{0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0xd6e8feb86659fd93}
+
+// cmSketch is a count-min sketch with 4-bit-like saturating counters,
+// which are halved once the number of increments reaches the sample
+// size, so that the estimates age and follow the changes of the workload.
+// Counters are updated conservatively: only the smallest ones are
+// incremented, which keeps the estimates of the rare keys low.
+type cmSketch struct {
+	seed       uint64
+	rows       [cmDepth][]uint8
+	shift      uint // 64 - log2(width)
+	additions  int
+	sampleSize int
+}
+
+func newCMSketch(size int) *cmSketch {
+	// a few counters per entry keep the collisions rare
+	s := &cmSketch{seed: rand.Uint64(), shift: 64 - 4, sampleSize: 10 * size}
+	for width := 16; width < 8*size; width <<= 1 {
+		s.shift--
+	}
+
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, 1<<(64-s.shift))
+	}
+
+	return s
+}
+
+func (s *cmSketch) increment(key string) {
+	counters := s.counters(key)
+
+	est := uint8(cmMaxCount)
+	for _, c := range counters {
+		est = min(est, *c)
+	}
+
+	if est < cmMaxCount {
+		for _, c := range counters {
+			if *c == est {
+				*c++
+			}
+		}
+	}
+
+	if s.additions++; s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+func (s *cmSketch) estimate(key string) uint8 {
+	est := uint8(cmMaxCount)
+	for _, c := range s.counters(key) {
+		est = min(est, *c)
+	}
+	return est
+}
+
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
+
+// counters returns the counters of the key, one per row.
+func (s *cmSketch) counters(key string) (counters [cmDepth]*uint8) {
+	h := s.hash(key)
+	for i := range s.rows {
+		counters[i] = &s.rows[i][(h*cmRowSeeds[i])>>s.shift]
+	}
+	return counters
+}
+
+// hash returns the seeded FNV-1a hash of the key, finalized with
+// the splitmix64 mixer to spread the low-entropy bits.
+func (s *cmSketch) hash(key string) uint64 {
+	h := 14695981039346656037 ^ s.seed
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
@@ -0,0 +1,348 @@
+package gcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// WarmerOption is a configuration option for the Warmer.
+type WarmerOption func(*Warmer)
+
+// WithWarmerConcurrency sets the number of requests that are run
+// concurrently, 4 by default.
+func WithWarmerConcurrency(n int) WarmerOption {
+	return func(w *Warmer) { w.concurrency = max(n, 1) }
+}
+
+// WithWarmerProgress sets the function that is called after every
+// request is run, e.g. to log the progress.
+// It is called sequentially, but from different goroutines.
+func WithWarmerProgress(fn func(WarmProgress)) WarmerOption {
+	return func(w *Warmer) { w.progress = fn }
+}
+
+// WithWarmerBatchSize sets the number of the warmed entries that are
+// saved to the store at once, 100 by default. Stores that implement
+// BatchStore save them in a single round trip.
+func WithWarmerBatchSize(n int) WarmerOption {
+	return func(w *Warmer) { w.batchSize = max(n, 1) }
+}
+
+// WithWarmerLogger sets the logger.
+func WithWarmerLogger(l *slog.Logger) WarmerOption {
+	return func(w *Warmer) { w.logger = l }
+}
+
+// WarmProgress describes the progress of the warming,
+// after the request to Method is run.
+type WarmProgress struct {
+	Method string
+	Err    error // error of the request, if it failed
+	Done   int   // number of the requests that were run
+	Failed int   // number of the requests that failed
+}
+
+// WarmRequest is a line of the warming input.
+type WarmRequest struct {
+	// Method is the full method name, e.g. "/pkg.Service/Method".
+	Method string `json:"method"`
+	// Request is the request message in protojson form.
+	Request json.RawMessage `json:"request"`
+}
+
+// Warmer preloads the server-side cache by running the recorded
+// requests through the interceptor and the registered services,
+// e.g. before the replica reports its readiness.
+// Warmer implements grpc.ServiceRegistrar, so that the services
+// are registered to it the same way they are registered to the server.
+type Warmer struct {
+	icptr       *Interceptor
+	services    map[string]warmService // service name -> service
+	concurrency int
+	batchSize   int
+	progress    func(WarmProgress)
+	logger      *slog.Logger
+}
+
+type warmService struct {
+	impl    any
+	methods map[string]grpc.MethodDesc
+}
+
+// NewWarmer makes a new Warmer that runs the requests
+// through the server interceptor of icptr.
+func NewWarmer(icptr *Interceptor, opts ...WarmerOption) *Warmer {
+	w := &Warmer{
+		icptr:       icptr,
+		services:    map[string]warmService{},
+		concurrency: 4,
+		batchSize:   100,
+		progress:    func(WarmProgress) {},
+		logger:      discardLogger,
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// RegisterService registers the service implementation to run the requests to.
+// Only unary methods are registered.
+func (w *Warmer) RegisterService(desc *grpc.ServiceDesc, impl any) {
+	svc := warmService{impl: impl, methods: map[string]grpc.MethodDesc{}}
+	for _, md := range desc.Methods {
+		svc.methods[md.MethodName] = md
+	}
+	w.services[desc.ServiceName] = svc
+}
+
+// Warm reads the requests from r, one JSON-encoded WarmRequest per line,
+// and runs them through the interceptor. Request messages are resolved
+// via the global proto registry. Failed requests are reported to the
+// progress function and logged, but don't stop the warming. Responses
+// are saved to the store in batches, see WithWarmerBatchSize.
+// Warm stops, once the context is done, e.g. on its deadline, and returns
+// the final progress along with the error of reading the input or the
+// error of the context.
+func (w *Warmer) Warm(ctx context.Context, r io.Reader) (WarmProgress, error) {
+	var (
+		mu   sync.Mutex
+		last WarmProgress
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, w.concurrency)
+	)
+
+	report := func(method string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		last.Method, last.Err = method, err
+		last.Done++
+		if err != nil {
+			last.Failed++
+			w.logger.WarnContext(ctx, "gcache: failed to warm the cache",
+				slog.String("method", method), slog.Any(ErrKey, err))
+		}
+		w.progress(last)
+	}
+
+	batch := w.icptr.newSaveBatch()
+	defer w.icptr.dropSaveBatch(batch)
+	ctx = withSaveBatch(ctx, batch)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 16<<20)
+
+	var err error
+	for err == nil && sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+
+		var req WarmRequest
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			report("", fmt.Errorf("decode line: %w", err))
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			report(req.Method, w.run(ctx, req))
+			w.flush(ctx, batch, w.batchSize)
+		}()
+	}
+
+	wg.Wait()
+
+	// the responses are already produced, so that they are saved regardless of the context
+	w.flush(context.WithoutCancel(ctx), batch, 1)
+
+	if err == nil {
+		err = errors.Join(sc.Err(), ctx.Err())
+	}
+
+	if err != nil {
+		return last, fmt.Errorf("warm cache: %w", err)
+	}
+
+	return last, nil
+}
+
+// run runs the request through the interceptor and the service handler.
+func (w *Warmer) run(ctx context.Context, req WarmRequest) error {
+	svcName, methodName, ok := strings.Cut(strings.TrimPrefix(req.Method, "/"), "/")
+	if !ok {
+		return fmt.Errorf("invalid method name %q", req.Method)
+	}
+
+	svc, ok := w.services[svcName]
+	if !ok {
+		return fmt.Errorf("service %q is not registered", svcName)
+	}
+
+	md, ok := svc.methods[methodName]
+	if !ok {
+		return fmt.Errorf("method %q not found in service %q", methodName, svcName)
+	}
+
+	mt, err := requestType(req.Method)
+	if err != nil {
+		return fmt.Errorf("resolve request type: %w", err)
+	}
+
+	msg := mt.New().Interface()
+	if len(req.Request) > 0 {
+		if err = protojson.Unmarshal(req.Request, msg); err != nil {
+			return fmt.Errorf("decode request: %w", err)
+		}
+	}
+
+	dec := func(in any) error {
+		m, ok := in.(proto.Message)
+		if !ok {
+			return fmt.Errorf("request %T is not a proto message", in)
+		}
+		proto.Merge(m, msg)
+		return nil
+	}
+
+	if _, err = md.Handler(svc.impl, ctx, dec, w.icptr.UnaryServerInterceptor()); err != nil {
+		return fmt.Errorf("call %s: %w", req.Method, err)
+	}
+
+	return nil
+}
+
+// flush saves the entries of the batch to the store at once,
+// if there are at least n of them.
+func (w *Warmer) flush(ctx context.Context, batch *saveBatch, n int) {
+	batch.inflight.Lock()
+	defer batch.inflight.Unlock()
+
+	entries := batch.take(n)
+	if len(entries) == 0 {
+		return
+	}
+
+	if err := saveMulti(ctx, w.icptr.store, entries); err != nil {
+		_ = w.icptr.storeFailed(ctx, "", "", "save", err)
+	}
+}
+
+type saveBatchCtxKey struct{}
+
+// saveBatch collects the entries, saved by the server path of the
+// interceptor, instead of writing them to the store one by one.
+type saveBatch struct {
+	icptr *Interceptor // owner of the batch
+
+	mu      sync.Mutex
+	entries map[string]Entry
+
+	inflight sync.Mutex // held while the taken entries are saved
+}
+
+// newSaveBatch makes a batch, tracked by the interceptor, so that
+// the invalidations drop the collected entries they describe.
+func (c *Interceptor) newSaveBatch() *saveBatch {
+	b := &saveBatch{icptr: c, entries: map[string]Entry{}}
+
+	c.batchesMu.Lock()
+	defer c.batchesMu.Unlock()
+
+	if c.batches == nil {
+		c.batches = map[*saveBatch]struct{}{}
+	}
+	c.batches[b] = struct{}{}
+
+	return b
+}
+
+// dropSaveBatch stops tracking the batch.
+func (c *Interceptor) dropSaveBatch(b *saveBatch) {
+	c.batchesMu.Lock()
+	defer c.batchesMu.Unlock()
+	delete(c.batches, b)
+}
+
+// discardBatched drops the collected entries, described by the invalidation,
+// from the batches of the interceptor, see writeBehind.discard.
+func (c *Interceptor) discardBatched(inv Invalidation) {
+	c.batchesMu.Lock()
+	defer c.batchesMu.Unlock()
+
+	for b := range c.batches {
+		b.discard(inv)
+	}
+}
+
+// withSaveBatch puts the batch to the context, so that the server path
+// of its interceptor adds the entries to it, instead of saving them.
+func withSaveBatch(ctx context.Context, b *saveBatch) context.Context {
+	return context.WithValue(ctx, saveBatchCtxKey{}, b)
+}
+
+// saveBatchOf returns the batch of the context, if it's collected for c.
+func saveBatchOf(ctx context.Context, c *Interceptor) (*saveBatch, bool) {
+	b, ok := ctx.Value(saveBatchCtxKey{}).(*saveBatch)
+	return b, ok && b.icptr == c
+}
+
+func (b *saveBatch) add(key string, e Entry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.entries[key] = e
+}
+
+// discard drops the entries, described by the invalidation, and waits
+// for the entries being saved, if any, so that the invalidated entries
+// aren't written back after the invalidation.
+func (b *saveBatch) discard(inv Invalidation) {
+	b.mu.Lock()
+	for key, e := range b.entries {
+		if invalidates(inv, key, e) {
+			delete(b.entries, key)
+		}
+	}
+	b.mu.Unlock()
+
+	b.inflight.Lock()
+	b.inflight.Unlock() //nolint:staticcheck // waiting for the entries being saved
+}
+
+// take returns the collected entries and empties the batch,
+// if there are at least n of them, returns nil otherwise.
+func (b *saveBatch) take(n int) map[string]Entry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.entries) < n {
+		return nil
+	}
+
+	entries := b.entries
+	b.entries = map[string]Entry{}
+	return entries
+}
@@ -0,0 +1,164 @@
+package gcache
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cappuccinotm/gcache/internal/tspb"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestWarmer_Warm(t *testing.T) {
+	const method = "/com.github.cappuccinotm.gcache.example.TestService/Test"
+
+	ctx := context.Background()
+	icptr := NewInterceptor()
+
+	var calls atomic.Int32
+	svc := &tspb.MockTestService{
+		TestFunc: func(_ context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+			calls.Add(1)
+			return &tspb.TestResponse{Value: "value of " + in.Key}, nil
+		},
+	}
+
+	var progress []WarmProgress
+	warmer := NewWarmer(icptr, WithWarmerConcurrency(2),
+		WithWarmerProgress(func(p WarmProgress) { progress = append(progress, p) }))
+	tspb.RegisterTestServiceServer(warmer, svc)
+
+	input := strings.Join([]string{
+		`{"method": "` + method + `", "request": {"key": "a"}}`,
+		`{"method": "` + method + `", "request": {"key": "b"}}`,
+		``,
+		`{"method": "` + method + `"}`,
+		`{"method": "/unknown.Service/Method", "request": {}}`,
+		`{"method": "` + method + `", "request": {"unknown": 1}}`,
+		`not a json`,
+	}, "\n")
+
+	res, err := warmer.Warm(ctx, strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, 6, res.Done)
+	assert.Equal(t, 3, res.Failed)
+	assert.Len(t, progress, 6)
+	assert.Equal(t, int32(3), calls.Load())
+
+	for _, key := range []string{"a", "b", ""} {
+		cacheKey, err := icptr.key(method, &tspb.TestRequest{Key: key})
+		require.NoError(t, err)
+
+		e, ok := lookup(t, icptr, cacheKey)
+		require.True(t, ok, key)
+
+		var resp tspb.TestResponse
+		require.NoError(t, icptr.codec.Unmarshal(e.Value, &resp))
+		assert.Equal(t, "value of "+key, resp.Value)
+	}
+
+	t.Run("batches", func(t *testing.T) {
+		l, _ := lru.New[string, Entry](10)
+		store := &batchStore{Store: NewLRU(l)}
+		icptr := NewInterceptor(WithStore(store))
+
+		warmer := NewWarmer(icptr, WithWarmerConcurrency(1), WithWarmerBatchSize(2))
+		tspb.RegisterTestServiceServer(warmer, svc)
+
+		res, err := warmer.Warm(ctx, strings.NewReader(input))
+		require.NoError(t, err)
+		assert.Equal(t, 3, res.Failed)
+
+		require.Len(t, store.saved, 2, "entries must be saved in batches")
+		assert.Len(t, store.saved[0], 2)
+		assert.Len(t, store.saved[1], 1)
+
+		for _, key := range []string{"a", "b", ""} {
+			cacheKey, err := icptr.key(method, &tspb.TestRequest{Key: key})
+			require.NoError(t, err)
+			_, ok := lookup(t, icptr, cacheKey)
+			assert.True(t, ok, key)
+		}
+	})
+
+	t.Run("nested client calls", func(t *testing.T) {
+		addr := tspb.Run(t, tspb.MockTestService{
+			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+				require.NoError(t, grpc.SendHeader(ctx, metadata.Pairs("ETag", "downstream")))
+				return &tspb.TestResponse{Value: "downstream"}, nil
+			},
+		})
+
+		downstream := NewInterceptor()
+		cc, err := grpc.NewClient(addr,
+			grpc.WithUnaryInterceptor(downstream.UnaryClientInterceptor()),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = cc.Close() })
+		cl := tspb.NewTestServiceClient(cc)
+
+		icptr := NewInterceptor()
+		warmer := NewWarmer(icptr)
+		tspb.RegisterTestServiceServer(warmer, &tspb.MockTestService{
+			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+				return cl.Test(ctx, &tspb.TestRequest{Key: "downstream"})
+			},
+		})
+
+		_, err = warmer.Warm(ctx, strings.NewReader(`{"method": "`+method+`", "request": {"key": "a"}}`))
+		require.NoError(t, err)
+
+		downstreamKey, err := downstream.key(method, &tspb.TestRequest{Key: "downstream"})
+		require.NoError(t, err)
+		_, ok := lookup(t, downstream, downstreamKey)
+		assert.True(t, ok, "client entry must be saved to its own store")
+		_, ok = lookup(t, icptr, downstreamKey)
+		assert.False(t, ok, "client entry must not be diverted to the warmer")
+
+		cacheKey, err := icptr.key(method, &tspb.TestRequest{Key: "a"})
+		require.NoError(t, err)
+		_, ok = lookup(t, icptr, cacheKey)
+		assert.True(t, ok)
+	})
+
+	t.Run("invalidated while warming", func(t *testing.T) {
+		icptr := NewInterceptor()
+		warmer := NewWarmer(icptr, WithWarmerConcurrency(1))
+		tspb.RegisterTestServiceServer(warmer, &tspb.MockTestService{
+			TestFunc: func(ctx context.Context, in *tspb.TestRequest) (*tspb.TestResponse, error) {
+				if in.Key == "b" {
+					require.NoError(t, icptr.Invalidate(ctx, method, &tspb.TestRequest{Key: "a"}))
+				}
+				return &tspb.TestResponse{Value: "value of " + in.Key}, nil
+			},
+		})
+
+		_, err := warmer.Warm(ctx, strings.NewReader(strings.Join([]string{
+			`{"method": "` + method + `", "request": {"key": "a"}}`,
+			`{"method": "` + method + `", "request": {"key": "b"}}`,
+		}, "\n")))
+		require.NoError(t, err)
+
+		for key, present := range map[string]bool{"a": false, "b": true} {
+			cacheKey, err := icptr.key(method, &tspb.TestRequest{Key: key})
+			require.NoError(t, err)
+			_, ok := lookup(t, icptr, cacheKey)
+			assert.Equal(t, present, ok, key)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := warmer.Warm(ctx, strings.NewReader(`{"method": "`+method+`", "request": {"key": "c"}}`))
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,190 @@
+package gcache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cappuccinotm/gcache/gcachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// InvalidationServer implements gcachepb.InvalidationServer, it streams
+// the published invalidations to the watching clients.
+// It also implements Bus, so that being set to the server's interceptor
+// via WithBus, it pushes the interceptor's invalidations to the clients.
+type InvalidationServer struct {
+	gcachepb.UnimplementedInvalidationServer
+
+	mu       sync.Mutex
+	watchers map[chan Invalidation]struct{}
+}
+
+// NewInvalidationServer makes a new InvalidationServer.
+func NewInvalidationServer() *InvalidationServer {
+	return &InvalidationServer{watchers: map[chan Invalidation]struct{}{}}
+}
+
+// watcherBufferSize is the number of invalidations that could be queued for
+// the client, the clients that fall further behind are disconnected, so that
+// they flush their caches after reconnecting.
+const watcherBufferSize = 64
+
+// Publish sends the invalidation to all watching clients.
+func (s *InvalidationServer) Publish(_ context.Context, inv Invalidation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for ch := range s.watchers {
+		select {
+		case ch <- inv:
+		default:
+			// the client falls behind, disconnect it
+			delete(s.watchers, ch)
+			close(ch)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe blocks until the context is canceled, as the server
+// doesn't receive invalidations from its clients.
+func (s *InvalidationServer) Subscribe(ctx context.Context, _ func(context.Context, Invalidation)) error {
+	<-ctx.Done()
+	return nil
+}
+
+// Watch streams the invalidations until the client cancels the call.
+func (s *InvalidationServer) Watch(_ *gcachepb.WatchRequest, stream gcachepb.Invalidation_WatchServer) error {
+	ch := make(chan Invalidation, watcherBufferSize)
+
+	s.mu.Lock()
+	s.watchers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.watchers[ch]; ok {
+			delete(s.watchers, ch)
+			close(ch)
+		}
+	}()
+
+	// let the client know that the subscription is established
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return fmt.Errorf("send header: %w", err)
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case inv, ok := <-ch:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "gcache: client falls behind the invalidations")
+			}
+
+			prefixes := inv.Prefixes
+			if inv.Generation > 0 {
+				// the server purged its cache, keys of all methods start with the slash
+				prefixes = append(prefixes, "/")
+			}
+
+			err := stream.Send(&gcachepb.WatchResponse{Keys: inv.Keys, Prefixes: prefixes, Tags: inv.Tags})
+			if err != nil {
+				return fmt.Errorf("send invalidation: %w", err)
+			}
+		}
+	}
+}
+
+// Watch subscribes to the invalidations, streamed by the server's
+// InvalidationServer over the given connection, and removes the
+// matching entries from the store in background.
+// Interceptor must be closed to stop the subscription.
+// Once it's closed, Watch returns the Unavailable status.
+func (c *Interceptor) Watch(cc grpc.ClientConnInterface) error {
+	c.stopMu.Lock()
+	defer c.stopMu.Unlock()
+
+	if c.ctx.Err() != nil {
+		return status.Error(codes.Unavailable, "gcache: interceptor is closed")
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.watch(c.ctx, gcachepb.NewInvalidationClient(cc))
+	}()
+
+	return nil
+}
+
+// watch receives the invalidations from the server and applies them to the
+// store, until the context is canceled. When the stream is interrupted,
+// it reconnects and flushes the store, as some invalidations might be missed.
+func (c *Interceptor) watch(ctx context.Context, client gcachepb.InvalidationClient) {
+	backoff := minBackoff
+	interrupted := false
+
+	for {
+		err := c.watchOnce(ctx, client, func() {
+			backoff = minBackoff
+			if interrupted {
+				c.flush(ctx, c.apply)
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		interrupted = true
+		c.logger.WarnContext(ctx, "gcache: invalidation stream is interrupted, reconnecting",
+			slog.Duration("backoff", backoff), slog.Any(ErrKey, err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// watchOnce receives the invalidations until the stream is interrupted,
+// connected is called once the stream is established.
+func (c *Interceptor) watchOnce(ctx context.Context, client gcachepb.InvalidationClient, connected func()) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &gcachepb.WatchRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		return fmt.Errorf("call watch: %w", err)
+	}
+
+	if _, err = stream.Header(); err != nil {
+		return fmt.Errorf("receive header: %w", err)
+	}
+
+	connected()
+
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return fmt.Errorf("receive invalidation: %w", err)
+		}
+
+		// the server doesn't touch the client's store, so that it is invalidated as a whole
+		if err = c.apply(ctx, Invalidation{Keys: resp.Keys, Prefixes: resp.Prefixes, Tags: resp.Tags}); err != nil {
+			c.logger.WarnContext(ctx, "gcache: failed to apply streamed invalidation", slog.Any(ErrKey, err))
+		}
+	}
+}
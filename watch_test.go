@@ -0,0 +1,155 @@
+package gcache
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cappuccinotm/gcache/gcachepb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestInvalidationServer_Watch(t *testing.T) {
+	srv := NewInvalidationServer()
+	addr := runInvalidationServer(t, srv)
+
+	cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	icptr := NewInterceptor()
+	require.NoError(t, icptr.Watch(cc))
+	t.Cleanup(func() { require.NoError(t, icptr.Close(ctx)) })
+
+	save(t, icptr, "key", Entry{Value: []byte("value")})
+	save(t, icptr, "tagged", Entry{Value: []byte("value"), Tags: []string{"order:42"}})
+	save(t, icptr, "/svc.Orders/List{01}", Entry{Value: []byte("value")})
+
+	// the client may not have subscribed yet, so we retry
+	assert.Eventually(t, func() bool {
+		require.NoError(t, srv.Publish(ctx, Invalidation{
+			Keys:     []string{"key"},
+			Prefixes: []string{"/svc.Orders/List{"},
+			Tags:     []string{"order:42"},
+		}))
+		_, ok := lookup(t, icptr, "key")
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		_, tagged := lookup(t, icptr, "tagged")
+		_, listed := lookup(t, icptr, "/svc.Orders/List{01}")
+		return !tagged && !listed
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestInterceptor_WatchAfterClose(t *testing.T) {
+	cc, err := grpc.NewClient("localhost:0", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cc.Close() })
+
+	icptr := NewInterceptor()
+	require.NoError(t, icptr.Close(context.Background()))
+	assert.Equal(t, codes.Unavailable, status.Code(icptr.Watch(cc)))
+}
+
+func TestInterceptor_watch_flushesAfterInterruption(t *testing.T) {
+	var calls int32
+	addr := runInvalidationServer(t, &interruptingInvalidationServer{calls: &calls})
+
+	cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	icptr := NewInterceptor()
+	save(t, icptr, "/svc.Orders/Get{01}", Entry{Value: []byte("value")})
+
+	wctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		icptr.watch(wctx, gcachepb.NewInvalidationClient(cc))
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, ok := lookup(t, icptr, "/svc.Orders/Get{01}")
+		return !ok
+	}, 2*time.Second, 10*time.Millisecond)
+	// the server counts the call after the client receives the header
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 2 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+}
+
+func TestInterceptor_watch_flushesStoreWithoutPrefixRemoval(t *testing.T) {
+	var calls int32
+	addr := runInvalidationServer(t, &interruptingInvalidationServer{calls: &calls})
+
+	cc, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	icptr := NewInterceptor(WithStoreV2(struct{ StoreV2 }{AdaptStore(NewMemory(1 << 20))}))
+	save(t, icptr, "/svc.Orders/Get{01}", Entry{Value: []byte("value")})
+
+	wctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		icptr.watch(wctx, gcachepb.NewInvalidationClient(cc))
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, ok := lookup(t, icptr, "/svc.Orders/Get{01}")
+		return !ok
+	}, 2*time.Second, 10*time.Millisecond)
+
+	save(t, icptr, "/svc.Orders/Get{01}", Entry{Value: []byte("fresh")})
+	e, ok := lookup(t, icptr, "/svc.Orders/Get{01}")
+	require.True(t, ok, "must be cached in the next epoch")
+	assert.Equal(t, []byte("fresh"), e.Value)
+
+	cancel()
+	<-done
+}
+
+// interruptingInvalidationServer breaks the first stream right after it's established.
+type interruptingInvalidationServer struct {
+	gcachepb.UnimplementedInvalidationServer
+	calls *int32
+}
+
+func (s *interruptingInvalidationServer) Watch(_ *gcachepb.WatchRequest, stream gcachepb.Invalidation_WatchServer) error {
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	if atomic.AddInt32(s.calls, 1) == 1 {
+		return status.Error(codes.Unavailable, "interrupted")
+	}
+
+	<-stream.Context().Done()
+	return nil
+}
+
+func runInvalidationServer(t *testing.T, srv gcachepb.InvalidationServer) (addr string) {
+	l, err := net.Listen("tcp", ":0") //nolint:gosec // OK for tests
+	require.NoError(t, err)
+
+	s := grpc.NewServer()
+	gcachepb.RegisterInvalidationServer(s, srv)
+
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(s.Stop)
+
+	return l.Addr().String()
+}
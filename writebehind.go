@@ -0,0 +1,256 @@
+package gcache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FullQueuePolicy specifies the behavior of interceptors when
+// the write-behind queue is full.
+type FullQueuePolicy int
+
+const (
+	// DropOnFullQueue makes interceptors drop the write,
+	// leaving the response uncached.
+	DropOnFullQueue FullQueuePolicy = iota
+	// FlushOnFullQueue makes interceptors write the entry to the store
+	// inline, as if there was no write-behind queue.
+	FlushOnFullQueue
+)
+
+// writeBehind is the bounded queue of the writes to the store,
+// performed in the background. Writes to the same key are coalesced,
+// the latest one wins.
+type writeBehind struct {
+	size   int
+	policy FullQueuePolicy
+	wake   chan struct{} // signals the writer about the new writes
+
+	mu      sync.Mutex
+	pending map[string]pendingWrite // by key
+	order   []string                // keys in the order of enqueueing
+	closed  bool                    // writer is draining the queue or stopped
+
+	inflight sync.Mutex // held while the popped write is performed
+}
+
+// pendingWrite is the write waiting in the queue.
+type pendingWrite struct {
+	ctx    context.Context // request context, detached from its cancellation
+	method string
+	op     string // "save" or "delete"
+	entry  Entry
+}
+
+func newWriteBehind(size int, policy FullQueuePolicy) *writeBehind {
+	return &writeBehind{
+		size:    size,
+		policy:  policy,
+		wake:    make(chan struct{}, 1),
+		pending: make(map[string]pendingWrite, size),
+	}
+}
+
+// push enqueues the write, reporting whether it was queued, and, if not,
+// whether the queue is full. The write isn't queued after the writer stops.
+func (q *writeBehind) push(key string, w pendingWrite) (queued, full bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false, false
+	}
+
+	if _, ok := q.pending[key]; ok {
+		q.pending[key] = w
+		return true, false
+	}
+
+	if len(q.order) >= q.size {
+		return false, true
+	}
+
+	q.pending[key] = w
+	q.order = append(q.order, key)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return true, false
+}
+
+// pop dequeues the oldest write, locking inflight until the write
+// is done. Returns false if the queue is empty.
+func (q *writeBehind) pop() (key string, w pendingWrite, ok bool) {
+	q.inflight.Lock()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.order) == 0 {
+		q.inflight.Unlock()
+		return "", pendingWrite{}, false
+	}
+
+	key, q.order = q.order[0], q.order[1:]
+	w = q.pending[key]
+	delete(q.pending, key)
+
+	return key, w, true
+}
+
+// done marks the popped write as performed.
+func (q *writeBehind) done() { q.inflight.Unlock() }
+
+// close makes the queue reject new writes.
+func (q *writeBehind) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+}
+
+// discard drops the pending writes of the entries described by the
+// invalidation, and waits for the write in flight, if any, so that
+// the invalidated entries aren't written back after the invalidation.
+func (q *writeBehind) discard(inv Invalidation) {
+	q.mu.Lock()
+	q.order = slices.DeleteFunc(q.order, func(key string) bool {
+		if !invalidates(inv, key, q.pending[key].entry) {
+			return false
+		}
+		delete(q.pending, key)
+		return true
+	})
+	q.mu.Unlock()
+
+	q.inflight.Lock()
+	q.inflight.Unlock() //nolint:staticcheck // waiting for the write in flight
+}
+
+// invalidates reports whether the invalidation covers the entry.
+func invalidates(inv Invalidation, key string, e Entry) bool {
+	if inv.Generation > 0 || slices.Contains(inv.Keys, key) {
+		return true
+	}
+
+	for _, prefix := range inv.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	for _, tag := range inv.Tags {
+		if slices.Contains(e.Tags, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// save saves the entry to the store, in the background,
+// if the write-behind queue is set.
+func (c *Interceptor) save(ctx context.Context, method, key string, e Entry) {
+	c.write(ctx, key, pendingWrite{ctx: ctx, method: method, op: "save", entry: e})
+}
+
+// remove removes the entry from the store, in the background,
+// if the write-behind queue is set.
+func (c *Interceptor) remove(ctx context.Context, method, key string) {
+	c.write(ctx, key, pendingWrite{ctx: ctx, method: method, op: "delete"})
+}
+
+// write enqueues the write, or performs it inline, if there is
+// no write-behind queue, the interceptor is closed, or the queue
+// is full and the policy says so.
+func (c *Interceptor) write(ctx context.Context, key string, w pendingWrite) {
+	if c.writeBehind == nil {
+		c.perform(key, w)
+		return
+	}
+
+	w.ctx = context.WithoutCancel(ctx)
+	queued, full := c.writeBehind.push(key, w)
+	if queued {
+		return
+	}
+
+	if full && c.writeBehind.policy == DropOnFullQueue {
+		c.logger.WarnContext(ctx, "gcache: write-behind queue is full, dropping the write",
+			slog.String("method", w.method), slog.String("op", w.op))
+		c.observer.Observe(ctx, Event{Kind: EventWriteDropped, Method: w.method, Key: key, Op: w.op})
+		return
+	}
+
+	c.perform(key, w)
+}
+
+// perform writes the entry to the store.
+func (c *Interceptor) perform(key string, w pendingWrite) {
+	var err error
+	switch w.op {
+	case "save":
+		err = c.store.Save(w.ctx, key, w.entry)
+	case "delete":
+		err = c.store.Delete(w.ctx, key)
+	}
+
+	if err != nil {
+		_ = c.storeFailed(w.ctx, w.method, key, w.op, err)
+	}
+}
+
+// writeBehindLoop performs the queued writes until the interceptor
+// is closed, then drains the queue.
+func (c *Interceptor) writeBehindLoop() {
+	q := c.writeBehind
+	for {
+		select {
+		case <-q.wake:
+			_ = c.drainWrites(context.Background())
+		case <-c.ctx.Done():
+			q.close()
+			_ = c.drainWrites(context.Background())
+			return
+		}
+	}
+}
+
+// Flush performs the writes queued by the write-behind and waits for the
+// write in flight, if any, so that the responses cached before the call
+// are in the store, or until the context is done.
+// It is a no-op without WithWriteBehind.
+func (c *Interceptor) Flush(ctx context.Context) error {
+	if c.writeBehind == nil {
+		return nil
+	}
+
+	if err := c.drainWrites(ctx); err != nil {
+		return fmt.Errorf("flush write-behind queue: %w", err)
+	}
+
+	return nil
+}
+
+// drainWrites performs the queued writes until the queue is empty,
+// or the context is done.
+func (c *Interceptor) drainWrites(ctx context.Context) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		key, w, ok := c.writeBehind.pop()
+		if !ok {
+			return nil
+		}
+		c.perform(key, w)
+		c.writeBehind.done()
+	}
+}
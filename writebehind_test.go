@@ -0,0 +1,160 @@
+package gcache
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterceptor_WriteBehind(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("detached, coalesced and drained on close", func(t *testing.T) {
+		store := newGatedStore()
+		icptr := NewInterceptor(WithStoreV2(store), WithWriteBehind(10))
+
+		icptr.save(canceled, "/svc/M", "block", Entry{})
+		<-store.blocked
+
+		icptr.save(canceled, "/svc/M", "a", Entry{Value: []byte("1")})
+		icptr.save(canceled, "/svc/M", "b", Entry{Value: []byte("2")})
+		icptr.save(canceled, "/svc/M", "a", Entry{Value: []byte("3")})
+		icptr.remove(canceled, "/svc/M", "c")
+
+		close(store.gate)
+		require.NoError(t, icptr.Close(context.Background()))
+
+		assert.Equal(t, []string{"save block=", "save a=3", "save b=2", "delete c"}, store.ops)
+		assert.Empty(t, store.canceled, "writes must be detached from the call context")
+	})
+
+	t.Run("full queue, drop", func(t *testing.T) {
+		var events []Event
+		store := newGatedStore()
+		icptr := NewInterceptor(WithStoreV2(store), WithWriteBehind(1),
+			WithObserver(ObserverFunc(func(_ context.Context, ev Event) { events = append(events, ev) })))
+
+		icptr.save(context.Background(), "/svc/M", "block", Entry{})
+		<-store.blocked
+
+		icptr.save(context.Background(), "/svc/M", "a", Entry{})
+		icptr.save(context.Background(), "/svc/M", "b", Entry{})
+
+		close(store.gate)
+		require.NoError(t, icptr.Close(context.Background()))
+
+		assert.Equal(t, []string{"save block=", "save a="}, store.ops)
+		assert.Equal(t, []Event{{Kind: EventWriteDropped, Method: "/svc/M", Key: "b", Op: "save"}}, events)
+	})
+
+	t.Run("full queue, flush", func(t *testing.T) {
+		store := newGatedStore()
+		icptr := NewInterceptor(WithStoreV2(store), WithWriteBehind(1), WithFullQueuePolicy(FlushOnFullQueue))
+
+		icptr.save(context.Background(), "/svc/M", "block", Entry{})
+		<-store.blocked
+
+		icptr.save(context.Background(), "/svc/M", "a", Entry{})
+		icptr.save(context.Background(), "/svc/M", "b", Entry{})
+		assert.Equal(t, []string{"save b="}, store.snapshot(), "must be written inline")
+
+		close(store.gate)
+		require.NoError(t, icptr.Close(context.Background()))
+		assert.Equal(t, []string{"save b=", "save block=", "save a="}, store.ops)
+	})
+
+	t.Run("flush", func(t *testing.T) {
+		store := newGatedStore()
+		icptr := NewInterceptor(WithStoreV2(store), WithWriteBehind(10))
+		t.Cleanup(func() { _ = icptr.Close(context.Background()) })
+
+		icptr.save(context.Background(), "/svc/M", "block", Entry{})
+		<-store.blocked
+		icptr.save(context.Background(), "/svc/M", "a", Entry{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.ErrorIs(t, icptr.Flush(ctx), context.Canceled)
+
+		close(store.gate)
+		require.NoError(t, icptr.Flush(context.Background()))
+		assert.Equal(t, []string{"save block=", "save a="}, store.snapshot())
+	})
+
+	t.Run("after close", func(t *testing.T) {
+		store := newGatedStore()
+		icptr := NewInterceptor(WithStoreV2(store), WithWriteBehind(1))
+		require.NoError(t, icptr.Close(context.Background()))
+
+		icptr.save(context.Background(), "/svc/M", "a", Entry{})
+		assert.Equal(t, []string{"save a="}, store.ops, "must be written inline")
+	})
+}
+
+func TestWriteBehind_Discard(t *testing.T) {
+	q := newWriteBehind(10, DropOnFullQueue)
+	q.push("/svc/A{01}", pendingWrite{op: "save"})
+	q.push("/svc/B{01}", pendingWrite{op: "save", entry: Entry{Tags: []string{"order:1"}}})
+	q.push("/svc/B{02}", pendingWrite{op: "save", entry: Entry{Tags: []string{"order:2"}}})
+	q.push("/svc/C{01}", pendingWrite{op: "save"})
+
+	q.discard(Invalidation{Keys: []string{"/svc/C{01}"}, Prefixes: []string{"/svc/A{"}, Tags: []string{"order:2"}})
+
+	assert.Equal(t, []string{"/svc/B{01}"}, q.order)
+	assert.Len(t, q.pending, 1)
+
+	q.discard(Invalidation{Generation: 1})
+	assert.Empty(t, q.order, "purge must discard everything")
+	assert.Empty(t, q.pending)
+}
+
+// gatedStore records the writes, blocking the write of
+// the "block" key until the gate is closed.
+type gatedStore struct {
+	gate    chan struct{}
+	blocked chan struct{} // closed when the write of the "block" key is blocked
+
+	mu       sync.Mutex
+	ops      []string
+	canceled []string
+}
+
+func newGatedStore() *gatedStore {
+	return &gatedStore{gate: make(chan struct{}), blocked: make(chan struct{})}
+}
+
+func (s *gatedStore) Load(context.Context, string) (Entry, error) { return Entry{}, ErrNotFound }
+
+func (s *gatedStore) Save(ctx context.Context, key string, e Entry) error {
+	if key == "block" {
+		close(s.blocked)
+		<-s.gate
+	}
+	return s.record(ctx, "save "+key+"="+string(e.Value))
+}
+
+func (s *gatedStore) Delete(ctx context.Context, key string) error {
+	return s.record(ctx, "delete "+key)
+}
+
+func (s *gatedStore) record(ctx context.Context, op string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ops = append(s.ops, op)
+	if ctx.Err() != nil {
+		s.canceled = append(s.canceled, op)
+	}
+
+	return nil
+}
+
+func (s *gatedStore) snapshot() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.ops...)
+}